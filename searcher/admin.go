@@ -0,0 +1,134 @@
+package main
+
+/*
+
+  We provide a small admin API (all under /admin/) which allows operators
+  to control the indexer without restarting the searcher:
+
+    POST /admin/index                 : trigger an immediate index pass
+    POST /admin/reindex?path=<path>   : reindex a file or directory
+    POST /admin/remove?path=<path>    : remove a file or directory from
+                                        the index (and stop indexing it
+                                        until it is reindexed)
+    GET  /admin/status                : the indexer's status (as json)
+    GET  /admin/config                : the effective configuration (as
+                                        json, with secrets redacted)
+
+  (see also: /admin/backup and /admin/backups in backup.go,
+  /admin/stats in stats.go and /admin/export in exportImport.go)
+
+  Every request must be authenticated using one of the bearer tokens listed
+  in the `Admin.Tokens` configuration. If no tokens are configured, the
+  admin API is disabled.
+
+*/
+
+import (
+  "strings"
+  "net/http"
+  "crypto/subtle"
+  "encoding/json"
+)
+
+// Is this request authorized by one of the configured bearer tokens?
+//
+func hasValidBearerToken(r *http.Request, someTokens []string) bool {
+  authHeader := r.Header.Get("Authorization")
+  if !strings.HasPrefix(authHeader, "Bearer ") { return false }
+  requestToken := []byte(strings.TrimPrefix(authHeader, "Bearer "))
+
+  isValid := false
+  for _, aToken := range someTokens {
+    if len(aToken) < 1 { continue }
+    if subtle.ConstantTimeCompare(requestToken, []byte(aToken)) == 1 {
+      isValid = true
+    }
+  }
+  return isValid
+}
+
+// Wrap an admin handler so that it is only run for authenticated requests
+// using the allowed method.
+//
+func adminHandler(method string, handler http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    adminTokens := getConfigAStr("Admin.Tokens", []string{})
+    if len(adminTokens) < 1 {
+      http.NotFound(w, r)
+      return
+    }
+    if !hasValidBearerToken(r, adminTokens) {
+      WebserverLogf("admin: unauthorized request for [%s]", r.URL.Path)
+      w.Header().Set("WWW-Authenticate", `Bearer realm="searcher-admin"`)
+      http.Error(w, "unauthorized", http.StatusUnauthorized)
+      return
+    }
+    if r.Method != method {
+      w.Header().Set("Allow", method)
+      http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+      return
+    }
+    WebserverLogf("admin: %s [%s]", r.Method, r.URL)
+    handler(w, r)
+  }
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, someData interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(statusCode)
+  err := json.NewEncoder(w).Encode(someData)
+  WebserverMaybeError("could not encode json response", err)
+}
+
+func writeJsonMessage(w http.ResponseWriter, statusCode int, aMessage string) {
+  writeJson(w, statusCode, map[string]string{ "message": aMessage })
+}
+
+func adminIndexHandler(w http.ResponseWriter, r *http.Request) {
+  requestIndexPass()
+  writeJsonMessage(w, http.StatusAccepted, "index pass requested")
+}
+
+// Queue an indexer task for the path given in the request's query.
+//
+func adminPathTaskHandler(aKind string) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    aPath := r.URL.Query().Get("path")
+    if len(aPath) < 1 {
+      writeJsonMessage(w, http.StatusBadRequest, "no path supplied")
+      return
+    }
+    if !isInHtmlDirs(aPath) {
+      writeJsonMessage(w, http.StatusBadRequest,
+        "path ["+aPath+"] is not inside any of the HtmlDirs")
+      return
+    }
+    if err := queueIndexerTask(aKind, aPath); err != nil {
+      writeJsonMessage(w, http.StatusServiceUnavailable, err.Error())
+      return
+    }
+    writeJsonMessage(w, http.StatusAccepted, aKind+" ["+aPath+"] queued")
+  }
+}
+
+func adminStatusHandler(w http.ResponseWriter, r *http.Request) {
+  writeJson(w, http.StatusOK, getIndexerStatus())
+}
+
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "application/json")
+  w.Write([]byte(getRedactedConfig()))
+}
+
+func registerAdminHandlers(mux *http.ServeMux) {
+  mux.HandleFunc("/admin/index",
+    adminHandler(http.MethodPost, adminIndexHandler))
+  mux.HandleFunc("/admin/reindex",
+    adminHandler(http.MethodPost, adminPathTaskHandler(reindexPathTask)))
+  mux.HandleFunc("/admin/remove",
+    adminHandler(http.MethodPost, adminPathTaskHandler(removePathTask)))
+  mux.HandleFunc("/admin/status",
+    adminHandler(http.MethodGet, adminStatusHandler))
+  mux.HandleFunc("/admin/config",
+    adminHandler(http.MethodGet, adminConfigHandler))
+}
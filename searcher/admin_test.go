@@ -0,0 +1,109 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "net/http"
+  "database/sql"
+  "path/filepath"
+  "net/http/httptest"
+)
+
+func TestAdminHandler(t *testing.T) {
+  okHandler := adminHandler(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+    writeJsonMessage(w, http.StatusOK, "done")
+  })
+  someTests := []struct {
+    name       string
+    tokens     string
+    method     string
+    authHeader string
+    wantStatus int
+  }{
+    { "no tokens configured", `[]`,                 "POST", "Bearer secret", http.StatusNotFound },
+    { "empty token",          `[""]`,               "POST", "Bearer ",       http.StatusUnauthorized },
+    { "no token",             `["secret"]`,         "POST", "",              http.StatusUnauthorized },
+    { "basic auth",           `["secret"]`,         "POST", "Basic c2VjcmV0", http.StatusUnauthorized },
+    { "wrong token",          `["secret"]`,         "POST", "Bearer secreT", http.StatusUnauthorized },
+    { "token prefix",         `["secret"]`,         "POST", "Bearer secre",  http.StatusUnauthorized },
+    { "wrong method",         `["secret"]`,         "GET",  "Bearer secret", http.StatusMethodNotAllowed },
+    { "valid token",          `["secret"]`,         "POST", "Bearer secret", http.StatusOK },
+    { "second token",         `["other","secret"]`, "POST", "Bearer secret", http.StatusOK },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      setTestConfig(t, "Admin.Tokens="+aTest.tokens)
+      aRequest := httptest.NewRequest(aTest.method, "/admin/index", nil)
+      if aTest.authHeader != "" { aRequest.Header.Set("Authorization", aTest.authHeader) }
+      aRecorder := httptest.NewRecorder()
+      okHandler(aRecorder, aRequest)
+      if aRecorder.Code != aTest.wantStatus {
+        t.Errorf("got status %d, want %d", aRecorder.Code, aTest.wantStatus)
+      }
+      if aRecorder.Code == http.StatusUnauthorized &&
+        !strings.HasPrefix(aRecorder.Header().Get("WWW-Authenticate"), "Bearer") {
+        t.Errorf("no bearer challenge: %q", aRecorder.Header().Get("WWW-Authenticate"))
+      }
+    })
+  }
+}
+
+// The paths of all of the (indexed) documents.
+//
+func documentPaths(t *testing.T, searchDB *sql.DB) string {
+  t.Helper()
+  rows, err := searchDB.Query("select docPath from documents order by docPath")
+  if err != nil { t.Fatalf("could not select the documents: %s", err) }
+  defer rows.Close()
+  somePaths := []string{}
+  for rows.Next() {
+    var aPath string
+    if err := rows.Scan(&aPath); err != nil { t.Fatalf("could not scan: %s", err) }
+    somePaths = append(somePaths, filepath.ToSlash(aPath))
+  }
+  return strings.Join(somePaths, " ")
+}
+
+// A removed path stays removed until it is reindexed.
+//
+func TestRemovedPathsAreNotReindexed(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  htmlDir  := t.TempDir()
+  writeTestFiles(t, htmlDir, map[string]string{
+    "a.html"     : "<title>A</title>",
+    "old/b.html" : "<title>B</title>",
+    "old/c.html" : "<title>C</title>",
+  })
+  setTestConfig(t, "HtmlDirs="+htmlDir)
+  trimmedPaths := func() string {
+    return strings.Replace(documentPaths(t, searchDB), filepath.ToSlash(htmlDir)+"/", "", -1)
+  }
+
+  someSteps := []struct {
+    name string
+    step func()
+    want string
+  }{
+    { "index",           func() {},
+      "a.html old/b.html old/c.html" },
+    { "remove a file",   func() { removePath(searchDB, filepath.Join(htmlDir, "a.html")) },
+      "old/b.html old/c.html" },
+    { "remove a directory", func() { removePath(searchDB, filepath.Join(htmlDir, "old")) },
+      "" },
+    { "reindex a file in a removed directory",
+      func() { reindexPath(searchDB, filepath.Join(htmlDir, "old", "b.html")) },
+      "" },
+    { "reindex the directory",
+      func() { reindexPath(searchDB, filepath.Join(htmlDir, "old")) },
+      "old/b.html old/c.html" },
+    { "reindex the file", func() { reindexPath(searchDB, filepath.Join(htmlDir, "a.html")) },
+      "a.html old/b.html old/c.html" },
+  }
+  for _, aStep := range someSteps {
+    aStep.step()
+    lookForNewFiles(searchDB)
+    if got := trimmedPaths(); got != aStep.want {
+      t.Errorf("%s: got documents [%s], want [%s]", aStep.name, got, aStep.want)
+    }
+  }
+}
@@ -0,0 +1,513 @@
+package main
+
+/*
+
+  We (optionally) record every search, and every click on a search result,
+  in a separate analytics database (`Analytics.DatabasePath`), so that we
+  can find out which content our searchers are missing.
+
+  For each search we record its (normalized) query, how many results were
+  found and how long the search took (as well as the user, if
+  `Analytics.RecordUsers` is true).
+
+  Result links point at the /click endpoint:
+
+    GET /click?doc=<filePath>&q=<query>&pos=<position>
+
+  which records the click (see also: popularity.go) and then redirects to
+  the document's url. Since the url is looked up from the (indexed)
+  document, the /click endpoint can not be used to redirect searchers to
+  arbitrary sites. Repeated clicks by the same client (user, or remote
+  address) on the same result of the same query are only recorded once
+  every clickDedupeWindow.
+
+  Events are recorded by a single analytics goroutine (see: runAnalytics),
+  so that searches are never slowed down by the analytics database. If the
+  analytics goroutine falls behind, events are dropped (and logged). The
+  same goroutine counts (batches of) clicks in the search database's
+  docClicks table, so that clicks never wait on the search writer. On
+  shutdown, it only stops once the webServer has finished (so the events
+  of any in-flight requests are still recorded).
+
+  Reports (top, zero-result and slowest queries) are available from:
+
+    GET /admin/analytics?days=<days>&limit=<limit>
+
+  (using the admin API's bearer tokens) or the command:
+
+    searcher analytics report [days]
+
+*/
+
+import (
+  "fmt"
+  "net"
+  "sync"
+  "time"
+  "strings"
+  "strconv"
+  "net/url"
+  "net/http"
+  "database/sql"
+)
+
+const (
+  searchEvent = "search"
+  clickEvent  = "click"
+)
+
+type analyticsEvent struct {
+  kind       string
+  at         time.Time
+  query      string
+  user       string
+  numResults int
+  latency    time.Duration
+  docPath    string
+  position   int
+}
+
+var analyticsEvents chan analyticsEvent = make(chan analyticsEvent, 1000)
+
+func isAnalyticsEnabled() bool {
+  return getConfigBool("Analytics.Enabled", true)
+}
+
+// Normalize a query (so that "Foo  bar" and "foo bar" are counted as the
+// same query).
+//
+func normalizeQuery(aQuery string) string {
+  return strings.Join(strings.Fields(strings.ToLower(aQuery)), " ")
+}
+
+func analyticsUser(theUser *searchUser) string {
+  if theUser == nil || !getConfigBool("Analytics.RecordUsers", false) {
+    return ""
+  }
+  return theUser.name
+}
+
+// (clicks are also queued when only popularity ranking is enabled)
+//
+func queueAnalyticsEvent(anEvent analyticsEvent) {
+  if anEvent.kind == clickEvent {
+    if !isClickTrackingEnabled() { return }
+  } else if !isAnalyticsEnabled() { return }
+  select {
+    case analyticsEvents <- anEvent :
+    default :
+      WebserverLogf("analytics: dropped %s event (the queue is full)", anEvent.kind)
+  }
+}
+
+func recordSearch(
+  aQuery string, theUser *searchUser, numResults int, latency time.Duration,
+) {
+  queueAnalyticsEvent(analyticsEvent{
+    kind       : searchEvent,
+    at         : time.Now(),
+    query      : normalizeQuery(aQuery),
+    user       : analyticsUser(theUser),
+    numResults : numResults,
+    latency    : latency,
+  })
+}
+
+func recordClick(aQuery string, theUser *searchUser, docPath string, position int) {
+  queueAnalyticsEvent(analyticsEvent{
+    kind     : clickEvent,
+    at       : time.Now(),
+    query    : normalizeQuery(aQuery),
+    user     : analyticsUser(theUser),
+    docPath  : docPath,
+    position : position,
+  })
+}
+
+// Open (and if need be create) the analytics database.
+//
+func openAnalyticsDatabase(aPath string) (*sql.DB, error) {
+  analyticsDB, err := sql.Open("sqlite3", aPath)
+  if err != nil { return nil, err }
+  _, err = analyticsDB.Exec(`
+    create table if not exists searches (
+      searchedAt int,
+      query      text,
+      user       text,
+      numResults int,
+      latencyMs  real
+    );
+    create index if not exists searchesAt on searches (searchedAt);
+    create table if not exists clicks (
+      clickedAt  int,
+      query      text,
+      user       text,
+      docPath    text,
+      position   int
+    );
+    create index if not exists clicksAt on clicks (clickedAt);
+  `)
+  if err != nil {
+    analyticsDB.Close()
+    return nil, err
+  }
+  return analyticsDB, nil
+}
+
+func writeAnalyticsEvents(analyticsDB *sql.DB, someEvents []analyticsEvent) error {
+  tx, err := analyticsDB.Begin()
+  if err != nil { return err }
+  for _, anEvent := range someEvents {
+    switch anEvent.kind {
+      case searchEvent :
+        _, err = tx.Exec(
+          "insert into searches values ( ?, ?, ?, ?, ? )",
+          anEvent.at.Unix(), anEvent.query, anEvent.user, anEvent.numResults,
+          float64(anEvent.latency) / float64(time.Millisecond),
+        )
+      case clickEvent :
+        _, err = tx.Exec(
+          "insert into clicks values ( ?, ?, ?, ?, ? )",
+          anEvent.at.Unix(), anEvent.query, anEvent.user, anEvent.docPath,
+          anEvent.position,
+        )
+    }
+    if err != nil {
+      tx.Rollback()
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+// Remove any events older than `Analytics.RetentionDays` (if not zero).
+//
+func pruneAnalytics(analyticsDB *sql.DB) error {
+  retentionDays := getConfigInt("Analytics.RetentionDays", 90)
+  if retentionDays < 1 { return nil }
+  before := time.Now().AddDate(0, 0, -int(retentionDays)).Unix()
+  if _, err := analyticsDB.Exec(
+    "delete from searches where searchedAt < ?", before,
+  ); err != nil { return err }
+  _, err := analyticsDB.Exec("delete from clicks where clickedAt < ?", before)
+  return err
+}
+
+// Record the queued analytics events (until the webServer has finished,
+// after which no more events can be queued).
+//
+// The analytics database is (re)opened whenever `Analytics.DatabasePath`
+// changes.
+//
+func runAnalytics(searchDB *sql.DB, webServerFinished <-chan struct{}) {
+  var analyticsDB *sql.DB
+  analyticsPath := ""
+  defer func() {
+    if analyticsDB != nil { analyticsDB.Close() }
+  }()
+
+  pruneTicker := time.NewTicker(time.Hour)
+  defer pruneTicker.Stop()
+
+  isFinished := false
+  for {
+    someEvents := []analyticsEvent{}
+    select {
+      case <-webServerFinished :
+        //
+        // record any events which are still queued
+        //
+        for 0 < len(analyticsEvents) {
+          someEvents = append(someEvents, <-analyticsEvents)
+        }
+        isFinished = true
+      case anEvent := <-analyticsEvents :
+        someEvents = append(someEvents, anEvent)
+        for 0 < len(analyticsEvents) && len(someEvents) < 100 {
+          someEvents = append(someEvents, <-analyticsEvents)
+        }
+      case <-pruneTicker.C :
+    }
+
+    newPath := getConfigStr("Analytics.DatabasePath", "data/analytics.db")
+    if analyticsDB == nil || newPath != analyticsPath {
+      if analyticsDB != nil { analyticsDB.Close() }
+      var err error
+      analyticsPath = newPath
+      analyticsDB, err = openAnalyticsDatabase(analyticsPath)
+      WebserverMaybeError("could not open the analytics database "+analyticsPath, err)
+      if err == nil {
+        WebserverMaybeError("could not prune analytics", pruneAnalytics(analyticsDB))
+      }
+    }
+
+    if 0 < len(someEvents) {
+      WebserverMaybeError(
+        "could not count clicks", recordDocumentClicks(searchDB, someEvents),
+      )
+    }
+
+    if analyticsDB != nil && isAnalyticsEnabled() {
+      if 0 < len(someEvents) {
+        WebserverMaybeError(
+          "could not record analytics",
+          writeAnalyticsEvents(analyticsDB, someEvents),
+        )
+      } else {
+        WebserverMaybeError("could not prune analytics", pruneAnalytics(analyticsDB))
+      }
+    }
+
+    if isFinished { return }
+  }
+}
+
+/////////////////////////////
+// Clicks
+
+const (
+  clickDedupeWindow = 10 * time.Minute
+  maxRecentClicks   = 100000
+)
+
+var recentClicks = struct {
+  sync.Mutex
+  clickedAt map[string]time.Time
+  prunedAt  time.Time
+}{ clickedAt: map[string]time.Time{} }
+
+// The client (for deduplicating clicks) making this request: the user if
+// authenticated, otherwise the remote address.
+//
+func clickClient(r *http.Request, theUser *searchUser) string {
+  if theUser != nil { return "user:" + theUser.name }
+  if aHost, _, err := net.SplitHostPort(r.RemoteAddr); err == nil { return aHost }
+  return r.RemoteAddr
+}
+
+// Has this client already clicked on this result (of this query) within
+// the clickDedupeWindow? (If not, the click is remembered.)
+//
+func isRepeatedClick(
+  aClient string, docPath string, aQuery string, now time.Time,
+) bool {
+  recentClicks.Lock()
+  defer recentClicks.Unlock()
+
+  if clickDedupeWindow < now.Sub(recentClicks.prunedAt) ||
+    maxRecentClicks <= len(recentClicks.clickedAt) {
+    for aKey, clickedAt := range recentClicks.clickedAt {
+      if clickDedupeWindow <= now.Sub(clickedAt) {
+        delete(recentClicks.clickedAt, aKey)
+      }
+    }
+    if maxRecentClicks <= len(recentClicks.clickedAt) {
+      // (too many clients, forget them all rather than grow without bound)
+      recentClicks.clickedAt = map[string]time.Time{}
+    }
+    recentClicks.prunedAt = now
+  }
+
+  aKey := aClient + "\x00" + docPath + "\x00" + normalizeQuery(aQuery)
+  if clickedAt, isKnown := recentClicks.clickedAt[aKey]; isKnown &&
+    now.Sub(clickedAt) < clickDedupeWindow {
+    return true
+  }
+  recentClicks.clickedAt[aKey] = now
+  return false
+}
+
+// The url of a (file, pushed or crawled) document, or "" if it is not (or
+// no longer) indexed.
+//
+func documentUrl(searchDB *sql.DB, docPath string) string {
+  var source string
+  var docUrl sql.NullString
+  err := searchDB.QueryRow(
+    "select source, url from documents where docPath = ?", docPath,
+  ).Scan(&source, &docUrl)
+  if err != nil { return "" }
+  if 0 < len(docUrl.String) { return docUrl.String }
+  theConfig := getConfigSnapshot()
+  return fileUrl(docPath, theConfig.HtmlDirs, theConfig.UrlBase)
+}
+
+// The link (through the /click endpoint) for a search result.
+//
+func clickLink(docPath string, aQuery string, position int) string {
+  return "/click?" + url.Values{
+    "doc" : { docPath },
+    "q"   : { aQuery },
+    "pos" : { strconv.Itoa(position) },
+  }.Encode()
+}
+
+func clickHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    docPath  := r.URL.Query().Get("doc")
+    theUser  := getSearchUser(r)
+    position, _ := strconv.Atoi(r.URL.Query().Get("pos"))
+
+    docUrl := ""
+    if isPathAllowed(docPath, allowedPathPrefixes(theUser)) {
+      docUrl = documentUrl(searchDB, docPath)
+    }
+    if docUrl == "" {
+      http.NotFound(w, r)
+      return
+    }
+    aQuery := r.URL.Query().Get("q")
+    if !isRepeatedClick(clickClient(r, theUser), docPath, aQuery, time.Now()) {
+      recordClick(aQuery, theUser, docPath, position)
+    }
+    http.Redirect(w, r, docUrl, http.StatusFound)
+  }
+}
+
+/////////////////////////////
+// Reports
+
+type analyticsQueryStats struct {
+  Query        string  `json:"query"`
+  Searches     int64   `json:"searches"`
+  AvgResults   float64 `json:"avgResults"`
+  Clicks       int64   `json:"clicks"`
+  AvgLatencyMs float64 `json:"avgLatencyMs"`
+  MaxLatencyMs float64 `json:"maxLatencyMs"`
+}
+
+type analyticsReport struct {
+  Since             time.Time             `json:"since"`
+  Searches          int64                 `json:"searches"`
+  ZeroResults       int64                 `json:"zeroResults"`
+  Clicks            int64                 `json:"clicks"`
+  TopQueries        []analyticsQueryStats `json:"topQueries"`
+  ZeroResultQueries []analyticsQueryStats `json:"zeroResultQueries"`
+  SlowestQueries    []analyticsQueryStats `json:"slowestQueries"`
+}
+
+func queryAnalyticsStats(
+  analyticsDB *sql.DB, whereSql string, orderSql string, since int64, limit int,
+) ([]analyticsQueryStats, error) {
+  rows, err := analyticsDB.Query(`
+    select query, count(*), avg(numResults),
+        ( select count(*) from clicks
+            where clicks.query = searches.query and clickedAt >= ? ),
+        avg(latencyMs), max(latencyMs)
+      from searches
+      where searchedAt >= ? `+whereSql+`
+      group by query
+      order by `+orderSql+`
+      limit ?
+  `, since, since, limit)
+  if err != nil { return nil, err }
+  defer rows.Close()
+
+  someStats := []analyticsQueryStats{}
+  for rows.Next() {
+    var someStat analyticsQueryStats
+    err = rows.Scan(
+      &someStat.Query, &someStat.Searches, &someStat.AvgResults,
+      &someStat.Clicks, &someStat.AvgLatencyMs, &someStat.MaxLatencyMs,
+    )
+    if err != nil { return nil, err }
+    someStats = append(someStats, someStat)
+  }
+  return someStats, rows.Err()
+}
+
+func buildAnalyticsReport(
+  analyticsDB *sql.DB, since time.Time, limit int,
+) (analyticsReport, error) {
+  theReport := analyticsReport{ Since: since }
+  sinceUnix := since.Unix()
+
+  err := analyticsDB.QueryRow(`
+    select count(*), count(case when numResults = 0 then 1 end)
+      from searches where searchedAt >= ?
+  `, sinceUnix).Scan(&theReport.Searches, &theReport.ZeroResults)
+  if err != nil { return theReport, err }
+  err = analyticsDB.QueryRow(
+    "select count(*) from clicks where clickedAt >= ?", sinceUnix,
+  ).Scan(&theReport.Clicks)
+  if err != nil { return theReport, err }
+
+  theReport.TopQueries, err = queryAnalyticsStats(
+    analyticsDB, "", "count(*) desc", sinceUnix, limit,
+  )
+  if err != nil { return theReport, err }
+  theReport.ZeroResultQueries, err = queryAnalyticsStats(
+    analyticsDB, "and numResults = 0", "count(*) desc", sinceUnix, limit,
+  )
+  if err != nil { return theReport, err }
+  theReport.SlowestQueries, err = queryAnalyticsStats(
+    analyticsDB, "", "max(latencyMs) desc", sinceUnix, limit,
+  )
+  return theReport, err
+}
+
+// Build a report covering the last someDays days.
+//
+func getAnalyticsReport(someDays int, limit int) (analyticsReport, error) {
+  analyticsDB, err := openAnalyticsDatabase(
+    getConfigStr("Analytics.DatabasePath", "data/analytics.db"),
+  )
+  if err != nil { return analyticsReport{}, err }
+  defer analyticsDB.Close()
+  return buildAnalyticsReport(
+    analyticsDB, time.Now().AddDate(0, 0, -someDays), limit,
+  )
+}
+
+func adminAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+  someDays, err := strconv.Atoi(r.URL.Query().Get("days"))
+  if err != nil || someDays < 1 { someDays = 7 }
+  limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+  if err != nil || limit < 1 { limit = 20 }
+
+  theReport, err := getAnalyticsReport(someDays, limit)
+  if err != nil {
+    WebserverMaybeError("could not build the analytics report", err)
+    writeJsonMessage(w, http.StatusInternalServerError, "could not build the analytics report")
+    return
+  }
+  writeJson(w, http.StatusOK, theReport)
+}
+
+func printAnalyticsQueryStats(aTitle string, someStats []analyticsQueryStats) {
+  fmt.Println("")
+  fmt.Println(aTitle)
+  fmt.Printf("  %8s %8s %8s %10s %10s  %s\n",
+    "searches", "results", "clicks", "avg ms", "max ms", "query",
+  )
+  for _, someStat := range someStats {
+    fmt.Printf("  %8d %8.1f %8d %10.1f %10.1f  %s\n",
+      someStat.Searches, someStat.AvgResults, someStat.Clicks,
+      someStat.AvgLatencyMs, someStat.MaxLatencyMs, someStat.Query,
+    )
+  }
+}
+
+// Print a report covering the last someDays days.
+//
+func analyticsReportCommand(someDays int) int {
+  theReport, err := getAnalyticsReport(someDays, 20)
+  if err != nil {
+    fmt.Printf("ERROR: could not build the analytics report: %s\n", err)
+    return 1
+  }
+  fmt.Printf("Searches since %s\n", theReport.Since.Format(time.RFC3339))
+  fmt.Printf("  searches: %d  zero results: %d  clicks: %d\n",
+    theReport.Searches, theReport.ZeroResults, theReport.Clicks,
+  )
+  printAnalyticsQueryStats("Top queries:", theReport.TopQueries)
+  printAnalyticsQueryStats("Zero result queries:", theReport.ZeroResultQueries)
+  printAnalyticsQueryStats("Slowest queries:", theReport.SlowestQueries)
+  return 0
+}
+
+func registerAnalyticsHandlers(mux *http.ServeMux, searchDB *sql.DB) {
+  mux.HandleFunc("/click", authHandler(clickHandler(searchDB)))
+  mux.HandleFunc("/admin/analytics",
+    adminHandler(http.MethodGet, adminAnalyticsHandler))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+  "fmt"
+  "time"
+  "strings"
+  "testing"
+  "net/http"
+  "path/filepath"
+  "net/http/httptest"
+)
+
+func TestIsRepeatedClick(t *testing.T) {
+  now := time.Now()
+  someTests := []struct {
+    name    string
+    client  string
+    docPath string
+    query   string
+    at      time.Time
+    want    bool
+  }{
+    { "first click",            "10.0.0.1", "files/a.html", "foo",   now, false },
+    { "same click",             "10.0.0.1", "files/a.html", "foo",   now.Add(time.Minute), true },
+    { "same normalized query",  "10.0.0.1", "files/a.html", " FOO ", now.Add(time.Minute), true },
+    { "another query",          "10.0.0.1", "files/a.html", "bar",   now.Add(time.Minute), false },
+    { "another document",       "10.0.0.1", "files/b.html", "foo",   now.Add(time.Minute), false },
+    { "another client",         "10.0.0.2", "files/a.html", "foo",   now.Add(time.Minute), false },
+    { "after the window",       "10.0.0.1", "files/a.html", "foo",
+      now.Add(clickDedupeWindow + time.Minute), false },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      got := isRepeatedClick(aTest.client, aTest.docPath, aTest.query, aTest.at)
+      if got != aTest.want { t.Errorf("got %v, want %v", got, aTest.want) }
+    })
+  }
+}
+
+func TestClickClient(t *testing.T) {
+  aRequest := httptest.NewRequest("GET", "/click", nil)
+  aRequest.RemoteAddr = "192.0.2.1:1234"
+  if got := clickClient(aRequest, nil); got != "192.0.2.1" {
+    t.Errorf("anonymous client: got %q", got)
+  }
+  if got := clickClient(aRequest, &searchUser{ name: "alice" }); got != "user:alice" {
+    t.Errorf("authenticated client: got %q", got)
+  }
+}
+
+// Forget any analytics events queued by (other) tests.
+//
+func drainAnalyticsEvents() {
+  for 0 < len(analyticsEvents) { <-analyticsEvents }
+}
+
+func TestClickHandler(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  err := upsertDocument(searchDB, indexedDocument{
+    path : "push:b", url : "https://example.com/b", title : "Badgers",
+    body : "all about badgers", source : pushSource,
+  })
+  if err != nil { t.Fatalf("could not insert a document: %s", err) }
+  drainAnalyticsEvents()
+  aHandler := clickHandler(searchDB)
+
+  someTests := []struct {
+    name         string
+    remoteAddr   string
+    target       string
+    wantStatus   int
+    wantLocation string
+    wantEvents   int
+  }{
+    { "click", "192.0.2.7:1000", "/click?doc=push:b&q=badgers&pos=1",
+      http.StatusFound, "https://example.com/b", 1 },
+    { "repeated click", "192.0.2.7:2000", "/click?doc=push:b&q=Badgers&pos=1",
+      http.StatusFound, "https://example.com/b", 0 },
+    { "another client", "192.0.2.8:1000", "/click?doc=push:b&q=badgers&pos=1",
+      http.StatusFound, "https://example.com/b", 1 },
+    { "unknown document", "192.0.2.7:1000", "/click?doc=https://evil.example.com/&q=x",
+      http.StatusNotFound, "", 0 },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aRequest := httptest.NewRequest("GET", aTest.target, nil)
+      aRequest.RemoteAddr = aTest.remoteAddr
+      aRecorder := httptest.NewRecorder()
+      aHandler(aRecorder, aRequest)
+      if aRecorder.Code != aTest.wantStatus ||
+        aRecorder.Header().Get("Location") != aTest.wantLocation {
+        t.Errorf("got %d %q, want %d %q", aRecorder.Code,
+          aRecorder.Header().Get("Location"), aTest.wantStatus, aTest.wantLocation)
+      }
+      if numEvents := len(analyticsEvents); numEvents != aTest.wantEvents {
+        t.Errorf("queued %d click events, want %d", numEvents, aTest.wantEvents)
+      }
+      drainAnalyticsEvents()
+    })
+  }
+}
+
+// Only real searches are recorded, and every queued event is written once
+// the webServer has finished.
+//
+func TestAnalyticsReport(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  analyticsPath := filepath.Join(t.TempDir(), "analytics.db")
+  setTestConfig(t, "Analytics.DatabasePath="+analyticsPath)
+  drainAnalyticsEvents()
+
+  aHandler := searchHandler(searchDB, CreateTemplate("../config/searchForm.html"))
+  for _, aPath := range []string{ "/", "/favicon.ico", "/search/", "/search/Wombats" } {
+    aHandler(httptest.NewRecorder(), httptest.NewRequest("GET", aPath, nil))
+  }
+  if numEvents := len(analyticsEvents); numEvents != 1 {
+    t.Fatalf("queued %d search events, want 1", numEvents)
+  }
+  recordSearch("wombats",  nil, 0, 30 * time.Millisecond)
+  recordSearch("badgers",  nil, 2, 10 * time.Millisecond)
+  recordSearch("aardvark", nil, 0, 90 * time.Millisecond)
+  recordClick("badgers", nil, "push:b", 1)
+
+  webServerFinished := make(chan struct{})
+  close(webServerFinished)
+  runAnalytics(searchDB, webServerFinished)
+  if numEvents := len(analyticsEvents); numEvents != 0 {
+    t.Errorf("%d events were not recorded", numEvents)
+  }
+
+  analyticsDB, err := openAnalyticsDatabase(analyticsPath)
+  if err != nil { t.Fatalf("could not open the analytics database: %s", err) }
+  defer analyticsDB.Close()
+  theReport, err := buildAnalyticsReport(analyticsDB, time.Now().Add(-time.Hour), 10)
+  if err != nil { t.Fatalf("could not build the report: %s", err) }
+
+  if theReport.Searches != 4 || theReport.ZeroResults != 3 || theReport.Clicks != 1 {
+    t.Errorf("got %d searches, %d zero results and %d clicks, want 4, 3 and 1",
+      theReport.Searches, theReport.ZeroResults, theReport.Clicks)
+  }
+  queryNames := func(someStats []analyticsQueryStats) string {
+    someNames := []string{}
+    for _, someStat := range someStats {
+      someNames = append(someNames, fmt.Sprintf("%s:%d", someStat.Query, someStat.Searches))
+    }
+    return strings.Join(someNames, " ")
+  }
+  if len(theReport.TopQueries) != 3 || theReport.TopQueries[0].Query != "wombats" {
+    t.Errorf("got top queries [%s]", queryNames(theReport.TopQueries))
+  }
+  if got := queryNames(theReport.ZeroResultQueries); got != "wombats:2 aardvark:1" {
+    t.Errorf("got zero result queries [%s]", got)
+  }
+  if got := theReport.SlowestQueries[0].Query; got != "aardvark" {
+    t.Errorf("got slowest query [%s]", got)
+  }
+  for _, someStat := range theReport.TopQueries {
+    if someStat.Query == "badgers" && someStat.Clicks != 1 {
+      t.Errorf("got %d clicks for badgers, want 1", someStat.Clicks)
+    }
+  }
+
+  var numClicks int
+  searchDB.QueryRow(
+    "select clicks from docClicks where docPath = 'push:b' and query = 'badgers'",
+  ).Scan(&numClicks)
+  if numClicks != 1 { t.Errorf("counted %d clicks, want 1", numClicks) }
+}
@@ -0,0 +1,367 @@
+package main
+
+/*
+
+  We (optionally) authenticate searchers and restrict which documents they
+  can find.
+
+  Authentication is pluggable: each searchAuthenticator is tried (in
+  order) until one of them identifies the user:
+
+    - static bearer tokens  (`Auth.Tokens`: user -> tokens),
+
+    - HTTP basic            (`Auth.HtpasswdFile`: an htpasswd file using
+                             bcrypt hashed passwords),
+
+    - a trusted reverse     (`Auth.ProxyUserHeader` and
+      proxy header           `Auth.ProxyGroupsHeader`, only believed when
+                             the request comes from one of the
+                             `Auth.TrustedProxies`).
+
+  Users belong to any groups given by the reverse proxy, as well as the
+  groups listed in `Auth.Groups` (group -> users).
+
+  The access control list (`Auth.ACL`) maps principals to the path
+  prefixes they may search. A principal is one of:
+
+    "*"             : everyone (including anonymous searchers),
+    "authenticated" : every authenticated user,
+    "user:<name>"   : the named user,
+    "group:<name>"  : every member of the named group.
+
+  If there are no ACL rules, everyone may search everything. Otherwise a
+  searcher may ONLY find documents whose (file or pushed document) path
+  starts with one of the prefixes allowed to them. The ACL is enforced
+  inside the search query itself, so restricted documents never appear in
+  the results, their counts or their snippets.
+
+  If `Auth.Required` is true, anonymous searchers are refused.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "net"
+  "sync"
+  "bufio"
+  "strings"
+  "context"
+  "net/http"
+  "path/filepath"
+  "crypto/subtle"
+  "golang.org/x/crypto/bcrypt"
+  "github.com/tidwall/gjson"
+)
+
+type searchUser struct {
+  name   string
+  groups []string
+}
+
+type searchAuthenticator interface {
+  // Identify the user making the request. Returns nil if this
+  // authenticator can not identify the user.
+  authenticate(r *http.Request) *searchUser
+}
+
+/////////////////////////////
+// Static bearer tokens
+
+type tokenAuthenticator struct{}
+
+func (ta tokenAuthenticator) authenticate(r *http.Request) *searchUser {
+  authHeader := r.Header.Get("Authorization")
+  if !strings.HasPrefix(authHeader, "Bearer ") { return nil }
+  requestToken := []byte(strings.TrimPrefix(authHeader, "Bearer "))
+
+  var theUser *searchUser
+  getConfigVar("Auth.Tokens").ForEach(func(aName, someTokens gjson.Result) bool {
+    for _, aToken := range someTokens.Array() {
+      if len(aToken.String()) < 1 { continue }
+      if subtle.ConstantTimeCompare(requestToken, []byte(aToken.String())) == 1 {
+        theUser = &searchUser{ name: aName.String() }
+      }
+    }
+    return true
+  })
+  return theUser
+}
+
+/////////////////////////////
+// HTTP basic (htpasswd)
+//
+// We implement a lazy reloading of the htpasswd file (just like the
+// webServerTemplate) so that users can be added without restarting the
+// searcher.
+
+type htpasswdFile struct {
+  filePath  string
+  fileMTime int64
+  fileSize  int64
+  passwords map[string]string
+  update    sync.RWMutex
+}
+
+var searchHtpasswd htpasswdFile
+
+func (hf *htpasswdFile) hasHtpasswdChanged(aFilePath string) bool {
+  hf.update.RLock()
+  defer hf.update.RUnlock()
+
+  fileMTime, fileSize := fileMTimeAndSize(aFilePath)
+  return hf.filePath != aFilePath ||
+    hf.fileMTime != fileMTime || hf.fileSize != fileSize
+}
+
+func (hf *htpasswdFile) reloadHtpasswd(aFilePath string) {
+  hf.update.Lock()
+  defer hf.update.Unlock()
+
+  hf.filePath  = aFilePath
+  hf.passwords = map[string]string{}
+  hf.fileMTime, hf.fileSize = fileMTimeAndSize(aFilePath)
+
+  passwordFile, err := os.Open(aFilePath)
+  if err != nil {
+    WebserverMaybeError("could not open htpasswd file "+aFilePath, err)
+    return
+  }
+  defer passwordFile.Close()
+
+  lineScanner := bufio.NewScanner(passwordFile)
+  for lineScanner.Scan() {
+    aLine := strings.TrimSpace(lineScanner.Text())
+    if len(aLine) < 1 || strings.HasPrefix(aLine, "#") { continue }
+    nameHash := strings.SplitN(aLine, ":", 2)
+    if len(nameHash) != 2 { continue }
+    if !strings.HasPrefix(nameHash[1], "$2") {
+      WebserverLogf("htpasswd: ignoring non bcrypt password for [%s]", nameHash[0])
+      continue
+    }
+    hf.passwords[nameHash[0]] = nameHash[1]
+  }
+  WebserverLogf("htpasswd: loaded %d users from [%s]", len(hf.passwords), aFilePath)
+}
+
+func (hf *htpasswdFile) checkPassword(aFilePath, aName, aPassword string) bool {
+  if hf.hasHtpasswdChanged(aFilePath) { hf.reloadHtpasswd(aFilePath) }
+
+  hf.update.RLock()
+  passwordHash, isKnown := hf.passwords[aName]
+  hf.update.RUnlock()
+
+  if !isKnown { return false }
+  return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(aPassword)) == nil
+}
+
+type basicAuthenticator struct{}
+
+func (ba basicAuthenticator) authenticate(r *http.Request) *searchUser {
+  htpasswdPath := getConfigStr("Auth.HtpasswdFile", "")
+  if htpasswdPath == "" { return nil }
+  aName, aPassword, hasBasicAuth := r.BasicAuth()
+  if !hasBasicAuth { return nil }
+  if !searchHtpasswd.checkPassword(htpasswdPath, aName, aPassword) {
+    WebserverLogf("auth: invalid password for [%s]", aName)
+    return nil
+  }
+  return &searchUser{ name: aName }
+}
+
+/////////////////////////////
+// Trusted reverse proxy headers
+
+type proxyAuthenticator struct{}
+
+func isTrustedProxy(remoteAddr string, trustedProxies []string) bool {
+  remoteHost, _, err := net.SplitHostPort(remoteAddr)
+  if err != nil { remoteHost = remoteAddr }
+  remoteIP := net.ParseIP(remoteHost)
+  if remoteIP == nil { return false }
+  for _, aProxy := range trustedProxies {
+    if _, aNetwork, err := net.ParseCIDR(aProxy); err == nil {
+      if aNetwork.Contains(remoteIP) { return true }
+      continue
+    }
+    if proxyIP := net.ParseIP(aProxy); proxyIP != nil && proxyIP.Equal(remoteIP) {
+      return true
+    }
+  }
+  return false
+}
+
+func (pa proxyAuthenticator) authenticate(r *http.Request) *searchUser {
+  userHeader := getConfigStr("Auth.ProxyUserHeader", "")
+  if userHeader == "" { return nil }
+  aName := strings.TrimSpace(r.Header.Get(userHeader))
+  if aName == "" { return nil }
+  if !isTrustedProxy(r.RemoteAddr, getConfigAStr("Auth.TrustedProxies", []string{})) {
+    WebserverLogf("auth: ignoring %s header from untrusted [%s]", userHeader, r.RemoteAddr)
+    return nil
+  }
+  theUser := &searchUser{ name: aName }
+  if groupsHeader := getConfigStr("Auth.ProxyGroupsHeader", ""); groupsHeader != "" {
+    for _, aGroup := range strings.Split(r.Header.Get(groupsHeader), ",") {
+      if aGroup = strings.TrimSpace(aGroup); aGroup != "" {
+        theUser.groups = append(theUser.groups, aGroup)
+      }
+    }
+  }
+  return theUser
+}
+
+func isIPOrCIDRs(aValue gjson.Result) error {
+  for _, anItem := range aValue.Array() {
+    if _, _, err := net.ParseCIDR(anItem.String()); err == nil { continue }
+    if net.ParseIP(anItem.String()) == nil {
+      return fmt.Errorf("[%s] is neither an IP address nor a CIDR", anItem.String())
+    }
+  }
+  return nil
+}
+
+/////////////////////////////
+// Authentication and access control
+
+func isACL(aValue gjson.Result) error {
+  var err error
+  aValue.ForEach(func(aPrincipal, somePrefixes gjson.Result) bool {
+    thePrincipal := aPrincipal.String()
+    if thePrincipal == "*" || thePrincipal == "authenticated" { return true }
+    if strings.HasPrefix(thePrincipal, "user:") && 5 < len(thePrincipal) { return true }
+    if strings.HasPrefix(thePrincipal, "group:") && 6 < len(thePrincipal) { return true }
+    err = fmt.Errorf(
+      "principal [%s] must be *, authenticated, user:<name> or group:<name>",
+      thePrincipal,
+    )
+    return false
+  })
+  return err
+}
+
+var searchAuthenticators []searchAuthenticator = []searchAuthenticator{
+  tokenAuthenticator{},
+  basicAuthenticator{},
+  proxyAuthenticator{},
+}
+
+type searchUserKey struct{}
+
+// Identify the user (if any) making this request, adding any groups from
+// `Auth.Groups`.
+//
+func authenticateSearcher(r *http.Request) *searchUser {
+  for _, anAuthenticator := range searchAuthenticators {
+    theUser := anAuthenticator.authenticate(r)
+    if theUser == nil { continue }
+    getConfigVar("Auth.Groups").ForEach(func(aGroup, someUsers gjson.Result) bool {
+      for _, aUser := range someUsers.Array() {
+        if aUser.String() == theUser.name {
+          theUser.groups = append(theUser.groups, aGroup.String())
+        }
+      }
+      return true
+    })
+    return theUser
+  }
+  return nil
+}
+
+func getSearchUser(r *http.Request) *searchUser {
+  theUser, _ := r.Context().Value(searchUserKey{}).(*searchUser)
+  return theUser
+}
+
+// Does an ACL principal apply to this (possibly anonymous) user?
+//
+func isPrincipal(aPrincipal string, theUser *searchUser) bool {
+  if aPrincipal == "*" { return true }
+  if theUser == nil { return false }
+  if aPrincipal == "authenticated" { return true }
+  if aPrincipal == "user:"+theUser.name { return true }
+  for _, aGroup := range theUser.groups {
+    if aPrincipal == "group:"+aGroup { return true }
+  }
+  return false
+}
+
+// The path prefixes this user may search. Returns nil if the user is not
+// restricted at all (there are no ACL rules).
+//
+func allowedPathPrefixes(theUser *searchUser) []string {
+  theACL := getConfigVar("Auth.ACL")
+  if len(theACL.Map()) < 1 { return nil }
+
+  somePrefixes := []string{}
+  theACL.ForEach(func(aPrincipal, aRule gjson.Result) bool {
+    if !isPrincipal(aPrincipal.String(), theUser) { return true }
+    for _, aPrefix := range aRule.Array() {
+      somePrefixes = append(somePrefixes, cleanPathPrefix(aPrefix.String()))
+    }
+    return true
+  })
+  return somePrefixes
+}
+
+// Clean a file path prefix, so that (for example) `./files/private/`
+// matches the (cleaned) paths of the indexed files `files/private/...`.
+// Pushed document (`push:`) and url prefixes are left alone.
+//
+func cleanPathPrefix(aPrefix string) string {
+  if strings.Contains(aPrefix, ":") { return aPrefix }
+  cleanPrefix := filepath.Clean(aPrefix)
+  if cleanPrefix == "." { return aPrefix }
+  if strings.HasSuffix(filepath.ToSlash(aPrefix), "/") &&
+    !strings.HasSuffix(cleanPrefix, string(filepath.Separator)) {
+    cleanPrefix = cleanPrefix + string(filepath.Separator)
+  }
+  return cleanPrefix
+}
+
+func isPathAllowed(aPath string, somePrefixes []string) bool {
+  if somePrefixes == nil { return true }
+  cleanPath := cleanPathPrefix(aPath)
+  for _, aPrefix := range somePrefixes {
+    if strings.HasPrefix(cleanPath, cleanPathPrefix(aPrefix)) { return true }
+  }
+  return false
+}
+
+// An sql condition (and its arguments) which restricts a path column to
+// the allowed prefixes.
+//
+func aclCondition(pathColumn string, somePrefixes []string) (string, []interface{}) {
+  if somePrefixes == nil { return "1", []interface{}{} }
+  if len(somePrefixes) < 1 { return "0", []interface{}{} }
+  someConditions := []string{}
+  someArgs       := []interface{}{}
+  for _, aPrefix := range somePrefixes {
+    someConditions = append(someConditions, "substr("+pathColumn+", 1, ?) = ?")
+    someArgs       = append(someArgs, len(aPrefix), aPrefix)
+  }
+  return "(" + strings.Join(someConditions, " or ") + ")", someArgs
+}
+
+// Wrap a handler so that the searcher is authenticated (and refused if
+// authentication is required but missing).
+//
+func authHandler(next http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    theUser := authenticateSearcher(r)
+    if theUser == nil && getConfigBool("Auth.Required", false) {
+      if getConfigStr("Auth.HtpasswdFile", "") != "" {
+        w.Header().Set("WWW-Authenticate", `Basic realm="searcher"`)
+      } else {
+        w.Header().Set("WWW-Authenticate", `Bearer realm="searcher"`)
+      }
+      http.Error(w, "unauthorized", http.StatusUnauthorized)
+      return
+    }
+    if theUser != nil {
+      WebserverLogf("auth: [%s] groups %v", theUser.name, theUser.groups)
+      r = r.WithContext(context.WithValue(r.Context(), searchUserKey{}, theUser))
+    }
+    next(w, r)
+  }
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+  "github.com/tidwall/gjson"
+)
+
+func TestIsACL(t *testing.T) {
+  someTests := []struct {
+    name      string
+    acl       string
+    wantError string
+  }{
+    { "empty",         `{}`, "" },
+    { "everyone",      `{ "*" : [ "files/public/" ] }`, "" },
+    { "authenticated", `{ "authenticated" : [ "files/" ] }`, "" },
+    { "user and group",
+      `{ "user:alice" : [ "files/a/" ], "group:staff" : [ "files/s/" ] }`, "" },
+    { "bare name",     `{ "alice" : [ "files/" ] }`, "principal [alice]" },
+    { "empty user",    `{ "user:" : [ "files/" ] }`, "principal [user:]" },
+    { "empty group",   `{ "group:" : [ "files/" ] }`, "principal [group:]" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      err := isACL(gjson.Parse(aTest.acl))
+      if aTest.wantError == "" {
+        if err != nil { t.Errorf("unexpected error: %s", err) }
+        return
+      }
+      if err == nil || !strings.Contains(err.Error(), aTest.wantError) {
+        t.Errorf("expected an error containing [%s], got: %v", aTest.wantError, err)
+      }
+    })
+  }
+}
+
+func TestAclCondition(t *testing.T) {
+  someTests := []struct {
+    name          string
+    somePrefixes  []string
+    wantCondition string
+    wantArgs      []interface{}
+  }{
+    { "unrestricted", nil, "1", []interface{}{} },
+    { "nothing allowed", []string{}, "0", []interface{}{} },
+    { "one prefix", []string{ "files/a/" },
+      "(substr(docPath, 1, ?) = ?)", []interface{}{ 8, "files/a/" } },
+    { "two prefixes", []string{ "files/a/", "push:" },
+      "(substr(docPath, 1, ?) = ? or substr(docPath, 1, ?) = ?)",
+      []interface{}{ 8, "files/a/", 5, "push:" } },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aCondition, someArgs := aclCondition("docPath", aTest.somePrefixes)
+      if aCondition != aTest.wantCondition {
+        t.Errorf("got condition %q, want %q", aCondition, aTest.wantCondition)
+      }
+      if !reflect.DeepEqual(someArgs, aTest.wantArgs) {
+        t.Errorf("got args %v, want %v", someArgs, aTest.wantArgs)
+      }
+    })
+  }
+}
+
+func TestIsPathAllowed(t *testing.T) {
+  someTests := []struct {
+    aPath        string
+    somePrefixes []string
+    want         bool
+  }{
+    { "files/a/x.html", nil, true },
+    { "files/a/x.html", []string{}, false },
+    { "files/a/x.html", []string{ "files/a/" }, true },
+    { "files/b/x.html", []string{ "files/a/" }, false },
+    { "files/a/x.html", []string{ "./files/a/" }, true },
+    { "./files/a/x.html", []string{ "files/a/" }, true },
+    { "files/b/x.html", []string{ "./files/a/" }, false },
+    { "files/ab/x.html", []string{ "./files/a/" }, false },
+    { "push:a", []string{ "push:" }, true },
+  }
+  for _, aTest := range someTests {
+    if got := isPathAllowed(aTest.aPath, aTest.somePrefixes); got != aTest.want {
+      t.Errorf("isPathAllowed(%q, %q) = %v, want %v",
+        aTest.aPath, aTest.somePrefixes, got, aTest.want)
+    }
+  }
+}
@@ -0,0 +1,330 @@
+package main
+
+/*
+
+  Since the database is written continuously by the indexer, simply
+  copying the database file while the searcher is running risks a torn
+  copy. So we snapshot the database using SQLite's online backup API,
+  which copies a consistent version of the database. All of the pages
+  are copied in one step (that is, in one read transaction), since a
+  backup which is copied a few pages at a time restarts whenever the
+  database is written. (With the default WAL journal mode the read
+  transaction does not block the indexer, nor the webServer.) Backups
+  read the database using (read-only) connections, they never use a
+  writer:
+
+    searcher -c <configFile> backup [path]   : snapshot the database
+    searcher -c <configFile> restore <path>  : replace the database with
+                                               a snapshot
+
+    POST /admin/backup                       : snapshot the database
+    GET  /admin/backups                      : list the snapshots
+
+  Unless a path is given, each snapshot is written to
+  `Backup.Directory` as `searcher-<UTC time>.db`. If
+  `Backup.IntervalHours` is positive, a snapshot is also taken
+  whenever the newest snapshot is older than that. Only the newest
+  `Backup.Keep` snapshots in `Backup.Directory` are kept (0 keeps them
+  all).
+
+  A snapshot is written to a `.partial` file which is only renamed once
+  the snapshot is complete, so a snapshot is never torn.
+
+  A restored snapshot is checked (`pragma quick_check`) before it is
+  copied, and is then migrated to the current database structure. It is
+  safest to restore a snapshot while the searcher is stopped.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "log"
+  "sort"
+  "time"
+  "context"
+  "strings"
+  "net/http"
+  "io/ioutil"
+  "database/sql"
+  "path/filepath"
+  "github.com/mattn/go-sqlite3"
+)
+
+const (
+  backupPrefix = "searcher-"
+  backupSuffix = ".db"
+)
+
+type backupInfo struct {
+  Path    string    `json:"path"`
+  Bytes   int64     `json:"bytes"`
+  Created time.Time `json:"created"`
+}
+
+func getBackupDirectory() string {
+  return getConfigStr("Backup.Directory", "data/backups")
+}
+
+// Run a function using the underlying SQLite connection of a database.
+//
+func withSQLiteConn(
+  aDB *sql.DB, aFunc func(*sqlite3.SQLiteConn) error,
+) error {
+  aConn, err := aDB.Conn(context.Background())
+  if err != nil { return err }
+  defer aConn.Close()
+  return aConn.Raw(func(driverConn interface{}) error {
+    sqliteConn, isSQLite := driverConn.(*sqlite3.SQLiteConn)
+    if !isSQLite {
+      return fmt.Errorf("the database does not use an SQLite connection")
+    }
+    return aFunc(sqliteConn)
+  })
+}
+
+// Copy the source database over the destination database using SQLite's
+// online backup API. All of the pages are copied in one step, so the copy
+// is a consistent snapshot (and is never restarted by a write).
+//
+func copyDatabase(destDB *sql.DB, srcDB *sql.DB) error {
+  return withSQLiteConn(destDB, func(destConn *sqlite3.SQLiteConn) error {
+    return withSQLiteConn(srcDB, func(srcConn *sqlite3.SQLiteConn) error {
+      aBackup, err := destConn.Backup("main", srcConn, "main")
+      if err != nil { return err }
+      isDone, err := aBackup.Step(-1)
+      if err == nil && !isDone {
+        err = fmt.Errorf("the backup did not complete")
+      }
+      if err != nil {
+        aBackup.Finish()
+        return err
+      }
+      return aBackup.Finish()
+    })
+  })
+}
+
+// Snapshot the database into the file destPath.
+//
+func backupDatabase(searchDB *sql.DB, destPath string) (backupInfo, error) {
+  var theBackup backupInfo
+  if destDir := filepath.Dir(destPath); destDir != "" {
+    if err := os.MkdirAll(destDir, 0755); err != nil {
+      return theBackup, fmt.Errorf("could not create [%s]: %s", destDir, err)
+    }
+  }
+  partialPath := destPath + ".partial"
+  os.Remove(partialPath)
+
+  destDB, err := sql.Open(searchDBDriver, partialPath)
+  if err != nil { return theBackup, err }
+  err = copyDatabase(destDB, searchDB)
+  destDB.Close()
+  if err != nil {
+    os.Remove(partialPath)
+    return theBackup, fmt.Errorf("could not back up the database: %s", err)
+  }
+  if err = os.Rename(partialPath, destPath); err != nil {
+    return theBackup, fmt.Errorf("could not rename the backup: %s", err)
+  }
+
+  theBackup.Path    = destPath
+  theBackup.Created = time.Now().UTC()
+  if backupFileInfo, err := os.Stat(destPath); err == nil {
+    theBackup.Bytes = backupFileInfo.Size()
+  }
+  log.Printf("Searcher: backed up the database to [%s]", destPath)
+  return theBackup, nil
+}
+
+// Snapshot the database into a new (timestamped) file in the
+// `Backup.Directory`, and remove any old backups.
+//
+func backupDatabaseToDirectory(searchDB *sql.DB) (backupInfo, error) {
+  backupDir := getBackupDirectory()
+  theBackup, err := backupDatabase(searchDB, filepath.Join(
+    backupDir,
+    backupPrefix + time.Now().UTC().Format("20060102T150405Z") + backupSuffix,
+  ))
+  if err != nil { return theBackup, err }
+  pruneBackups(backupDir, int(getConfigInt("Backup.Keep", 7)))
+  return theBackup, nil
+}
+
+// The backups in a directory (oldest first).
+//
+func listBackups(backupDir string) ([]backupInfo, error) {
+  someBackups := []backupInfo{}
+  dirEntries, err := ioutil.ReadDir(backupDir)
+  if os.IsNotExist(err) { return someBackups, nil }
+  if err != nil { return someBackups, err }
+  for _, anEntry := range dirEntries {
+    aName := anEntry.Name()
+    if anEntry.IsDir() ||
+      !strings.HasPrefix(aName, backupPrefix) ||
+      !strings.HasSuffix(aName, backupSuffix) { continue }
+    someBackups = append(someBackups, backupInfo{
+      Path    : filepath.Join(backupDir, aName),
+      Bytes   : anEntry.Size(),
+      Created : anEntry.ModTime().UTC(),
+    })
+  }
+  // the (timestamped) names sort oldest first
+  sort.Slice(someBackups, func(i, j int) bool {
+    return someBackups[i].Path < someBackups[j].Path
+  })
+  return someBackups, nil
+}
+
+// Remove all but the newest numToKeep backups (0 keeps them all).
+//
+func pruneBackups(backupDir string, numToKeep int) {
+  if numToKeep < 1 { return }
+  someBackups, err := listBackups(backupDir)
+  if err != nil {
+    log.Printf("Searcher(error): could not list the backups: %s", err)
+    return
+  }
+  for i := 0; i < len(someBackups)-numToKeep; i++ {
+    log.Printf("Searcher: removing old backup [%s]", someBackups[i].Path)
+    if err := os.Remove(someBackups[i].Path); err != nil {
+      log.Printf("Searcher(error): could not remove old backup: %s", err)
+    }
+  }
+}
+
+// Replace the database with a backup.
+//
+func restoreDatabase(backupPath string) error {
+  if _, err := os.Stat(backupPath); err != nil {
+    return fmt.Errorf("could not find the backup: %s", err)
+  }
+  srcDB, err := sql.Open(searchDBDriver, "file:"+backupPath+"?mode=ro")
+  if err != nil { return err }
+  defer srcDB.Close()
+
+  var checkResult string
+  err = srcDB.QueryRow("pragma quick_check;").Scan(&checkResult)
+  if err != nil {
+    return fmt.Errorf("could not check the backup: %s", err)
+  }
+  if checkResult != "ok" {
+    return fmt.Errorf("the backup is corrupt: %s", checkResult)
+  }
+
+  searchDB, err := openSearchDB(false)
+  if err != nil { return err }
+  defer searchDB.Close()
+  // (all of the pages are copied at once, so nobody sees a partial restore)
+  if err = copyDatabase(searchDB, srcDB); err != nil {
+    return fmt.Errorf("could not restore the database: %s", err)
+  }
+  return migrateDatabase(searchDB)
+}
+
+// Take a backup whenever the newest backup is older than
+// `Backup.IntervalHours` (until we are asked to shutdown).
+//
+// The database is only opened (read-only) once backups are scheduled.
+//
+func runScheduledBackups() {
+  var searchDB *sql.DB
+  defer func() {
+    if searchDB != nil { searchDB.Close() }
+  }()
+
+  checkTicker := time.NewTicker(time.Minute)
+  defer checkTicker.Stop()
+
+  for {
+    select {
+      case <-shutdownRequested : return
+      case <-checkTicker.C :
+    }
+    intervalHours := getConfigFloat("Backup.IntervalHours", 0)
+    if intervalHours <= 0 { continue }
+    someBackups, err := listBackups(getBackupDirectory())
+    if err != nil {
+      log.Printf("Searcher(error): could not list the backups: %s", err)
+      continue
+    }
+    interval := time.Duration(intervalHours * float64(time.Hour))
+    if 0 < len(someBackups) &&
+      time.Since(someBackups[len(someBackups)-1].Created) < interval { continue }
+    if searchDB == nil {
+      searchDB, err = openSearchDB(true)
+      if err != nil {
+        log.Printf("Searcher(error): could not open the database for backups: %s", err)
+        searchDB = nil
+        continue
+      }
+    }
+    if _, err = backupDatabaseToDirectory(searchDB); err != nil {
+      log.Printf("Searcher(error): scheduled backup failed: %s", err)
+    }
+  }
+}
+
+// The `backup [path]` command.
+//
+func backupCommand(someArgs []string) int {
+  searchDB, err := openSearchDB(true)
+  if err != nil {
+    fmt.Printf("ERROR: could not open the database: %s\n", err)
+    return 1
+  }
+  defer searchDB.Close()
+
+  var theBackup backupInfo
+  if 0 < len(someArgs) {
+    theBackup, err = backupDatabase(searchDB, someArgs[0])
+  } else {
+    theBackup, err = backupDatabaseToDirectory(searchDB)
+  }
+  if err != nil {
+    fmt.Printf("ERROR: %s\n", err)
+    return 1
+  }
+  fmt.Printf("backed up the database to [%s] (%d bytes)\n", theBackup.Path, theBackup.Bytes)
+  return 0
+}
+
+// The `restore <path>` command.
+//
+func restoreCommand(backupPath string) int {
+  if err := restoreDatabase(backupPath); err != nil {
+    fmt.Printf("ERROR: %s\n", err)
+    return 1
+  }
+  fmt.Printf("restored the database from [%s]\n", backupPath)
+  return 0
+}
+
+func adminBackupHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    theBackup, err := backupDatabaseToDirectory(searchDB)
+    if err != nil {
+      WebserverMaybeError("backing up the database", err)
+      writeJsonMessage(w, http.StatusInternalServerError, err.Error())
+      return
+    }
+    writeJson(w, http.StatusOK, theBackup)
+  }
+}
+
+func adminBackupsHandler(w http.ResponseWriter, r *http.Request) {
+  someBackups, err := listBackups(getBackupDirectory())
+  if err != nil {
+    writeJsonMessage(w, http.StatusInternalServerError, err.Error())
+    return
+  }
+  writeJson(w, http.StatusOK, someBackups)
+}
+
+func registerBackupHandlers(mux *http.ServeMux, searchDB *sql.DB) {
+  mux.HandleFunc("/admin/backup",
+    adminHandler(http.MethodPost, streamingHandler(adminBackupHandler(searchDB))))
+  mux.HandleFunc("/admin/backups",
+    adminHandler(http.MethodGet, adminBackupsHandler))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+  "os"
+  "testing"
+  "path/filepath"
+  "database/sql"
+)
+
+func TestBackupDatabase(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  err := upsertDocument(searchDB, indexedDocument{
+    path : "files/a.html", title : "Aardvarks", body : "all about aardvarks",
+    source : fileSource,
+  })
+  if err != nil { t.Fatalf("could not insert a document: %s", err) }
+
+  backupPath := filepath.Join(t.TempDir(), "backups", "searcher-test.db")
+  theBackup, err := backupDatabase(searchDB, backupPath)
+  if err != nil { t.Fatalf("could not back up the database: %s", err) }
+  if theBackup.Path != backupPath || theBackup.Bytes < 1 {
+    t.Errorf("got backup %+v", theBackup)
+  }
+  if _, err := os.Stat(backupPath + ".partial"); !os.IsNotExist(err) {
+    t.Errorf("the partial backup was not renamed")
+  }
+
+  backupDB, err := sql.Open(searchDBDriver, "file:"+backupPath+"?mode=ro")
+  if err != nil { t.Fatalf("could not open the backup: %s", err) }
+  defer backupDB.Close()
+  version, err := getDatabaseVersion(backupDB)
+  if err != nil || version != len(databaseMigrations) {
+    t.Errorf("got backup version %d (%v), want %d", version, err, len(databaseMigrations))
+  }
+  if got := searchDocPaths(t, backupDB, "aardvarks"); len(got) != 1 {
+    t.Errorf("the backup's documents were not found: %q", got)
+  }
+}
+
+func TestPruneBackups(t *testing.T) {
+  someTests := []struct {
+    numToKeep int
+    want      int
+  }{
+    { 0, 4 }, { 1, 1 }, { 3, 3 }, { 10, 4 },
+  }
+  for _, aTest := range someTests {
+    backupDir := t.TempDir()
+    for _, aName := range []string{
+      "searcher-20210101T000000Z.db", "searcher-20210102T000000Z.db",
+      "searcher-20210103T000000Z.db", "searcher-20210104T000000Z.db",
+      "notABackup.db",
+    } {
+      os.WriteFile(filepath.Join(backupDir, aName), []byte("x"), 0644)
+    }
+    pruneBackups(backupDir, aTest.numToKeep)
+    someBackups, err := listBackups(backupDir)
+    if err != nil { t.Fatalf("could not list the backups: %s", err) }
+    if len(someBackups) != aTest.want {
+      t.Errorf("keep %d: got %d backups, want %d", aTest.numToKeep, len(someBackups), aTest.want)
+      continue
+    }
+    if 0 < aTest.want && filepath.Base(someBackups[len(someBackups)-1].Path) !=
+      "searcher-20210104T000000Z.db" {
+      t.Errorf("keep %d: the newest backup was removed", aTest.numToKeep)
+    }
+  }
+}
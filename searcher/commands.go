@@ -0,0 +1,128 @@
+package main
+
+/*
+
+  Besides running the searcher (the default), the searcher binary can run
+  a number of (one shot) commands, given as the (non flag) arguments:
+
+    searcher -c <configFile> config check
+    searcher -c <configFile> analytics report [days]
+    searcher -c <configFile> migrate compress|decompress
+    searcher -c <configFile> backup [path]
+    searcher -c <configFile> restore <path>
+    searcher -c <configFile> check [--repair]
+    searcher -c <configFile> stats
+    searcher -c <configFile> export [path]
+    searcher -c <configFile> import <path>
+
+  Each command returns the exit status for the searcher.
+
+*/
+
+import (
+  "fmt"
+  "os"
+  "strconv"
+  "io/ioutil"
+)
+
+func commandUsage() {
+  fmt.Fprintln(os.Stderr, "usage: searcher [flags] [command]")
+  fmt.Fprintln(os.Stderr, "")
+  fmt.Fprintln(os.Stderr, "commands:")
+  fmt.Fprintln(os.Stderr, "  config check  validate the configuration file and print the")
+  fmt.Fprintln(os.Stderr, "                effective configuration")
+  fmt.Fprintln(os.Stderr, "  analytics report [days]")
+  fmt.Fprintln(os.Stderr, "                report the top, zero result and slowest queries")
+  fmt.Fprintln(os.Stderr, "                (over the last 7 days by default)")
+  fmt.Fprintln(os.Stderr, "  migrate compress|decompress")
+  fmt.Fprintln(os.Stderr, "                (de)compress the text of the documents already")
+  fmt.Fprintln(os.Stderr, "                in the database")
+  fmt.Fprintln(os.Stderr, "  backup [path] snapshot the (running) database (by default into")
+  fmt.Fprintln(os.Stderr, "                the Backup.Directory)")
+  fmt.Fprintln(os.Stderr, "  restore <path>")
+  fmt.Fprintln(os.Stderr, "                replace the database with a snapshot")
+  fmt.Fprintln(os.Stderr, "  check [--repair]")
+  fmt.Fprintln(os.Stderr, "                check (and repair) the database and its index")
+  fmt.Fprintln(os.Stderr, "  stats         report what has been indexed")
+  fmt.Fprintln(os.Stderr, "  export [path] export the indexed documents (as NDJSON, by default")
+  fmt.Fprintln(os.Stderr, "                to stdout)")
+  fmt.Fprintln(os.Stderr, "  import <path> import (NDJSON) documents (use - for stdin)")
+  fmt.Fprintln(os.Stderr, "")
+  fmt.Fprintln(os.Stderr, "with no command, the searcher indexes and serves the HtmlDirs")
+}
+
+func runCommand(someArgs []string) int {
+  switch {
+    case len(someArgs) == 2 && someArgs[0] == "config" && someArgs[1] == "check" :
+      return configCheckCommand()
+    case 2 <= len(someArgs) && len(someArgs) <= 3 &&
+      someArgs[0] == "analytics" && someArgs[1] == "report" :
+      someDays := 7
+      if len(someArgs) == 3 {
+        aNumber, err := strconv.Atoi(someArgs[2])
+        if err != nil || aNumber < 1 {
+          fmt.Fprintf(os.Stderr, "days must be a positive number (not [%s])\n", someArgs[2])
+          return 2
+        }
+        someDays = aNumber
+      }
+      return analyticsReportCommand(someDays)
+    case len(someArgs) == 2 && someArgs[0] == "migrate" && someArgs[1] == "compress" :
+      return migrateCompressCommand(true)
+    case len(someArgs) == 2 && someArgs[0] == "migrate" && someArgs[1] == "decompress" :
+      return migrateCompressCommand(false)
+    case 1 <= len(someArgs) && len(someArgs) <= 2 && someArgs[0] == "backup" :
+      return backupCommand(someArgs[1:])
+    case len(someArgs) == 2 && someArgs[0] == "restore" :
+      return restoreCommand(someArgs[1])
+    case len(someArgs) == 1 && someArgs[0] == "check" :
+      return checkCommand(false)
+    case len(someArgs) == 2 && someArgs[0] == "check" && someArgs[1] == "--repair" :
+      return checkCommand(true)
+    case len(someArgs) == 1 && someArgs[0] == "stats" :
+      return statsCommand()
+    case 1 <= len(someArgs) && len(someArgs) <= 2 && someArgs[0] == "export" :
+      return exportCommand(someArgs[1:])
+    case len(someArgs) == 2 && someArgs[0] == "import" :
+      return importCommand(someArgs[1])
+  }
+  fmt.Fprintf(os.Stderr, "unrecognized command: %v\n\n", someArgs)
+  commandUsage()
+  return 2
+}
+
+// Validate the configuration file, printing any problems followed by the
+// effective (merged) configuration.
+//
+func configCheckCommand() int {
+  updateConfig.RLock()
+  aConfigFilePath := configFilePath
+  updateConfig.RUnlock()
+
+  configBytes, err := ioutil.ReadFile(aConfigFilePath)
+  if err != nil {
+    fmt.Printf("ERROR: could not read [%s]: %s\n", aConfigFilePath, err)
+    return 1
+  }
+  aConfig  := string(configBytes)
+  problems := validateConfig(aConfig)
+  for _, aWarning := range problems.warnings {
+    fmt.Printf("WARNING: %s\n", aWarning)
+  }
+  for _, anError := range problems.errors {
+    fmt.Printf("ERROR: %s\n", anError)
+  }
+  if 0 < len(problems.errors) {
+    fmt.Printf("[%s] is NOT a valid configuration\n", aConfigFilePath)
+    return 1
+  }
+
+  fmt.Printf("[%s] is a valid configuration\n", aConfigFilePath)
+  fmt.Println("")
+  fmt.Println("Effective configuration:")
+  updateConfig.RLock()
+  defer updateConfig.RUnlock()
+  fmt.Print(redactConfig(layerConfig(aConfig)))
+  return 0
+}
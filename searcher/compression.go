@@ -0,0 +1,181 @@
+package main
+
+/*
+
+  For large archives (such as mirrored wikipedia pages), the extracted
+  text of the documents dominates the size of the database. So, when
+  `Database.CompressText` is true, the body of each (newly indexed)
+  document is stored as a gzip compressed blob.
+
+  The full text index (documentSearch) still needs the (uncompressed)
+  text, both to index a document and to compute snippets. So the search
+  database is ALWAYS opened using our own SQLite driver (searchDBDriver),
+  which registers the `searcherDecompress` SQL function on each
+  connection. The documentSearch index uses the documentText view (which
+  decompresses each body) as its external content.
+
+  Since searcherDecompress returns any uncompressed (text) body unchanged,
+  compressed and uncompressed documents can be mixed freely. The
+  `migrate compress` (or `migrate decompress`) command converts the
+  documents already in an existing database.
+
+  NOTE: tools which do not provide searcherDecompress (such as the sqlite3
+  command line) can still query the documents table, but can not compute
+  snippets or rebuild the documentSearch index.
+
+*/
+
+import (
+  "fmt"
+  "bytes"
+  "io/ioutil"
+  "database/sql"
+  "compress/gzip"
+  "github.com/mattn/go-sqlite3"
+)
+
+const searchDBDriver = "searcherSqlite3"
+
+func init() {
+  sql.Register(searchDBDriver, &sqlite3.SQLiteDriver{
+    ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+      return conn.RegisterFunc("searcherDecompress", searcherDecompress, true)
+    },
+  })
+}
+
+var gzipMagic []byte = []byte{ 0x1f, 0x8b }
+
+func isTextCompressionEnabled() bool {
+  return getConfigBool("Database.CompressText", false)
+}
+
+// Compress a document's text.
+//
+func compressText(aStr string) ([]byte, error) {
+  var compressed bytes.Buffer
+  writer := gzip.NewWriter(&compressed)
+  if _, err := writer.Write([]byte(aStr)); err != nil { return nil, err }
+  if err := writer.Close(); err != nil { return nil, err }
+  return compressed.Bytes(), nil
+}
+
+// Decompress a document's text (if it has been compressed).
+//
+func decompressText(someBytes []byte) (string, error) {
+  if !bytes.HasPrefix(someBytes, gzipMagic) { return string(someBytes), nil }
+  reader, err := gzip.NewReader(bytes.NewReader(someBytes))
+  if err != nil { return "", err }
+  defer reader.Close()
+  decompressed, err := ioutil.ReadAll(reader)
+  if err != nil { return "", err }
+  return string(decompressed), nil
+}
+
+// The searcherDecompress SQL function: a (compressed) blob is
+// decompressed, anything else is returned unchanged.
+//
+func searcherDecompress(aValue interface{}) (interface{}, error) {
+  if someBytes, isBlob := aValue.([]byte); isBlob {
+    return decompressText(someBytes)
+  }
+  return aValue, nil
+}
+
+// The value to store as a document's body (compressed if
+// `Database.CompressText` is true).
+//
+func documentBody(aBody string) (interface{}, error) {
+  if !isTextCompressionEnabled() { return aBody, nil }
+  return compressText(aBody)
+}
+
+// (Re)store the body of every document either compressed or
+// uncompressed, in batches (each in its own transaction). Returns the
+// number of documents changed.
+//
+func migrateDocumentBodies(searchDB *sql.DB, compress bool) (int, error) {
+  // the documents which still need to be changed...
+  //
+  toChange := "select docId, body from documents where typeof(body) = 'text' "
+  if !compress {
+    toChange = "select docId, body from documents where typeof(body) = 'blob' "
+  }
+  toChange = toChange + "and docId > ? order by docId limit 500"
+
+  numChanged := 0
+  lastDocId  := int64(0)
+  for {
+    rows, err := searchDB.Query(toChange, lastDocId)
+    if err != nil { return numChanged, err }
+    docIds := []int64{}
+    bodies := []interface{}{}
+    for rows.Next() {
+      var docId int64
+      var body  []byte
+      if err = rows.Scan(&docId, &body); err != nil { break }
+      var newBody interface{}
+      if compress {
+        newBody, err = compressText(string(body))
+      } else {
+        newBody, err = decompressText(body)
+      }
+      if err != nil {
+        err = fmt.Errorf("could not convert document %d: %s", docId, err)
+        break
+      }
+      docIds = append(docIds, docId)
+      bodies = append(bodies, newBody)
+    }
+    if err == nil { err = rows.Err() }
+    rows.Close()
+    if err != nil { return numChanged, err }
+    if len(docIds) < 1 { return numChanged, nil }
+
+    tx, err := searchDB.Begin()
+    if err != nil { return numChanged, err }
+    for anIndex, docId := range docIds {
+      _, err = tx.Exec(
+        "update documents set body = ? where docId = ?", bodies[anIndex], docId,
+      )
+      if err != nil {
+        tx.Rollback()
+        return numChanged, err
+      }
+    }
+    if err = tx.Commit(); err != nil { return numChanged, err }
+    numChanged = numChanged + len(docIds)
+    lastDocId  = docIds[len(docIds)-1]
+  }
+}
+
+// The `migrate compress` and `migrate decompress` commands.
+//
+func migrateCompressCommand(compress bool) int {
+  initDatabaseStructure()
+  searchDB, err := openSearchDB(false)
+  if err != nil {
+    fmt.Printf("ERROR: could not open the database: %s\n", err)
+    return 1
+  }
+  defer searchDB.Close()
+
+  numChanged, err := migrateDocumentBodies(searchDB, compress)
+  action := "compressed"
+  if !compress { action = "decompressed" }
+  fmt.Printf("%s %d documents\n", action, numChanged)
+  if err != nil {
+    fmt.Printf("ERROR: %s\n", err)
+    return 1
+  }
+  if compress {
+    // reclaim the space freed by compressing the documents
+    //
+    fmt.Println("vacuuming the database")
+    if _, err = searchDB.Exec("vacuum;"); err != nil {
+      fmt.Printf("ERROR: could not vacuum the database: %s\n", err)
+      return 1
+    }
+  }
+  return 0
+}
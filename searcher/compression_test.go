@@ -0,0 +1,103 @@
+package main
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+)
+
+func TestCompressText(t *testing.T) {
+  aText := strings.Repeat("quantum gravity and the badgers of the wiki ", 50)
+  compressed, err := compressText(aText)
+  if err != nil { t.Fatalf("could not compress: %s", err) }
+  if !bytes.HasPrefix(compressed, gzipMagic) || len(aText) <= len(compressed) {
+    t.Errorf("compressed %d bytes to %d bytes (not gzipped?)", len(aText), len(compressed))
+  }
+
+  someTests := []struct {
+    name  string
+    value interface{}
+    want  interface{}
+  }{
+    { "compressed", compressed, aText },
+    { "uncompressed blob", []byte("plain bytes"), "plain bytes" },
+    { "text", "plain text", "plain text" },
+    { "null", nil, nil },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      got, err := searcherDecompress(aTest.value)
+      if err != nil { t.Fatalf("could not decompress: %s", err) }
+      if got != aTest.want { t.Errorf("got %q, want %q", got, aTest.want) }
+    })
+  }
+}
+
+// Documents stored compressed are searched (and their snippets computed)
+// using their decompressed text, and survive being migrated to (and from)
+// uncompressed bodies.
+//
+func TestCompressedDocuments(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+
+  setTestConfig(t, "Database.CompressText=true")
+  someDocs := []indexedDocument{
+    { path: "files/a.html", title: "Gravity", kind: "html",
+      body: "all about quantum gravity", source: fileSource },
+    { path: "files/b.html", title: "Badgers", kind: "html",
+      body: "all about badgers", source: fileSource },
+  }
+  for _, aDoc := range someDocs {
+    if err := upsertDocument(searchDB, aDoc); err != nil {
+      t.Fatalf("could not insert [%s]: %s", aDoc.path, err)
+    }
+  }
+  setTestConfig(t, "Database.CompressText=false")
+  if err := upsertDocument(searchDB, indexedDocument{
+    path: "files/c.html", title: "Gravel", kind: "html",
+    body: "all about gravel and gravity", source: fileSource,
+  }); err != nil {
+    t.Fatalf("could not insert [files/c.html]: %s", err)
+  }
+
+  checkBodies := func(wantTypes string) {
+    t.Helper()
+    var gotTypes string
+    err := searchDB.QueryRow(
+      "select group_concat(typeof(body), ',') from ( select body from documents order by docPath )",
+    ).Scan(&gotTypes)
+    if err != nil { t.Fatalf("could not get the body types: %s", err) }
+    if gotTypes != wantTypes { t.Errorf("got bodies [%s], want [%s]", gotTypes, wantTypes) }
+
+    if got := strings.Join(searchDocPaths(t, searchDB, "gravity"), " "); got != "files/a.html files/c.html" {
+      t.Errorf("searching for gravity got [%s]", got)
+    }
+    var aSnippet string
+    err = searchDB.QueryRow(`
+      select snippet(documentSearch, 1, '[', ']', '...', 8) from documentSearch
+        where documentSearch match 'badgers'
+    `).Scan(&aSnippet)
+    if err != nil { t.Fatalf("could not compute a snippet: %s", err) }
+    if aSnippet != "all about [badgers]" { t.Errorf("got snippet [%s]", aSnippet) }
+  }
+  checkBodies("blob,blob,text")
+
+  numChanged, err := migrateDocumentBodies(searchDB, false)
+  if err != nil || numChanged != 2 {
+    t.Fatalf("decompressed %d documents (%v), want 2", numChanged, err)
+  }
+  checkBodies("text,text,text")
+
+  numChanged, err = migrateDocumentBodies(searchDB, true)
+  if err != nil || numChanged != 3 {
+    t.Fatalf("compressed %d documents (%v), want 3", numChanged, err)
+  }
+  checkBodies("blob,blob,blob")
+
+  // the index remains consistent with the (decompressed) documents
+  if _, err := searchDB.Exec(
+    "insert into documentSearch ( documentSearch ) values ( 'integrity-check' );",
+  ); err != nil {
+    t.Errorf("the index is not consistent: %s", err)
+  }
+}
@@ -7,10 +7,21 @@ package main
   Very importantly, both packages ignore golang comments embedded in the
   json!
 
-  We implement a lazy loading of the config file so that we can allow for
-  the config file to be changed outside the searcher. TO DO THIS, we
-  require a RWMutex. SEE: https://stackoverflow.com/a/19168242 for a good
-  discussion on how to USE RWMutex's.
+  The effective configuration is layered (defaults, file, environment,
+  command line), see configLayers.go.
+
+  The effective configuration is held in an immutable configSnapshot which
+  is swapped (atomically) whenever the configuration changes, so reading a
+  configuration value never needs a lock (nor a stat of the config file).
+
+  We watch the config file (see watchConfigFile) so that we can allow for
+  the config file to be changed outside the searcher. When it changes, we
+  reload it, swap the snapshot and then tell any subscribers (see
+  subscribeToConfig) so that they can react to the change.
+
+  Reloading still requires a RWMutex. SEE:
+  https://stackoverflow.com/a/19168242 for a good discussion on how to USE
+  RWMutex's.
 
 */
 
@@ -20,15 +31,61 @@ import (
   "time"
   "sync"
   "io/ioutil"
+  "sync/atomic"
   "github.com/tidwall/gjson"
   "github.com/tidwall/sjson"
 )
 
-var configFilePath  string = ""
-var configFileMTime int64  = 0
-var configFileSize  int64  = 0
-var searcherConfig  string = ""
-var updateConfig    sync.RWMutex
+// An immutable snapshot of the effective configuration, with the most
+// commonly used values already typed.
+//
+type configSnapshot struct {
+  raw          string // the effective (layered) configuration as json
+  DatabasePath string
+  HtmlDirs     []string
+  UrlBase      string
+  TitlePattern string
+  Host         string
+  Port         int64
+  SearchForm   string
+}
+
+func newConfigSnapshot(aConfig string) *configSnapshot {
+  cs := &configSnapshot{ raw: aConfig }
+  cs.DatabasePath = gjson.Get(aConfig, "DatabasePath").String()
+  cs.HtmlDirs     = []string{}
+  for _, anHtmlDir := range gjson.Get(aConfig, "HtmlDirs").Array() {
+    cs.HtmlDirs = append(cs.HtmlDirs, anHtmlDir.String())
+  }
+  cs.UrlBase      = gjson.Get(aConfig, "UrlBase").String()
+  cs.TitlePattern = gjson.Get(aConfig, "TitlePattern").String()
+  cs.Host         = gjson.Get(aConfig, "Host").String()
+  cs.Port         = gjson.Get(aConfig, "Port").Int()
+  cs.SearchForm   = gjson.Get(aConfig, "Webserver.SearchForm").String()
+  return cs
+}
+
+// Have any of the HtmlDirs changed between two snapshots?
+//
+func (cs *configSnapshot) hasSameHtmlDirs(otherConfig *configSnapshot) bool {
+  if len(cs.HtmlDirs) != len(otherConfig.HtmlDirs) { return false }
+  for i, anHtmlDir := range cs.HtmlDirs {
+    if anHtmlDir != otherConfig.HtmlDirs[i] { return false }
+  }
+  return true
+}
+
+type configSubscriber func(oldConfig *configSnapshot, newConfig *configSnapshot)
+
+var currentConfig      atomic.Value    // always holds a *configSnapshot
+var configFilePath     string = ""
+var configFileMTime    int64  = 0
+var configFileSize     int64  = 0
+var searcherFileConfig string = "" // the last valid configuration file
+var updateConfig       sync.RWMutex
+
+var configSubscribers       map[string]configSubscriber = map[string]configSubscriber{}
+var updateConfigSubscribers sync.Mutex
 
 /////////////////////////////
 // Locking primitives
@@ -44,15 +101,29 @@ func setConfigFilePath(aConfigFilePath string) {
   configFilePath = aConfigFilePath
 }
 
+// Subscribe to changes in the configuration. The subscriber is called
+// (from the goroutine which reloaded the configuration) after the new
+// configuration has been swapped in, so it should be quick.
+//
+func subscribeToConfig(aName string, aSubscriber configSubscriber) {
+  updateConfigSubscribers.Lock()
+  defer updateConfigSubscribers.Unlock()
+  configSubscribers[aName] = aSubscriber
+}
+
+func notifyConfigSubscribers(oldConfig *configSnapshot, newConfig *configSnapshot) {
+  updateConfigSubscribers.Lock()
+  defer updateConfigSubscribers.Unlock()
+  for aName, aSubscriber := range configSubscribers {
+    log.Printf("Config: notifying [%s] of the new configuration", aName)
+    aSubscriber(oldConfig, newConfig)
+  }
+}
+
 func hasConfigChanged() bool {
   updateConfig.RLock()
   defer updateConfig.RUnlock()
 
-  if len(searcherConfig) < 1 {
-    //log.Print("Config(reload): no configuration data")
-    return true
-  }
-
   if len(configFilePath) < 1 {
     log.Print("Config(no reload): no configuration path")
     return false
@@ -81,17 +152,47 @@ func hasConfigChanged() bool {
 }
 
 func reloadConfigFile() {
+  oldConfig, newConfig := swapConfigSnapshot()
+  if newConfig != nil && oldConfig != nil {
+    notifyConfigSubscribers(oldConfig, newConfig)
+  }
+}
+
+// Reload the configuration file and swap in the new snapshot, returning
+// the old and new snapshots (the new snapshot is nil if the configuration
+// file was rejected).
+//
+func swapConfigSnapshot() (*configSnapshot, *configSnapshot) {
   updateConfig.Lock()
   defer updateConfig.Unlock()
 
+  oldConfig, _ := currentConfig.Load().(*configSnapshot)
+
   searcherConfigBytes, err := ioutil.ReadFile(configFilePath)
   if err == nil {
-    searcherConfig = string(searcherConfigBytes)
+    //
+    // record the file info (even if we reject its contents) so that we do
+    // not keep trying to reload a broken configuration
+    //
     configFileInfo, err := os.Stat(configFilePath)
     if err == nil {
       configFileMTime = configFileInfo.ModTime().Unix()
       configFileSize  = configFileInfo.Size()
     }
+    newFileConfig := string(searcherConfigBytes)
+    if !isValidConfig(configFilePath, newFileConfig) {
+      if oldConfig == nil {
+        log.Fatalf(
+          "Searcher(fatal): invalid configuration in [%s]", configFilePath,
+        )
+      }
+      log.Printf(
+        "Searcher(error): rejected configuration in [%s] (keeping the previous configuration)",
+        configFilePath,
+      )
+      return oldConfig, nil
+    }
+    searcherFileConfig = newFileConfig
   } else {
     log.Printf(
       "Searcher(error): Failed to load configuration from [%s] ERROR: %s",
@@ -99,49 +200,54 @@ func reloadConfigFile() {
     )
   }
 
-  // Now ensure we have the basic REQUIRED configuraiton...
+  // Now merge the defaults, the file and any overrides...
   //
-  gValue := gjson.Get(searcherConfig, "DatabasePath")
-  if ! gValue.Exists() {
-    tmpSearcherConfig, err := sjson.Set(
-      searcherConfig, "DatabasePath", "data/searcher.db",
-    )
-    if err == nil {
-      searcherConfig = tmpSearcherConfig
-    } else {
-      log.Fatalf(
-"Searcher(fatal): Failed to SET configuration value DatabasePath when required ERROR: %s",
-        err,
-      )
-    }
-  }
-  gValue = gjson.Get(searcherConfig, "HtmlDirs")
-  if ! gValue.Exists() {
-    tmpSearcherConfig, err := sjson.Set(
-      searcherConfig, "HtmlDirs", "[ \"files\" ]",
-    )
-    if err == nil {
-      searcherConfig = tmpSearcherConfig
-    } else {
-      log.Fatalf(
-"Searcher(fatal): Failed to SET configuration value HtmlDirs when required ERROR: %s",
-        err,
-      )
-    }
-  }
+  newConfig := newConfigSnapshot(layerConfig(searcherFileConfig))
+  currentConfig.Store(newConfig)
   log.Printf(
-    "Searcher: configuration: [%s]\n[%s]", configFilePath, searcherConfig,
+    "Searcher: configuration: [%s]\n[%s]", configFilePath,
+    gjson.Get(redactConfig(newConfig.raw), "@ugly").Raw,
   )
+  return oldConfig, newConfig
 }
 
-func getConfigVar(configVarPath string) gjson.Result {
-  if hasConfigChanged() { reloadConfigFile() }
+// Forget the current snapshot so that the configuration is reloaded the
+// next time it is needed.
+//
+// NOTE: the caller must hold the updateConfig lock.
+//
+func clearConfigSnapshot() {
+  currentConfig.Store((*configSnapshot)(nil))
+}
 
-  updateConfig.RLock()
-  defer updateConfig.RUnlock()
-  return gjson.Get(searcherConfig, configVarPath)
+// Validate a configuration, logging any problems. Returns false if the
+// configuration should be rejected.
+//
+func isValidConfig(aConfigFilePath string, aConfig string) bool {
+  problems := validateConfig(aConfig)
+  for _, aWarning := range problems.warnings {
+    log.Printf("Searcher(warning): [%s] %s", aConfigFilePath, aWarning)
+  }
+  for _, anError := range problems.errors {
+    log.Printf("Searcher(error): [%s] %s", aConfigFilePath, anError)
+  }
+  return len(problems.errors) < 1
 }
 
+// Watch the configuration file (until we are asked to shutdown),
+// reloading it whenever it changes.
+//
+func watchConfigFile() {
+  for {
+    pollSeconds := getConfigInt("ConfigPollSeconds", 5)
+    select {
+      case <-shutdownRequested :
+        return
+      case <-time.After(time.Duration(pollSeconds) * time.Second) :
+        if hasConfigChanged() { reloadConfigFile() }
+    }
+  }
+}
 
 ///////////////////////////
 // Non locking primitives
@@ -149,6 +255,42 @@ func getConfigVar(configVarPath string) gjson.Result {
 // Which depend upon the locking primitives above
 //
 
+// Get the current configuration snapshot (loading the configuration if
+// this is the first time it is needed).
+//
+func getConfigSnapshot() *configSnapshot {
+  theConfig, _ := currentConfig.Load().(*configSnapshot)
+  if theConfig == nil {
+    reloadConfigFile()
+    theConfig, _ = currentConfig.Load().(*configSnapshot)
+  }
+  return theConfig
+}
+
+func getConfigVar(configVarPath string) gjson.Result {
+  return gjson.Get(getConfigSnapshot().raw, configVarPath)
+}
+
+// Return the effective configuration (as strict json) with any secrets
+// (such as the admin tokens) redacted.
+//
+func getRedactedConfig() string {
+  return redactConfig(getConfigSnapshot().raw)
+}
+
+func redactConfig(aConfig string) string {
+  theConfig := jsoncToJson(aConfig)
+  for _, aSecretPath := range []string{
+    "Admin.Tokens", "Ingest.Tokens", "Auth.Tokens",
+  } {
+    if gjson.Get(theConfig, aSecretPath).Exists() {
+      redactedConfig, err := sjson.Set(theConfig, aSecretPath, "REDACTED")
+      if err == nil { theConfig = redactedConfig }
+    }
+  }
+  return gjson.Get(theConfig, "@pretty").Raw
+}
+
 func getConfigStr(configVarPath string, aDefault string) string {
   gValue := getConfigVar(configVarPath)
   theValue := aDefault
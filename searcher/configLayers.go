@@ -0,0 +1,223 @@
+package main
+
+/*
+
+  The effective configuration is built from four layers, each of which
+  overrides the previous ones:
+
+    1. the built-in defaults (from the configSchema),
+
+    2. the configuration file (see: setConfigFilePath),
+
+    3. SEARCHER_* environment variables, one for each key in the schema,
+       named by upper casing its path and replacing each `.` by `_`
+       (e.g. `SEARCHER_INDEXER_SLEEPSECONDS` for `Indexer.SleepSeconds`),
+
+    4. command line overrides (`--set Path=value`, as well as the `-H` and
+       `-p` flags).
+
+  Environment variable and command line values are converted to the type
+  required by the schema. A `[]string` may be given either as a json array
+  or as a comma separated list, while a `map[string][]string` must be given
+  as a json object.
+
+  The environment and command line do not change while the searcher runs,
+  so they are parsed (once) by setConfigOverrides. The layers are merged
+  by layerConfig whenever the configuration file is (re)loaded, so the
+  existing getConfig* accessors always see the effective configuration.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "strings"
+  "strconv"
+  "github.com/tidwall/gjson"
+  "github.com/tidwall/sjson"
+)
+
+type configOverride struct {
+  path   string
+  value  string  // as (strict) json
+  source string
+}
+
+var configOverrides []configOverride
+
+// A flag.Value which collects repeated `--set Path=value` flags
+//
+type configSetFlags []string
+
+func (csf *configSetFlags) String() string {
+  return strings.Join(*csf, " ")
+}
+
+func (csf *configSetFlags) Set(aValue string) error {
+  *csf = append(*csf, aValue)
+  return nil
+}
+
+func configEnvName(aPath string) string {
+  return "SEARCHER_" + strings.ToUpper(strings.Replace(aPath, ".", "_", -1))
+}
+
+// Convert a (string) value into json of the kind required by the schema
+//
+func configValueToJson(aKey configKey, aValue string) (string, error) {
+  switch aKey.kind {
+    case stringKind :
+      return quoteJsonString(aValue), nil
+    case intKind :
+      anInt, err := strconv.ParseInt(strings.TrimSpace(aValue), 10, 64)
+      if err != nil { return "", fmt.Errorf("[%s] is not an int", aValue) }
+      return strconv.FormatInt(anInt, 10), nil
+    case floatKind :
+      aFloat, err := strconv.ParseFloat(strings.TrimSpace(aValue), 64)
+      if err != nil { return "", fmt.Errorf("[%s] is not a float", aValue) }
+      return strconv.FormatFloat(aFloat, 'g', -1, 64), nil
+    case boolKind :
+      aBool, err := strconv.ParseBool(strings.TrimSpace(aValue))
+      if err != nil { return "", fmt.Errorf("[%s] is not a bool", aValue) }
+      return strconv.FormatBool(aBool), nil
+    case stringsKind :
+      trimmedValue := strings.TrimSpace(aValue)
+      if strings.HasPrefix(trimmedValue, "[") {
+        someStrings := gjson.Parse(trimmedValue)
+        if !hasConfigKind(someStrings, stringsKind) {
+          return "", fmt.Errorf("[%s] is not a json array of strings", aValue)
+        }
+        return toStrictJson(someStrings), nil
+      }
+      someItems := []string{}
+      for _, anItem := range strings.Split(aValue, ",") {
+        anItem = strings.TrimSpace(anItem)
+        if len(anItem) < 1 { continue }
+        someItems = append(someItems, quoteJsonString(anItem))
+      }
+      return "["+strings.Join(someItems, ",")+"]", nil
+    case mapKind :
+      aMap := gjson.Parse(strings.TrimSpace(aValue))
+      if !hasConfigKind(aMap, mapKind) {
+        return "", fmt.Errorf("[%s] is not a json object of string arrays", aValue)
+      }
+      return toStrictJson(aMap), nil
+  }
+  return "", fmt.Errorf("can not override a value of kind %s", aKey.kind)
+}
+
+func newConfigOverride(
+  aPath string, aValue string, aSource string, problems *configProblems,
+) {
+  aKey, isKnown := findConfigKey(aPath)
+  if !isKnown {
+    problems.addError("%s: unknown configuration key [%s]", aSource, aPath)
+    return
+  }
+  jsonValue, err := configValueToJson(aKey, aValue)
+  if err != nil {
+    problems.addError("%s: configuration key [%s] %s", aSource, aPath, err)
+    return
+  }
+  if aKey.validate != nil {
+    if err := aKey.validate(gjson.Parse(jsonValue)); err != nil {
+      problems.addError("%s: configuration key [%s] %s", aSource, aPath, err)
+      return
+    }
+  }
+  configOverrides = append(configOverrides, configOverride{
+    path   : aPath,
+    value  : jsonValue,
+    source : aSource,
+  })
+}
+
+// Collect the (environment and command line) overrides.
+//
+func setConfigOverrides(
+  someSets []string, cliHost string, cliPort int64,
+) configProblems {
+  var problems configProblems
+
+  updateConfig.Lock()
+  defer updateConfig.Unlock()
+
+  configOverrides = []configOverride{}
+  for _, aKey := range configSchema {
+    if aKey.kind == objectKind { continue }
+    envName := configEnvName(aKey.path)
+    if aValue, isSet := os.LookupEnv(envName); isSet {
+      newConfigOverride(aKey.path, aValue, envName, &problems)
+    }
+  }
+  if cliHost != "" {
+    newConfigOverride("Host", cliHost, "-H", &problems)
+  }
+  if cliPort != 0 {
+    newConfigOverride("Port", strconv.FormatInt(cliPort, 10), "-p", &problems)
+  }
+  for _, aSet := range someSets {
+    nameValue := strings.SplitN(aSet, "=", 2)
+    if len(nameValue) != 2 {
+      problems.addError("--set %s: must be of the form Path=value", aSet)
+      continue
+    }
+    newConfigOverride(
+      strings.TrimSpace(nameValue[0]), nameValue[1], "--set", &problems,
+    )
+  }
+
+  // force the configuration to be (re)loaded with these overrides
+  clearConfigSnapshot()
+  return problems
+}
+
+var configPathEscaper *strings.Replacer = strings.NewReplacer(
+  ".", "\\.", "*", "\\*", "?", "\\?",
+)
+
+// Copy every (non object) value in aLayer into theConfig. A map (such as
+// `Auth.ACL`) is copied as a whole, so that a layer replaces (rather than
+// merges into) the map below it.
+//
+func overlayConfig(
+  theConfig string, aLayer gjson.Result, aPrefix string, aKeyPrefix string,
+) string {
+  aLayer.ForEach(func(aName, aValue gjson.Result) bool {
+    aPath    := aPrefix + configPathEscaper.Replace(aName.String())
+    aKeyPath := aKeyPrefix + aName.String()
+    aKey, _  := findConfigKey(aKeyPath)
+    if aValue.IsObject() && aKey.kind != mapKind {
+      theConfig = overlayConfig(theConfig, aValue, aPath+".", aKeyPath+".")
+      return true
+    }
+    newConfig, err := sjson.SetRaw(theConfig, aPath, toStrictJson(aValue))
+    if err == nil { theConfig = newConfig }
+    return true
+  })
+  return theConfig
+}
+
+// Merge the built-in defaults, the (JSONC) configuration file and the
+// overrides into the effective (strict json) configuration.
+//
+// NOTE: the caller must hold the updateConfig lock.
+//
+func layerConfig(fileConfig string) string {
+  theConfig := "{}"
+  for _, aKey := range configSchema {
+    if aKey.defaultValue == nil { continue }
+    newConfig, err := sjson.Set(theConfig, aKey.path, aKey.defaultValue)
+    if err == nil { theConfig = newConfig }
+  }
+
+  theConfig = overlayConfig(
+    theConfig, gjson.Parse(stripJsonComments(fileConfig)), "", "",
+  )
+
+  for _, anOverride := range configOverrides {
+    newConfig, err := sjson.SetRaw(theConfig, anOverride.path, anOverride.value)
+    if err == nil { theConfig = newConfig }
+  }
+  return theConfig
+}
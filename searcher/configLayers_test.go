@@ -0,0 +1,98 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "github.com/tidwall/gjson"
+)
+
+func TestConfigLayers(t *testing.T) {
+  fileConfig := `{
+    // the file overrides the defaults
+    "Port" : 8080
+    "HtmlDirs" : [ "site" ]
+    "Indexer" : { "SleepSeconds" : 30 }
+  }`
+  someTests := []struct {
+    name    string
+    env     map[string]string
+    sets    []string
+    cliHost string
+    cliPort int64
+    path    string
+    want    string
+  }{
+    { name : "default",   path : "Host",                 want : `"0.0.0.0"` },
+    { name : "file",      path : "Port",                 want : `8080` },
+    { name : "nested default is kept", path : "Indexer.RemoveBatch", want : `200` },
+    { name : "environment over file",
+      env  : map[string]string{ "SEARCHER_PORT" : "8081" },
+      path : "Port", want : `8081` },
+    { name : "nested environment",
+      env  : map[string]string{ "SEARCHER_INDEXER_SLEEPSECONDS" : "5" },
+      path : "Indexer.SleepSeconds", want : `5` },
+    { name : "flag over environment",
+      env  : map[string]string{ "SEARCHER_PORT" : "8081" },
+      cliPort : 8082, path : "Port", want : `8082` },
+    { name : "--set over flag and environment",
+      env  : map[string]string{ "SEARCHER_PORT" : "8081" },
+      sets : []string{ "Port=8083" }, cliPort : 8082, path : "Port", want : `8083` },
+    { name : "the last --set wins",
+      sets : []string{ "Host=a.example.com", "Host=b.example.com" },
+      cliHost : "c.example.com", path : "Host", want : `"b.example.com"` },
+    { name : "comma separated list",
+      env  : map[string]string{ "SEARCHER_HTMLDIRS" : "a, b" },
+      path : "HtmlDirs", want : `["a","b"]` },
+    { name : "json list",
+      sets : []string{ `HtmlDirs=["c"]` }, path : "HtmlDirs", want : `["c"]` },
+    { name : "json map",
+      sets : []string{ `Auth.ACL={"*":["files/public/"]}` },
+      path : "Auth.ACL", want : `{"*":["files/public/"]}` },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      // (reset the overrides after the environment has been restored)
+      t.Cleanup(func() { setConfigOverrides([]string{}, "", 0) })
+      for aName, aValue := range aTest.env { t.Setenv(aName, aValue) }
+      problems := setConfigOverrides(aTest.sets, aTest.cliHost, aTest.cliPort)
+      if 0 < len(problems.errors) { t.Fatalf("unexpected errors: %q", problems.errors) }
+      theConfig := layerConfig(fileConfig)
+      if got := gjson.Get(theConfig, aTest.path).Raw; got != aTest.want {
+        t.Errorf("[%s] = %s, want %s", aTest.path, got, aTest.want)
+      }
+    })
+  }
+}
+
+func TestConfigOverrideErrors(t *testing.T) {
+  someTests := []struct {
+    name      string
+    env       map[string]string
+    sets      []string
+    wantError string
+  }{
+    { name : "not an int", env : map[string]string{ "SEARCHER_PORT" : "http" },
+      wantError : "SEARCHER_PORT: configuration key [Port] [http] is not an int" },
+    { name : "not a bool", sets : []string{ "Analytics.Enabled=maybe" },
+      wantError : "[Analytics.Enabled] [maybe] is not a bool" },
+    { name : "unknown key", sets : []string{ "Indexr.SleepSeconds=5" },
+      wantError : "unknown configuration key [Indexr.SleepSeconds]" },
+    { name : "no value", sets : []string{ "Port" },
+      wantError : "must be of the form Path=value" },
+    { name : "invalid value", sets : []string{ "Port=-1" },
+      wantError : "[Port] must not be negative" },
+    { name : "not a map", sets : []string{ `Auth.ACL=["files/"]` },
+      wantError : "is not a json object of string arrays" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      t.Cleanup(func() { setConfigOverrides([]string{}, "", 0) })
+      for aName, aValue := range aTest.env { t.Setenv(aName, aValue) }
+      problems := setConfigOverrides(aTest.sets, "", 0)
+      if len(problems.errors) != 1 ||
+        !strings.Contains(problems.errors[0], aTest.wantError) {
+        t.Errorf("got errors %q, want %q", problems.errors, aTest.wantError)
+      }
+    })
+  }
+}
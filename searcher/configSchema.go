@@ -0,0 +1,367 @@
+package main
+
+/*
+
+  We describe every configuration key the searcher knows about, so that a
+  configuration can be validated when it is (re)loaded:
+
+    - a key with a value of the wrong type is an ERROR (and the whole
+      configuration is rejected),
+
+    - an unknown key (usually a typo such as "Indexr") is a WARNING.
+
+  Keys are listed by their (gjson) path, together with their built-in
+  default value. A key of kind "object" contains further keys (and has no
+  default value).
+
+  REMEMBER to add any new configuration keys to this schema!
+
+*/
+
+import (
+  "fmt"
+  "regexp"
+  "strings"
+  "github.com/tidwall/gjson"
+)
+
+const (
+  stringKind  = "string"
+  intKind     = "int"
+  floatKind   = "float"
+  boolKind    = "bool"
+  stringsKind = "[]string"
+  mapKind     = "map[string][]string"
+  objectKind  = "object"
+)
+
+type configKey struct {
+  path         string
+  kind         string
+  defaultValue interface{}
+  description  string
+  validate     func(gjson.Result) error
+}
+
+func isPositive(aValue gjson.Result) error {
+  if aValue.Int() < 1 { return fmt.Errorf("must be at least 1") }
+  return nil
+}
+
+func isNotNegative(aValue gjson.Result) error {
+  if aValue.Int() < 0 { return fmt.Errorf("must not be negative") }
+  return nil
+}
+
+func isNotNegativeFloat(aValue gjson.Result) error {
+  if aValue.Float() < 0 { return fmt.Errorf("must not be negative") }
+  return nil
+}
+
+func isRegexp(aValue gjson.Result) error {
+  _, err := regexp.Compile(aValue.String())
+  return err
+}
+
+var configSchema []configKey = []configKey{
+  { "DatabasePath", stringKind, "data/searcher.db",
+    "the path to the SQLite database", nil },
+  { "HtmlDirs", stringsKind, []string{ "files" },
+    "the directories (or sitemaps) to search for new or updated html files",
+    areLocalHtmlDirs },
+  { "UrlBase", stringKind, "",
+    "the url which replaces an HtmlDir when mapping a file to its url", nil },
+  { "TitlePattern", stringKind, "<title>(.*?)</title>",
+    "the regular expression used to find the title of an html file",
+    isRegexp },
+  { "Host", stringKind, "0.0.0.0",
+    "the interface on which the webServer listens", nil },
+  { "Port", intKind, 9090,
+    "the port on which the webServer listens", isNotNegative },
+  { "ConfigPollSeconds", intKind, 5,
+    "how often the configuration file is checked for changes", isPositive },
+
+  { "Indexer", objectKind, nil,
+    "how the indexer works", nil },
+  { "Indexer.SleepSeconds", intKind, 60,
+    "the (maximum) time the indexer sleeps between passes", isPositive },
+  { "Indexer.RemoveBatch", intKind, 200,
+    "the number of missing files to remove in one indexer pass",
+    isNotNegative },
+  { "Indexer.AddUpdateBatch", intKind, 200,
+    "the number of new or changed files to index in one indexer pass",
+    isNotNegative },
+
+  { "Crawler", objectKind, nil,
+    "which sites the indexer crawls (over HTTP)", nil },
+  { "Crawler.Seeds", stringsKind, []string{},
+    "the urls from which each site is crawled (none disables the crawler)",
+    areHttpUrls },
+  { "Crawler.MaxDepth", intKind, 3,
+    "the maximum number of links followed from a seed", isNotNegative },
+  { "Crawler.MaxPages", intKind, 1000,
+    "the maximum number of pages fetched by one crawl", isPositive },
+  { "Crawler.Include", stringsKind, []string{},
+    "only crawl urls matching one of these patterns (none crawls every url)",
+    areRegexps },
+  { "Crawler.Exclude", stringsKind, []string{},
+    "never crawl urls matching any of these patterns", areRegexps },
+  { "Crawler.UserAgent", stringKind, "searcher",
+    "the User-Agent sent by (and used to find the robots.txt rules for) the crawler",
+    nil },
+  { "Crawler.DelayMs", intKind, 100,
+    "the (minimum) time between requests", isNotNegative },
+  { "Crawler.MaxDelaySeconds", intKind, 30,
+    "the maximum time between requests (a longer robots.txt Crawl-delay is ignored)",
+    isNotNegative },
+  { "Crawler.TimeoutSeconds", intKind, 30,
+    "the maximum time taken to fetch a page", isPositive },
+  { "Crawler.MaxPageBytes", intKind, 10485760,
+    "the maximum size of a crawled page", isPositive },
+  { "Crawler.IntervalMinutes", intKind, 60,
+    "the (minimum) time between crawls (0 crawls after every index pass)",
+    isNotNegative },
+
+  { "Database", objectKind, nil,
+    "how the documents are stored", nil },
+  { "Database.CompressText", boolKind, false,
+    "should the text of (newly indexed) documents be compressed?", nil },
+  { "Database.JournalMode", stringKind, "WAL",
+    "the SQLite journal mode (WAL, DELETE, TRUNCATE or PERSIST)", isJournalMode },
+  { "Database.Synchronous", stringKind, "NORMAL",
+    "the SQLite synchronous setting (OFF, NORMAL, FULL or EXTRA)", isSynchronous },
+  { "Database.BusyTimeoutMs", intKind, 5000,
+    "how long (in milliseconds) to wait for a database lock", isNotNegative },
+  { "Database.MaxReadConnections", intKind, 4,
+    "the maximum number of (read-only) connections used by searches", isPositive },
+  { "Database.VacuumAfterRemovals", intKind, 1,
+    "vacuum after an index pass removes this many files (0 never vacuums)", isNotNegative },
+
+  { "Backup", objectKind, nil,
+    "how the database is backed up", nil },
+  { "Backup.Directory", stringKind, "data/backups",
+    "the directory in which backups are stored", nil },
+  { "Backup.IntervalHours", floatKind, 0.0,
+    "how often a backup is taken (0 disables scheduled backups)", isNotNegativeFloat },
+  { "Backup.Keep", intKind, 7,
+    "how many backups are kept (0 keeps them all)", isNotNegative },
+
+  { "Admin", objectKind, nil,
+    "who may use the admin API", nil },
+  { "Admin.Tokens", stringsKind, []string{},
+    "the bearer tokens which authorize admin requests", nil },
+
+  { "Ingest", objectKind, nil,
+    "who may push documents using the ingestion API", nil },
+  { "Ingest.Tokens", stringsKind, []string{},
+    "the bearer tokens which authorize ingestion requests", nil },
+  { "Ingest.MaxBodyBytes", intKind, 10485760,
+    "the maximum size of an ingestion request", isPositive },
+
+  { "Webserver", objectKind, nil,
+    "how the webServer works", nil },
+  { "Webserver.MaxNumResults", intKind, 100,
+    "the default number of search results", isPositive },
+  { "Webserver.SearchForm", stringKind, "config/searchForm.html",
+    "the path to the searchForm.html template", nil },
+  { "Webserver.ShutdownSeconds", intKind, 10,
+    "the time in-flight searches are given to complete on shutdown",
+    isNotNegative },
+  { "Webserver.Timeouts", objectKind, nil,
+    "the webServer's timeouts", nil },
+  { "Webserver.Timeouts.ReadSeconds", intKind, 30,
+    "the time allowed to read a whole request", isNotNegative },
+  { "Webserver.Timeouts.HeaderSeconds", intKind, 10,
+    "the time allowed to read a request's headers", isNotNegative },
+  { "Webserver.Timeouts.WriteSeconds", intKind, 60,
+    "the time allowed to write a response", isNotNegative },
+  { "Webserver.Timeouts.StreamSeconds", intKind, 3600,
+    "the time allowed to write a streamed response, such as an export (0 is unlimited)",
+    isNotNegative },
+  { "Webserver.Timeouts.IdleSeconds", intKind, 120,
+    "the time an idle keep-alive connection is kept open", isNotNegative },
+  { "Webserver.TLS", objectKind, nil,
+    "how the webServer uses TLS", nil },
+  { "Webserver.TLS.CertFile", stringKind, "",
+    "the TLS certificate (chain) file (TLS is used if both files are set)",
+    nil },
+  { "Webserver.TLS.KeyFile", stringKind, "",
+    "the TLS private key file", nil },
+  { "Webserver.TLS.MinVersion", stringKind, "1.2",
+    "the minimum TLS version (1.0, 1.1, 1.2 or 1.3)", isTLSVersion },
+  { "Webserver.TLS.HTTP2", boolKind, true,
+    "should HTTP/2 be offered over TLS?", nil },
+  { "Webserver.TLS.RedirectHTTP", boolKind, false,
+    "should plain HTTP requests (on the HTTPPort) be redirected to HTTPS?",
+    nil },
+  { "Webserver.TLS.HTTPPort", intKind, 80,
+    "the port on which plain HTTP requests are redirected", isNotNegative },
+  { "Webserver.Assets", objectKind, nil,
+    "the favicon, stylesheets and scripts served from the config directory", nil },
+  { "Webserver.Assets.Favicon", stringKind, "config/searcherFavicon.ico",
+    "the favicon served at /favicon.ico", nil },
+  { "Webserver.Assets.Stylesheets", stringsKind, []string{},
+    "the stylesheets used by the search form", nil },
+  { "Webserver.Assets.Scripts", stringsKind, []string{},
+    "the scripts used by the search form", nil },
+  { "Webserver.Assets.CacheSeconds", intKind, 3600,
+    "how long browsers may cache an asset", isNotNegative },
+  { "Webserver.StaticFiles", objectKind, nil,
+    "how the webServer serves the files in the HtmlDirs", nil },
+  { "Webserver.StaticFiles.Enabled", boolKind, false,
+    "should the webServer serve the files in the HtmlDirs?", nil },
+  { "Webserver.StaticFiles.CacheSeconds", intKind, 3600,
+    "how long browsers may cache a static file", isNotNegative },
+  { "Webserver.StaticFiles.IndexFiles", stringsKind, []string{ "index.html" },
+    "the files used to serve a directory", nil },
+
+  { "Auth", objectKind, nil,
+    "how searchers are authenticated and what they may search", nil },
+  { "Auth.Required", boolKind, false,
+    "must searchers be authenticated?", nil },
+  { "Auth.Tokens", mapKind, map[string][]string{},
+    "the bearer tokens of each user", nil },
+  { "Auth.HtpasswdFile", stringKind, "",
+    "an htpasswd file of (bcrypt) passwords used for HTTP basic authentication", nil },
+  { "Auth.ProxyUserHeader", stringKind, "",
+    "the header in which a trusted reverse proxy gives the user", nil },
+  { "Auth.ProxyGroupsHeader", stringKind, "",
+    "the header in which a trusted reverse proxy gives the user's groups", nil },
+  { "Auth.TrustedProxies", stringsKind, []string{},
+    "the IP addresses (or CIDRs) of the trusted reverse proxies", isIPOrCIDRs },
+  { "Auth.Groups", mapKind, map[string][]string{},
+    "the users in each group", nil },
+  { "Auth.ACL", mapKind, map[string][]string{},
+    "the path prefixes each principal may search", isACL },
+
+  { "Analytics", objectKind, nil,
+    "how searches and clicks are recorded", nil },
+  { "Analytics.Enabled", boolKind, true,
+    "should searches and clicks be recorded?", nil },
+  { "Analytics.DatabasePath", stringKind, "data/analytics.db",
+    "the path to the analytics database", nil },
+  { "Analytics.RecordUsers", boolKind, false,
+    "should the (authenticated) user be recorded?", nil },
+  { "Analytics.RetentionDays", intKind, 90,
+    "how long searches and clicks are kept (0 keeps them forever)", isNotNegative },
+
+  { "Ranking", objectKind, nil,
+    "how search results are ranked", nil },
+  { "Ranking.PopularityWeight", floatKind, 0.0,
+    "how much a result's click popularity adds to its relevance (0 disables)", isNotNegativeFloat },
+  { "Ranking.PopularityHalfLifeDays", floatKind, 30.0,
+    "how many days it takes for a click's popularity to halve", isNotNegativeFloat },
+  { "Ranking.LinkWeight", floatKind, 0.0,
+    "how much a result's (inbound) link score adds to its relevance (0 disables)", isNotNegativeFloat },
+  { "Ranking.RecencyWeight", floatKind, 0.0,
+    "how much a (just) modified result's recency adds to its relevance (0 disables)", isNotNegativeFloat },
+  { "Ranking.RecencyHalfLifeDays", floatKind, 365.0,
+    "how many days it takes for a result's recency to halve", isNotNegativeFloat },
+  { "Ranking.Candidates", intKind, 200,
+    "how many of the most relevant results are re-ranked by popularity (link score or recency)",
+    isRankingCandidates },
+
+  { "OpenSearch", objectKind, nil,
+    "how the searcher describes itself to browsers", nil },
+  { "OpenSearch.SiteName", stringKind, "Searcher",
+    "the (short) name of the searcher", nil },
+  { "OpenSearch.Description", stringKind, "Search this site",
+    "a description of the searcher", nil },
+  { "OpenSearch.Favicon", stringKind, "config/searcherFavicon.ico",
+    "the favicon used in the OpenSearch description", nil },
+  { "OpenSearch.BaseUrl", stringKind, "",
+    "the public url of the searcher (if empty, taken from each request)", nil },
+  { "OpenSearch.MaxSuggestions", intKind, 10,
+    "how many suggestions are returned by /suggest", isPositive },
+}
+
+func findConfigKey(aPath string) (configKey, bool) {
+  for _, aKey := range configSchema {
+    if aKey.path == aPath { return aKey, true }
+  }
+  return configKey{}, false
+}
+
+// Does a (json) value have the type required by the schema?
+//
+func hasConfigKind(aValue gjson.Result, aKind string) bool {
+  switch aKind {
+    case stringKind  : return aValue.Type == gjson.String
+    case intKind     :
+      return aValue.Type == gjson.Number && aValue.Num == float64(aValue.Int())
+    case floatKind   : return aValue.Type == gjson.Number
+    case boolKind    :
+      return aValue.Type == gjson.True || aValue.Type == gjson.False
+    case objectKind  : return aValue.IsObject()
+    case stringsKind :
+      if !aValue.IsArray() { return false }
+      for _, anItem := range aValue.Array() {
+        if anItem.Type != gjson.String { return false }
+      }
+      return true
+    case mapKind     :
+      if !aValue.IsObject() { return false }
+      isMap := true
+      aValue.ForEach(func(aName, someItems gjson.Result) bool {
+        isMap = hasConfigKind(someItems, stringsKind)
+        return isMap
+      })
+      return isMap
+  }
+  return false
+}
+
+type configProblems struct {
+  errors   []string
+  warnings []string
+}
+
+func (cp *configProblems) addError(aFormat string, v ...interface{}) {
+  cp.errors = append(cp.errors, fmt.Sprintf(aFormat, v...))
+}
+
+func (cp *configProblems) addWarning(aFormat string, v ...interface{}) {
+  cp.warnings = append(cp.warnings, fmt.Sprintf(aFormat, v...))
+}
+
+func validateConfigObject(
+  anObject gjson.Result, aPrefix string, problems *configProblems,
+) {
+  anObject.ForEach(func(aName, aValue gjson.Result) bool {
+    aPath := aPrefix + aName.String()
+    aKey, isKnown := findConfigKey(aPath)
+    if !isKnown {
+      problems.addWarning("unknown configuration key [%s]", aPath)
+      return true
+    }
+    if !hasConfigKind(aValue, aKey.kind) {
+      problems.addError(
+        "configuration key [%s] must be of type %s (found: %s)",
+        aPath, aKey.kind, strings.TrimSpace(aValue.Raw),
+      )
+      return true
+    }
+    if aKey.validate != nil {
+      if err := aKey.validate(aValue); err != nil {
+        problems.addError("configuration key [%s] %s", aPath, err)
+      }
+    }
+    if aKey.kind == objectKind {
+      validateConfigObject(aValue, aPath+".", problems)
+    }
+    return true
+  })
+}
+
+// Validate a (JSONC) configuration against the schema.
+//
+func validateConfig(jsonc string) configProblems {
+  var problems configProblems
+  theConfig := gjson.Parse(stripJsonComments(jsonc))
+  if !theConfig.IsObject() {
+    problems.addError("the configuration must be a json object")
+    return problems
+  }
+  validateConfigObject(theConfig, "", &problems)
+  return problems
+}
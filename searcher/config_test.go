@@ -0,0 +1,53 @@
+package main
+
+import (
+  "os"
+  "testing"
+  "path/filepath"
+)
+
+func TestRejectedReloadKeepsTheConfig(t *testing.T) {
+  configPath := filepath.Join(t.TempDir(), "searcher.jsonc")
+  writeConfig := func(aConfig string) {
+    t.Helper()
+    if err := os.WriteFile(configPath, []byte(aConfig), 0644); err != nil { t.Fatal(err) }
+  }
+  t.Cleanup(func() {
+    updateConfigSubscribers.Lock()
+    delete(configSubscribers, "test")
+    updateConfigSubscribers.Unlock()
+    setConfigFilePath("")
+    setConfigOverrides([]string{}, "", 0)
+  })
+
+  writeConfig(`{ "Port" : 8080 }`)
+  setConfigFilePath(configPath)
+  reloadConfigFile()
+  firstConfig := getConfigSnapshot()
+  if firstConfig.Port != 8080 { t.Fatalf("got port %d, want 8080", firstConfig.Port) }
+
+  numNotified := 0
+  var notifiedOld, notifiedNew *configSnapshot
+  subscribeToConfig("test", func(oldConfig, newConfig *configSnapshot) {
+    numNotified = numNotified + 1
+    notifiedOld, notifiedNew = oldConfig, newConfig
+  })
+
+  writeConfig(`{ "Port" : "8081" }`)
+  reloadConfigFile()
+  if getConfigSnapshot() != firstConfig {
+    t.Errorf("a rejected configuration replaced the snapshot (port %d)",
+      getConfigSnapshot().Port)
+  }
+  if numNotified != 0 { t.Errorf("the subscribers were notified of a rejected configuration") }
+  if hasConfigChanged() { t.Errorf("a rejected configuration would be reloaded again") }
+
+  writeConfig(`{ "Port" : 8082 }`)
+  reloadConfigFile()
+  if getConfigSnapshot().Port != 8082 {
+    t.Errorf("got port %d, want 8082", getConfigSnapshot().Port)
+  }
+  if numNotified != 1 || notifiedOld != firstConfig || notifiedNew != getConfigSnapshot() {
+    t.Errorf("the subscribers were notified %d times (old port %v)", numNotified, notifiedOld)
+  }
+}
@@ -0,0 +1,643 @@
+package main
+
+/*
+
+  As well as (or instead of) walking the HtmlDirs, the indexer can crawl
+  sites over HTTP (for example, a local development server). After each
+  index pass (at most every `Crawler.IntervalMinutes`) the crawler:
+
+    - reads each seed's robots.txt (and obeys the rules for its
+      `Crawler.UserAgent`, or for `*`, including any Crawl-delay of at
+      most `Crawler.MaxDelaySeconds`),
+
+    - starts from the seed url, any pages listed in the site's sitemaps
+      (those listed in its robots.txt, or /sitemap.xml) and any pages it
+      has crawled before,
+
+    - follows links to pages on the SAME host (scheme, host and port) as
+      the seed, at most `Crawler.MaxDepth` links away from the seed, whose
+      urls match one of the `Crawler.Include` patterns (if any) and none
+      of the `Crawler.Exclude` patterns,
+
+    - fetches at most `Crawler.MaxPages` pages (in total) each crawl,
+      waiting `Crawler.DelayMs` between requests.
+
+  Crawled (text/html) pages go through the same extraction (TitlePattern,
+  stripHtml) and indexing as files. Each is stored as a document whose
+  path (and url) is the page's url, with the "crawl" source.
+
+  Recrawls are incremental: each page's ETag and Last-Modified headers are
+  stored, and sent back (as If-None-Match and If-Modified-Since) when the
+  page is next fetched, so unchanged pages are not downloaded again.
+  Pages which are gone (404 or 410), marked `noindex` (by a robots meta
+  tag) or are no longer allowed (by robots.txt or the patterns) are
+  removed from the index, as are (after every index pass) the pages of
+  any site which is no longer one of the `Crawler.Seeds`.
+
+*/
+
+import (
+  "io"
+  "fmt"
+  "time"
+  "regexp"
+  "strings"
+  "net/url"
+  "net/http"
+  "io/ioutil"
+  "database/sql"
+  "github.com/tidwall/gjson"
+)
+
+func areRegexps(aValue gjson.Result) error {
+  for _, aPattern := range aValue.Array() {
+    if _, err := regexp.Compile(aPattern.String()); err != nil { return err }
+  }
+  return nil
+}
+
+func areHttpUrls(aValue gjson.Result) error {
+  for _, aUrl := range aValue.Array() {
+    if !isUrlLocation(aUrl.String()) {
+      return fmt.Errorf("[%s] must be an http or https url", aUrl.String())
+    }
+    if _, err := url.Parse(aUrl.String()); err != nil { return err }
+  }
+  return nil
+}
+
+func getCrawlerUserAgent() string {
+  return getConfigStr("Crawler.UserAgent", "searcher")
+}
+
+func compilePatterns(somePatterns []string) []*regexp.Regexp {
+  someRegexps := []*regexp.Regexp{}
+  for _, aPattern := range somePatterns {
+    aRegexp, err := regexp.Compile(aPattern)
+    if err != nil {
+      IndexerMaybeError("could not compile crawler pattern "+aPattern, err)
+      continue
+    }
+    someRegexps = append(someRegexps, aRegexp)
+  }
+  return someRegexps
+}
+
+/////////////////////////////
+// robots.txt
+
+type robotsRule struct {
+  pattern *regexp.Regexp
+  length  int
+  allow   bool
+}
+
+type robotsRules struct {
+  matched    bool
+  rules      []robotsRule
+  crawlDelay time.Duration
+  sitemaps   []string
+}
+
+func (theRules *robotsRules) addRule(aPath string, allow bool) {
+  // (an empty Disallow allows everything)
+  if len(aPath) < 1 { return }
+  aPattern := regexp.QuoteMeta(aPath)
+  aPattern  = strings.Replace(aPattern, `\*`, ".*", -1)
+  if strings.HasSuffix(aPattern, `\$`) {
+    aPattern = strings.TrimSuffix(aPattern, `\$`) + "$"
+  }
+  aRegexp, err := regexp.Compile("^" + aPattern)
+  if err != nil { return }
+  theRules.rules = append(theRules.rules, robotsRule{ aRegexp, len(aPath), allow })
+}
+
+// May a page (given its path and query) be crawled? The longest matching
+// rule wins (an Allow wins a tie).
+//
+func (theRules *robotsRules) isAllowed(aPath string) bool {
+  isAllowed  := true
+  bestLength := -1
+  for _, aRule := range theRules.rules {
+    if !aRule.pattern.MatchString(aPath) { continue }
+    if bestLength < aRule.length || (bestLength == aRule.length && aRule.allow) {
+      isAllowed  = aRule.allow
+      bestLength = aRule.length
+    }
+  }
+  return isAllowed
+}
+
+// Parse a robots.txt, using the groups for our user agent (or, if there
+// are none, the groups for `*`).
+//
+func parseRobots(robotsTxt string, userAgent string) robotsRules {
+  agentToken := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+  var agentRules, anyRules robotsRules
+  someSitemaps := []string{}
+  groupAgents  := []string{}
+  inRules      := false
+  for _, aLine := range strings.Split(robotsTxt, "\n") {
+    if anIndex := strings.Index(aLine, "#"); 0 <= anIndex { aLine = aLine[:anIndex] }
+    someParts := strings.SplitN(aLine, ":", 2)
+    if len(someParts) < 2 { continue }
+    aKey   := strings.ToLower(strings.TrimSpace(someParts[0]))
+    aValue := strings.TrimSpace(someParts[1])
+    if aKey == "sitemap" {
+      someSitemaps = append(someSitemaps, aValue)
+      continue
+    }
+    if aKey == "user-agent" {
+      if inRules { groupAgents = []string{} }
+      inRules     = false
+      groupAgents = append(groupAgents, strings.ToLower(aValue))
+      continue
+    }
+    inRules = true
+    isAgentGroup, isAnyGroup := false, false
+    for _, anAgent := range groupAgents {
+      if anAgent == "*" { isAnyGroup = true; continue }
+      // (a group's user agent is matched against our product token, see:
+      // RFC 9309)
+      if strings.SplitN(anAgent, "/", 2)[0] == agentToken { isAgentGroup = true }
+    }
+    for _, theRules := range []*robotsRules{ &agentRules, &anyRules } {
+      if theRules == &agentRules && !isAgentGroup { continue }
+      if theRules == &anyRules   && !isAnyGroup   { continue }
+      theRules.matched = true
+      switch aKey {
+        case "allow"    : theRules.addRule(aValue, true)
+        case "disallow" : theRules.addRule(aValue, false)
+        case "crawl-delay" :
+          var aDelay float64
+          if _, err := fmt.Sscanf(aValue, "%g", &aDelay); err == nil {
+            theRules.crawlDelay = time.Duration(aDelay * float64(time.Second))
+          }
+      }
+    }
+  }
+  theRules := anyRules
+  if agentRules.matched { theRules = agentRules }
+  theRules.sitemaps = someSitemaps
+  return theRules
+}
+
+// Fetch (and parse) a site's robots.txt. A missing robots.txt allows
+// everything, an unavailable one (a server error) nothing.
+//
+func fetchRobots(client *http.Client, siteUrl *url.URL) (robotsRules, error) {
+  robotsUrl := siteUrl.Scheme + "://" + siteUrl.Host + "/robots.txt"
+  request, err := http.NewRequest(http.MethodGet, robotsUrl, nil)
+  if err != nil { return robotsRules{}, err }
+  request.Header.Set("User-Agent", getCrawlerUserAgent())
+  response, err := client.Do(request)
+  if err != nil { return robotsRules{}, err }
+  defer response.Body.Close()
+  if 500 <= response.StatusCode {
+    return robotsRules{}, fmt.Errorf("%s returned %s", robotsUrl, response.Status)
+  }
+  if response.StatusCode != http.StatusOK { return robotsRules{}, nil }
+  robotsBytes, err := ioutil.ReadAll(response.Body)
+  if err != nil { return robotsRules{}, err }
+  return parseRobots(string(robotsBytes), getCrawlerUserAgent()), nil
+}
+
+/////////////////////////////
+// Pages
+
+var robotsMetaRegexp *regexp.Regexp = regexp.MustCompile(
+  `(?is)<meta\s[^>]*?name\s*=\s*["']?robots["']?[^>]*?content\s*=\s*["']([^"']*)["']`,
+)
+
+// The canonical form of a page's url (without any fragment).
+//
+func crawlUrl(aUrl *url.URL) string {
+  canonicalUrl := *aUrl
+  canonicalUrl.Fragment    = ""
+  canonicalUrl.RawFragment = ""
+  canonicalUrl.Host        = strings.ToLower(canonicalUrl.Host)
+  if len(canonicalUrl.Path) < 1 { canonicalUrl.Path = "/" }
+  return canonicalUrl.String()
+}
+
+// The (absolute) urls of the links in a page.
+//
+func extractLinks(pageStr string, pageUrl *url.URL) []string {
+  someLinks := []string{}
+  for _, aLink := range findPageLinks(pageStr) {
+    linkUrl, err := pageUrl.Parse(aLink.href)
+    if err != nil { continue }
+    if linkUrl.Scheme != "http" && linkUrl.Scheme != "https" { continue }
+    someLinks = append(someLinks, crawlUrl(linkUrl))
+  }
+  return someLinks
+}
+
+// The (comma separated) directives of a page's robots meta tag.
+//
+func robotsMetaDirectives(pageStr string) map[string]bool {
+  someDirectives := map[string]bool{}
+  for _, aMatch := range robotsMetaRegexp.FindAllStringSubmatch(pageStr, -1) {
+    for _, aDirective := range strings.Split(aMatch[1], ",") {
+      someDirectives[strings.ToLower(strings.TrimSpace(aDirective))] = true
+    }
+  }
+  return someDirectives
+}
+
+// Find the ETag and Last-Modified (headers) of a crawled page.
+//
+func findCrawlValidators(searchDB *sql.DB, pageUrl string) (string, string) {
+  var etag, lastModified sql.NullString
+  searchDB.QueryRow(
+    "select etag, lastModified from documents where docPath = ? and source = ?",
+    pageUrl, crawlSource,
+  ).Scan(&etag, &lastModified)
+  return etag.String, lastModified.String
+}
+
+// Record the ETag and Last-Modified (headers) and the depth of a crawled
+// page.
+//
+func recordCrawlValidators(
+  searchDB *sql.DB, pageUrl string, etag string, lastModified string, depth int64,
+) error {
+  _, err := searchDB.Exec(`
+    update documents set etag = ?, lastModified = ?, crawlDepth = ?
+      where docPath = ?
+  `, etag, lastModified, depth, pageUrl)
+  return err
+}
+
+func removeCrawledPage(searchDB *sql.DB, pageUrl string, aReason string) {
+  isDeleted, err := deleteDocument(searchDB, pageUrl)
+  IndexerMaybeError("could not remove "+pageUrl, err)
+  if isDeleted {
+    IndexerLogf("REMOVED: [%s] (%s)", pageUrl, aReason)
+    crawlerPagesRemoved.inc()
+  }
+}
+
+type crawlTarget struct {
+  url     string
+  depth   int64
+  lastMod time.Time
+}
+
+// Fetch (and, if it has changed, index) a page. Returns the links to
+// follow from the page.
+//
+func crawlPage(
+  searchDB *sql.DB, client *http.Client, aTarget crawlTarget,
+  titleRegexp *regexp.Regexp,
+) []string {
+  _, _, pageHash, isIndexed, err := findDocumentInfo(searchDB, aTarget.url)
+  IndexerMaybeError("looking for "+aTarget.url+" in documents", err)
+  if err != nil { return nil }
+
+  request, err := http.NewRequest(http.MethodGet, aTarget.url, nil)
+  if err != nil {
+    IndexerMaybeError("could not request "+aTarget.url, err)
+    crawlerPagesFailed.inc()
+    return nil
+  }
+  request.Header.Set("User-Agent", getCrawlerUserAgent())
+  request.Header.Set("Accept", "text/html,application/xhtml+xml")
+  if isIndexed {
+    etag, lastModified := findCrawlValidators(searchDB, aTarget.url)
+    if 0 < len(etag)         { request.Header.Set("If-None-Match", etag) }
+    if 0 < len(lastModified) { request.Header.Set("If-Modified-Since", lastModified) }
+  }
+  response, err := client.Do(request)
+  if err != nil {
+    IndexerMaybeError("could not fetch "+aTarget.url, err)
+    crawlerPagesFailed.inc()
+    return nil
+  }
+  defer response.Body.Close()
+
+  switch response.StatusCode {
+    case http.StatusOK : // (index it below)
+    case http.StatusNotModified :
+      crawlerPagesNotModified.inc()
+      etag, lastModified := findCrawlValidators(searchDB, aTarget.url)
+      err = recordCrawlValidators(searchDB, aTarget.url, etag, lastModified, aTarget.depth)
+      IndexerMaybeError("could not record the crawl of "+aTarget.url, err)
+      return nil
+    case http.StatusNotFound, http.StatusGone :
+      removeCrawledPage(searchDB, aTarget.url, response.Status)
+      return nil
+    default :
+      IndexerMaybeError("could not fetch "+aTarget.url,
+        fmt.Errorf("the server returned %s", response.Status))
+      crawlerPagesFailed.inc()
+      return nil
+  }
+  crawlerPagesFetched.inc()
+
+  // (we may have been redirected)
+  pageUrl := response.Request.URL
+  if strings.ToLower(pageUrl.Host) != strings.ToLower(request.URL.Host) {
+    IndexerLogf("SKIPPED: [%s] (redirected to %s)", aTarget.url, pageUrl)
+    return nil
+  }
+  contentType := strings.ToLower(response.Header.Get("Content-Type"))
+  if !strings.Contains(contentType, "text/html") &&
+    !strings.Contains(contentType, "application/xhtml+xml") {
+    IndexerLogf("SKIPPED: [%s] (%s)", aTarget.url, contentType)
+    return nil
+  }
+  maxPageBytes   := getConfigInt("Crawler.MaxPageBytes", 10485760)
+  pageBytes, err := ioutil.ReadAll(io.LimitReader(response.Body, maxPageBytes+1))
+  if err == nil && maxPageBytes < int64(len(pageBytes)) {
+    err = fmt.Errorf("the page is larger than %d bytes", maxPageBytes)
+  }
+  if err != nil {
+    IndexerMaybeError("could not read "+aTarget.url, err)
+    crawlerPagesFailed.inc()
+    return nil
+  }
+  pageStr := string(pageBytes)
+
+  someDirectives := robotsMetaDirectives(pageStr)
+  someLinks      := []string{}
+  if !someDirectives["nofollow"] && !someDirectives["none"] {
+    someLinks = extractLinks(pageStr, pageUrl)
+  }
+  if someDirectives["noindex"] || someDirectives["none"] {
+    removeCrawledPage(searchDB, aTarget.url, "noindex")
+    return someLinks
+  }
+
+  pageTitle, pageText := extractHtml(pageStr, titleRegexp, aTarget.url)
+  etag         := response.Header.Get("ETag")
+  lastModified := response.Header.Get("Last-Modified")
+  pageMTime    := time.Now().Unix()
+  if aTime, err := http.ParseTime(lastModified); err == nil {
+    pageMTime = aTime.Unix()
+  } else if !aTarget.lastMod.IsZero() {
+    pageMTime = aTarget.lastMod.Unix()
+  }
+
+  if isIndexed && pageHash == documentHash(pageTitle, pageText) {
+    IndexerLogf("UNCHANGED: [%s]", aTarget.url)
+  } else {
+    if isIndexed {
+      IndexerLogf("UPDATING: [%s][%s]", aTarget.url, pageTitle)
+    } else {
+      IndexerLogf("INSERTING: [%s][%s]", aTarget.url, pageTitle)
+    }
+    err = upsertDocument(searchDB, indexedDocument{
+      path   : aTarget.url,
+      url    : aTarget.url,
+      title  : pageTitle,
+      kind   : fileResultType(pageUrl.Path),
+      mtime  : pageMTime,
+      size   : int64(len(pageBytes)),
+      body   : pageText,
+      source : crawlSource,
+    })
+    if err != nil {
+      IndexerMaybeError("trying to index "+aTarget.url, err)
+      crawlerPagesFailed.inc()
+      return someLinks
+    }
+  }
+  err = recordCrawlValidators(searchDB, aTarget.url, etag, lastModified, aTarget.depth)
+  IndexerMaybeError("could not record the crawl of "+aTarget.url, err)
+  err = recordDocumentLinks(searchDB, aTarget.url, aTarget.url, pageStr)
+  IndexerMaybeError("could not record the links in "+aTarget.url, err)
+  return someLinks
+}
+
+/////////////////////////////
+// Sites
+
+// The prefix of the (canonical) urls of the pages of a site.
+//
+func crawlSitePrefix(siteUrl *url.URL) string {
+  return siteUrl.Scheme + "://" + strings.ToLower(siteUrl.Host) + "/"
+}
+
+// The pages (of this site) crawled before (with the depth at which they
+// were found).
+//
+func findCrawledPages(searchDB *sql.DB, siteUrl *url.URL) ([]crawlTarget, error) {
+  sitePrefix := crawlSitePrefix(siteUrl)
+  rows, err := searchDB.Query(`
+    select docPath, crawlDepth from documents
+      where source = ? and substr(docPath, 1, ?) = ?
+  `, crawlSource, len(sitePrefix), sitePrefix)
+  if err != nil { return nil, err }
+  defer rows.Close()
+  someTargets := []crawlTarget{}
+  for rows.Next() {
+    var aTarget crawlTarget
+    var depth   sql.NullInt64
+    if err = rows.Scan(&aTarget.url, &depth); err != nil { return nil, err }
+    aTarget.depth = depth.Int64
+    someTargets   = append(someTargets, aTarget)
+  }
+  return someTargets, rows.Err()
+}
+
+type siteCrawler struct {
+  searchDB    *sql.DB
+  client      *http.Client
+  titleRegexp *regexp.Regexp
+  includes    []*regexp.Regexp
+  excludes    []*regexp.Regexp
+  maxDepth    int64
+  delay       time.Duration
+  maxDelay    time.Duration
+  numFetched  int64
+  maxPages    int64
+}
+
+// Should this url be crawled at all (given the patterns)?
+//
+func (theCrawler *siteCrawler) isIncluded(aUrl string) bool {
+  for _, anExclude := range theCrawler.excludes {
+    if anExclude.MatchString(aUrl) { return false }
+  }
+  if len(theCrawler.includes) < 1 { return true }
+  for _, anInclude := range theCrawler.includes {
+    if anInclude.MatchString(aUrl) { return true }
+  }
+  return false
+}
+
+// The time to wait between requests to a site, given the Crawl-delay of
+// its robots.txt (which is capped, so that a site can not stall the
+// indexer).
+//
+func (theCrawler *siteCrawler) siteDelay(robotsDelay time.Duration) time.Duration {
+  if theCrawler.maxDelay < robotsDelay { robotsDelay = theCrawler.maxDelay }
+  if robotsDelay < theCrawler.delay { return theCrawler.delay }
+  return robotsDelay
+}
+
+// Wait before fetching the next page. Returns false if we have been asked
+// to shutdown (while waiting).
+//
+func waitBeforeFetching(aDelay time.Duration) bool {
+  delayTimer := time.NewTimer(aDelay)
+  select {
+    case <-shutdownRequested :
+      delayTimer.Stop()
+      return false
+    case <-delayTimer.C :
+      return true
+  }
+}
+
+// Crawl the site of one seed url.
+//
+func (theCrawler *siteCrawler) crawlSite(aSeed string) {
+  seedUrl, err := url.Parse(aSeed)
+  if err != nil {
+    IndexerMaybeError("could not parse crawler seed "+aSeed, err)
+    return
+  }
+  siteHost := strings.ToLower(seedUrl.Host)
+  IndexerLogf("crawling %s", crawlUrl(seedUrl))
+
+  theRobots, err := fetchRobots(theCrawler.client, seedUrl)
+  if err != nil {
+    IndexerMaybeError("could not read the robots.txt of "+siteHost, err)
+    return
+  }
+  delay := theCrawler.siteDelay(theRobots.crawlDelay)
+  if theCrawler.maxDelay < theRobots.crawlDelay {
+    IndexerLogf("using a crawl delay of %s (not %s) for %s",
+      delay, theRobots.crawlDelay, siteHost)
+  }
+
+  // start with the seed, the pages in the sitemaps and the pages we have
+  // crawled before
+  //
+  toCrawl := []crawlTarget{ { url : crawlUrl(seedUrl) } }
+  someSitemaps := theRobots.sitemaps
+  if len(someSitemaps) < 1 {
+    someSitemaps = []string{ seedUrl.Scheme + "://" + seedUrl.Host + "/sitemap.xml" }
+  }
+  for _, aSitemap := range someSitemaps {
+    someEntries, err := readSitemap(theCrawler.client, aSitemap)
+    if err != nil && 0 < len(theRobots.sitemaps) {
+      IndexerMaybeError("could not read sitemap "+aSitemap, err)
+    }
+    for _, anEntry := range someEntries {
+      entryUrl, err := url.Parse(anEntry.url)
+      if err != nil { continue }
+      toCrawl = append(toCrawl, crawlTarget{ crawlUrl(entryUrl), 0, anEntry.lastMod })
+    }
+  }
+  crawledPages, err := findCrawledPages(theCrawler.searchDB, seedUrl)
+  IndexerMaybeError("could not find the pages crawled from "+siteHost, err)
+  toCrawl = append(toCrawl, crawledPages...)
+
+  hasSeen := map[string]bool{}
+  for 0 < len(toCrawl) {
+    if theCrawler.maxPages <= theCrawler.numFetched { break }
+    if isShuttingDown() { break }
+    aTarget := toCrawl[0]
+    toCrawl  = toCrawl[1:]
+    if hasSeen[aTarget.url] { continue }
+    hasSeen[aTarget.url] = true
+
+    targetUrl, err := url.Parse(aTarget.url)
+    if err != nil { continue }
+    if strings.ToLower(targetUrl.Host) != siteHost || targetUrl.Scheme != seedUrl.Scheme {
+      continue
+    }
+    if !theCrawler.isIncluded(aTarget.url) {
+      removeCrawledPage(theCrawler.searchDB, aTarget.url, "excluded")
+      continue
+    }
+    if !theRobots.isAllowed(targetUrl.RequestURI()) {
+      removeCrawledPage(theCrawler.searchDB, aTarget.url, "disallowed by robots.txt")
+      continue
+    }
+
+    if 0 < theCrawler.numFetched && !waitBeforeFetching(delay) { break }
+    theCrawler.numFetched = theCrawler.numFetched + 1
+    someLinks := crawlPage(
+      theCrawler.searchDB, theCrawler.client, aTarget, theCrawler.titleRegexp,
+    )
+    if theCrawler.maxDepth <= aTarget.depth { continue }
+    for _, aLink := range someLinks {
+      if hasSeen[aLink] { continue }
+      toCrawl = append(toCrawl, crawlTarget{ url : aLink, depth : aTarget.depth + 1 })
+    }
+  }
+}
+
+// Remove the pages of any site which is no longer one of the seeds.
+//
+func removeUncrawledSites(searchDB *sql.DB, someSeeds []string) error {
+  sitePrefixes := []string{}
+  for _, aSeed := range someSeeds {
+    if seedUrl, err := url.Parse(aSeed); err == nil {
+      sitePrefixes = append(sitePrefixes, crawlSitePrefix(seedUrl))
+    }
+  }
+
+  rows, err := searchDB.Query(
+    "select docPath from documents where source = ?", crawlSource,
+  )
+  if err != nil { return err }
+  toRemove := []string{}
+  for rows.Next() {
+    var pageUrl string
+    if err = rows.Scan(&pageUrl); err != nil { break }
+    isSeeded := false
+    for _, aPrefix := range sitePrefixes {
+      if strings.HasPrefix(pageUrl, aPrefix) { isSeeded = true; break }
+    }
+    if !isSeeded { toRemove = append(toRemove, pageUrl) }
+  }
+  if err == nil { err = rows.Err() }
+  rows.Close()
+  if err != nil { return err }
+
+  for _, pageUrl := range toRemove {
+    if isShuttingDown() { break }
+    removeCrawledPage(searchDB, pageUrl, "no longer a crawled site")
+  }
+  return nil
+}
+
+var lastCrawlStart time.Time
+
+// Crawl the `Crawler.Seeds` (if a crawl is due).
+//
+func crawlSites(searchDB *sql.DB) {
+  someSeeds := getConfigAStr("Crawler.Seeds", []string{})
+  IndexerMaybeError("could not remove the pages of uncrawled sites",
+    removeUncrawledSites(searchDB, someSeeds))
+  if len(someSeeds) < 1 { return }
+  crawlInterval := time.Duration(
+    getConfigInt("Crawler.IntervalMinutes", 60),
+  ) * time.Minute
+  if time.Since(lastCrawlStart) < crawlInterval { return }
+  lastCrawlStart = time.Now()
+
+  theCrawler := siteCrawler{
+    searchDB    : searchDB,
+    client      : &http.Client{
+      Timeout : time.Duration(getConfigInt("Crawler.TimeoutSeconds", 30)) * time.Second,
+    },
+    titleRegexp : getTitleRegexp(),
+    includes    : compilePatterns(getConfigAStr("Crawler.Include", []string{})),
+    excludes    : compilePatterns(getConfigAStr("Crawler.Exclude", []string{})),
+    maxDepth    : getConfigInt("Crawler.MaxDepth", 3),
+    delay       : time.Duration(getConfigInt("Crawler.DelayMs", 100)) * time.Millisecond,
+    maxDelay    : time.Duration(getConfigInt("Crawler.MaxDelaySeconds", 30)) * time.Second,
+    maxPages    : getConfigInt("Crawler.MaxPages", 1000),
+  }
+  for _, aSeed := range someSeeds {
+    if isShuttingDown() { break }
+    theCrawler.crawlSite(aSeed)
+  }
+  IndexerLogf("crawled %d pages", theCrawler.numFetched)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+  "time"
+  "net/url"
+  "reflect"
+  "testing"
+)
+
+func TestParseRobots(t *testing.T) {
+  aRobotsTxt := `
+# an example robots.txt
+User-agent: *
+Disallow: /private/
+Allow: /private/public/
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: otherbot
+User-agent: searcher   # (our group)
+Disallow: /
+Allow: /docs/
+Crawl-delay: 0.5
+
+Sitemap: https://example.com/sitemap.xml
+`
+  someTests := []struct {
+    name       string
+    robotsTxt  string
+    userAgent  string
+    wantDelay  time.Duration
+    allowed    []string
+    disallowed []string
+  }{
+    { name : "our group", robotsTxt : aRobotsTxt, userAgent : "searcher/1.0",
+      wantDelay  : 500 * time.Millisecond,
+      allowed    : []string{ "/docs/", "/docs/a.html" },
+      disallowed : []string{ "/", "/private/a.html", "/other.html" },
+    },
+    { name : "the * group", robotsTxt : aRobotsTxt, userAgent : "anotherbot",
+      wantDelay  : 2 * time.Second,
+      allowed    : []string{ "/", "/private/public/a.html", "/a.pdf?x=1", "/docs/" },
+      disallowed : []string{ "/private/a.html", "/a.pdf", "/docs/b.pdf" },
+    },
+    { name : "no robots.txt", robotsTxt : "", userAgent : "searcher",
+      allowed : []string{ "/", "/private/a.html" },
+    },
+    { name : "an empty disallow", robotsTxt : "User-agent: *\nDisallow:\n",
+      userAgent : "searcher", allowed : []string{ "/", "/a.html" },
+    },
+    { name : "an allow wins a tie",
+      robotsTxt : "User-agent: *\nDisallow: /a\nAllow: /a\n", userAgent : "searcher",
+      allowed : []string{ "/a" },
+    },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      theRules := parseRobots(aTest.robotsTxt, aTest.userAgent)
+      if theRules.crawlDelay != aTest.wantDelay {
+        t.Errorf("got crawl delay %s, want %s", theRules.crawlDelay, aTest.wantDelay)
+      }
+      for _, aPath := range aTest.allowed {
+        if !theRules.isAllowed(aPath) { t.Errorf("[%s] should be allowed", aPath) }
+      }
+      for _, aPath := range aTest.disallowed {
+        if theRules.isAllowed(aPath) { t.Errorf("[%s] should be disallowed", aPath) }
+      }
+    })
+  }
+
+  theRules := parseRobots(aRobotsTxt, "searcher")
+  if !reflect.DeepEqual(theRules.sitemaps, []string{ "https://example.com/sitemap.xml" }) {
+    t.Errorf("got sitemaps %q", theRules.sitemaps)
+  }
+}
+
+func TestCrawlUrl(t *testing.T) {
+  someTests := []struct {
+    aUrl string
+    want string
+  }{
+    { "https://Example.COM",             "https://example.com/" },
+    { "https://example.com/a.html#top",  "https://example.com/a.html" },
+    { "https://example.com/a?b=c#d",     "https://example.com/a?b=c" },
+  }
+  for _, aTest := range someTests {
+    parsedUrl, err := url.Parse(aTest.aUrl)
+    if err != nil { t.Fatalf("could not parse [%s]: %s", aTest.aUrl, err) }
+    if got := crawlUrl(parsedUrl); got != aTest.want {
+      t.Errorf("crawlUrl(%q) = %q, want %q", aTest.aUrl, got, aTest.want)
+    }
+  }
+}
+
+func TestRobotsMetaDirectives(t *testing.T) {
+  someDirectives := robotsMetaDirectives(
+    `<head><meta name="robots" content="NoIndex, nofollow"></head>`,
+  )
+  if !someDirectives["noindex"] || !someDirectives["nofollow"] {
+    t.Errorf("got directives %v", someDirectives)
+  }
+  if 0 < len(robotsMetaDirectives(`<meta name="description" content="noindex">`)) {
+    t.Errorf("found directives in a description")
+  }
+}
+
+func TestSiteDelay(t *testing.T) {
+  theCrawler := siteCrawler{
+    delay    : 100 * time.Millisecond,
+    maxDelay : 30 * time.Second,
+  }
+  someTests := []struct {
+    name        string
+    robotsDelay time.Duration
+    want        time.Duration
+  }{
+    { "no crawl-delay",        0,                       100 * time.Millisecond },
+    { "a shorter crawl-delay", 10 * time.Millisecond,   100 * time.Millisecond },
+    { "a longer crawl-delay",  5 * time.Second,         5 * time.Second },
+    { "a capped crawl-delay",  24 * time.Hour,          30 * time.Second },
+  }
+  for _, aTest := range someTests {
+    if got := theCrawler.siteDelay(aTest.robotsDelay); got != aTest.want {
+      t.Errorf("%s: got a delay of %s, want %s", aTest.name, got, aTest.want)
+    }
+  }
+  if !waitBeforeFetching(time.Millisecond) {
+    t.Errorf("waiting was interrupted (without a shutdown)")
+  }
+}
+
+func TestRemoveUncrawledSites(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  someDocs := []indexedDocument{
+    { path: "https://kept.example.com/", title: "Kept", source: crawlSource },
+    { path: "https://kept.example.com/a", title: "Kept a", source: crawlSource },
+    { path: "http://kept.example.com/b", title: "Other scheme", source: crawlSource },
+    { path: "https://kept.example.com.evil/", title: "Other host", source: crawlSource },
+    { path: "https://gone.example.com/c", title: "Gone", source: crawlSource },
+    { path: "files/d.html", title: "File", source: fileSource },
+    { path: "push:e", title: "Pushed", source: pushSource },
+  }
+  for _, aDoc := range someDocs {
+    aDoc.url = aDoc.path
+    if err := upsertDocument(searchDB, aDoc); err != nil {
+      t.Fatalf("could not insert [%s]: %s", aDoc.path, err)
+    }
+  }
+
+  err := removeUncrawledSites(searchDB, []string{ "https://KEPT.example.com/start" })
+  if err != nil { t.Fatalf("could not remove the uncrawled sites: %s", err) }
+  want := "files/d.html https://kept.example.com/ https://kept.example.com/a push:e"
+  if got := documentPaths(t, searchDB); got != want {
+    t.Errorf("got documents [%s], want [%s]", got, want)
+  }
+
+  // with no seeds, every crawled page is removed
+  if err := removeUncrawledSites(searchDB, []string{}); err != nil {
+    t.Fatalf("could not remove the uncrawled sites: %s", err)
+  }
+  if got := documentPaths(t, searchDB); got != "files/d.html push:e" {
+    t.Errorf("got documents [%s], want [files/d.html push:e]", got)
+  }
+}
@@ -0,0 +1,405 @@
+package main
+
+/*
+
+  We evolve the structure of an existing database using a (numbered) list
+  of migrations. The number of migrations which have already been applied
+  is recorded in SQLite's `user_version` pragma.
+
+  A new database is created directly with the current structure (see:
+  databaseStructure), and its user_version is set to the number of
+  migrations, so the migrations are ONLY applied to existing databases.
+  A database with user_version 0 has exactly the original tables (fileInfo
+  and pageSearch). (Since version 4, the documents are stored in the
+  documents table, see: documents.go, and since version 5 they are indexed
+  using the documentText view, see: compression.go. Since version 8 the
+  index includes the anchor text of the links to each document, see:
+  links.go. Since version 9 the paths removed by the admin API are no
+  longer indexed, see: indexerStatus.go.)
+
+  NEVER change (or remove) a migration once it has been released, ONLY
+  append new ones. Whenever a migration is appended, databaseStructure
+  MUST be changed to match.
+
+*/
+
+import (
+  "fmt"
+  "database/sql"
+)
+
+type databaseMigration struct {
+  description string
+  apply       func(tx *sql.Tx) error
+}
+
+func migrationSql(someSql ...string) func(tx *sql.Tx) error {
+  return func(tx *sql.Tx) error {
+    for _, aSql := range someSql {
+      if _, err := tx.Exec(aSql); err != nil { return err }
+    }
+    return nil
+  }
+}
+
+var databaseMigrations []databaseMigration = []databaseMigration{
+  {
+    "add the pushedDocs table",
+    migrationSql(`
+      create table if not exists pushedDocs (
+        docPath  text not null primary key,
+        docId    text not null unique,
+        docUrl   text,
+        docType  text,
+        docMTime int
+      );
+    `),
+  },
+  {
+    "add the docClicks table",
+    migrationSql(`
+      create table if not exists docClicks (
+        docPath    text not null,
+        query      text not null,
+        clicks     int,
+        popularity real,
+        updatedAt  int,
+        primary key ( docPath, query )
+      );
+    `),
+  },
+  {
+    "record when documents are indexed, the index passes and saved searches",
+    migrationSql(
+      "alter table fileInfo add column indexedAt int;",
+      "alter table pushedDocs add column indexedAt int;",
+      `create table if not exists indexPasses (
+        passStart int,
+        passEnd   int
+      );`,
+      `create table if not exists savedSearches (
+        name      text not null primary key,
+        query     text not null,
+        createdAt int
+      );`,
+    ),
+  },
+  {
+    "move the indexed documents into the documents table (see: documents.go)",
+    migrationSql(
+      `create table documents (
+        docId     integer primary key,
+        docPath   text not null unique,
+        url       text,
+        title     text,
+        docType   text,
+        mtime     int,
+        size      int,
+        hash      text,
+        body      text,
+        source    text,
+        indexedAt int
+      );`,
+      `insert or ignore into documents
+        ( docPath, url, title, docType, mtime, size, body, source, indexedAt )
+        select pageSearch.filePath, pushedDocs.docUrl, pageSearch.fileTitle,
+            pushedDocs.docType,
+            coalesce(fileInfo.fileMTime, pushedDocs.docMTime),
+            fileInfo.fileSize, pageSearch.fileStr,
+            case when pushedDocs.docPath is null then 'file' else 'push' end,
+            coalesce(fileInfo.indexedAt, pushedDocs.indexedAt)
+          from pageSearch
+          left join fileInfo   on fileInfo.filePath   = pageSearch.filePath
+          left join pushedDocs on pushedDocs.docPath  = pageSearch.filePath ;`,
+      `create virtual table documentSearch using fts5(
+        title,
+        body,
+        content='documents',
+        content_rowid='docId'
+      );`,
+      `create trigger documentsInserted after insert on documents begin
+        insert into documentSearch ( rowid, title, body )
+          values ( new.docId, new.title, new.body );
+      end;`,
+      `create trigger documentsDeleted after delete on documents begin
+        insert into documentSearch ( documentSearch, rowid, title, body )
+          values ( 'delete', old.docId, old.title, old.body );
+      end;`,
+      `create trigger documentsUpdated after update of title, body on documents begin
+        insert into documentSearch ( documentSearch, rowid, title, body )
+          values ( 'delete', old.docId, old.title, old.body );
+        insert into documentSearch ( rowid, title, body )
+          values ( new.docId, new.title, new.body );
+      end;`,
+      "insert into documentSearch ( documentSearch ) values ( 'rebuild' );",
+      "drop table pageSearch;",
+      "drop table fileInfo;",
+      "drop table pushedDocs;",
+    ),
+  },
+  {
+    "index the (possibly compressed) documents using the documentText view (see: compression.go)",
+    migrationSql(
+      "drop trigger documentsInserted;",
+      "drop trigger documentsDeleted;",
+      "drop trigger documentsUpdated;",
+      "drop table documentSearch;",
+      `create view documentText as
+        select docId, title, searcherDecompress(body) as body from documents ;`,
+      `create virtual table documentSearch using fts5(
+        title,
+        body,
+        content='documentText',
+        content_rowid='docId'
+      );`,
+      `create trigger documentsInserted after insert on documents begin
+        insert into documentSearch ( rowid, title, body )
+          values ( new.docId, new.title, searcherDecompress(new.body) );
+      end;`,
+      `create trigger documentsDeleted after delete on documents begin
+        insert into documentSearch ( documentSearch, rowid, title, body )
+          values ( 'delete', old.docId, old.title, searcherDecompress(old.body) );
+      end;`,
+      // (re)compressing a body does not change the indexed text
+      `create trigger documentsUpdated after update of title, body on documents
+        when old.title is not new.title
+          or searcherDecompress(old.body) is not searcherDecompress(new.body)
+      begin
+        insert into documentSearch ( documentSearch, rowid, title, body )
+          values ( 'delete', old.docId, old.title, searcherDecompress(old.body) );
+        insert into documentSearch ( rowid, title, body )
+          values ( new.docId, new.title, searcherDecompress(new.body) );
+      end;`,
+      "insert into documentSearch ( documentSearch ) values ( 'rebuild' );",
+    ),
+  },
+  {
+    "record the number of files added, updated, removed, skipped and failed by each index pass",
+    migrationSql(
+      "alter table indexPasses add column filesAdded int;",
+      "alter table indexPasses add column filesUpdated int;",
+      "alter table indexPasses add column filesRemoved int;",
+      "alter table indexPasses add column filesSkipped int;",
+      "alter table indexPasses add column filesFailed int;",
+    ),
+  },
+  {
+    "record the ETag, Last-Modified and depth of each crawled page",
+    migrationSql(
+      "alter table documents add column etag text;",
+      "alter table documents add column lastModified text;",
+      "alter table documents add column crawlDepth int;",
+    ),
+  },
+  {
+    "record the links between documents and index their anchor text (see: links.go)",
+    migrationSql(
+      `create table links (
+        fromDocId  int not null,
+        toPath     text not null,
+        anchorText text
+      );`,
+      "create index linksFrom on links(fromDocId);",
+      "create index linksTo on links(toPath);",
+      "create index documentUrls on documents(url);",
+      "alter table documents add column anchors text;",
+      "alter table documents add column linkScore real;",
+      "drop trigger documentsInserted;",
+      "drop trigger documentsDeleted;",
+      "drop trigger documentsUpdated;",
+      "drop table documentSearch;",
+      "drop view documentText;",
+      `create view documentText as
+        select docId, title, searcherDecompress(body) as body, anchors
+          from documents ;`,
+      `create virtual table documentSearch using fts5(
+        title,
+        body,
+        anchors,
+        content='documentText',
+        content_rowid='docId'
+      );`,
+      `create trigger documentsInserted after insert on documents begin
+        insert into documentSearch ( rowid, title, body, anchors )
+          values ( new.docId, new.title, searcherDecompress(new.body), new.anchors );
+      end;`,
+      `create trigger documentsDeleted after delete on documents begin
+        insert into documentSearch ( documentSearch, rowid, title, body, anchors )
+          values ( 'delete', old.docId, old.title, searcherDecompress(old.body), old.anchors );
+        delete from links where fromDocId = old.docId ;
+      end;`,
+      // (re)compressing a body does not change the indexed text
+      `create trigger documentsUpdated after update of title, body, anchors on documents
+        when old.title is not new.title
+          or old.anchors is not new.anchors
+          or searcherDecompress(old.body) is not searcherDecompress(new.body)
+      begin
+        insert into documentSearch ( documentSearch, rowid, title, body, anchors )
+          values ( 'delete', old.docId, old.title, searcherDecompress(old.body), old.anchors );
+        insert into documentSearch ( rowid, title, body, anchors )
+          values ( new.docId, new.title, searcherDecompress(new.body), new.anchors );
+      end;`,
+      "insert into documentSearch ( documentSearch ) values ( 'rebuild' );",
+    ),
+  },
+  {
+    "record the paths removed by the admin API (see: indexerStatus.go)",
+    migrationSql(`
+      create table if not exists excludedPaths (
+        path       text not null primary key,
+        excludedAt int
+      );
+    `),
+  },
+}
+
+// The current structure of the database, equivalent to the original
+// tables with all of the databaseMigrations applied (see:
+// TestDatabaseStructure).
+//
+var databaseStructure []string = []string{
+  `create table documents (
+    docId        integer primary key,
+    docPath      text not null unique,
+    url          text,
+    title        text,
+    docType      text,
+    mtime        int,
+    size         int,
+    hash         text,
+    body         text,
+    source       text,
+    indexedAt    int,
+    etag         text,
+    lastModified text,
+    crawlDepth   int,
+    anchors      text,
+    linkScore    real
+  );`,
+  "create index documentUrls on documents(url);",
+  `create view documentText as
+    select docId, title, searcherDecompress(body) as body, anchors
+      from documents ;`,
+  `create virtual table documentSearch using fts5(
+    title,
+    body,
+    anchors,
+    content='documentText',
+    content_rowid='docId'
+  );`,
+  `create trigger documentsInserted after insert on documents begin
+    insert into documentSearch ( rowid, title, body, anchors )
+      values ( new.docId, new.title, searcherDecompress(new.body), new.anchors );
+  end;`,
+  `create trigger documentsDeleted after delete on documents begin
+    insert into documentSearch ( documentSearch, rowid, title, body, anchors )
+      values ( 'delete', old.docId, old.title, searcherDecompress(old.body), old.anchors );
+    delete from links where fromDocId = old.docId ;
+  end;`,
+  // (re)compressing a body does not change the indexed text
+  `create trigger documentsUpdated after update of title, body, anchors on documents
+    when old.title is not new.title
+      or old.anchors is not new.anchors
+      or searcherDecompress(old.body) is not searcherDecompress(new.body)
+  begin
+    insert into documentSearch ( documentSearch, rowid, title, body, anchors )
+      values ( 'delete', old.docId, old.title, searcherDecompress(old.body), old.anchors );
+    insert into documentSearch ( rowid, title, body, anchors )
+      values ( new.docId, new.title, searcherDecompress(new.body), new.anchors );
+  end;`,
+  `create table links (
+    fromDocId  int not null,
+    toPath     text not null,
+    anchorText text
+  );`,
+  "create index linksFrom on links(fromDocId);",
+  "create index linksTo on links(toPath);",
+  `create table docClicks (
+    docPath    text not null,
+    query      text not null,
+    clicks     int,
+    popularity real,
+    updatedAt  int,
+    primary key ( docPath, query )
+  );`,
+  `create table indexPasses (
+    passStart    int,
+    passEnd      int,
+    filesAdded   int,
+    filesUpdated int,
+    filesRemoved int,
+    filesSkipped int,
+    filesFailed  int
+  );`,
+  `create table savedSearches (
+    name      text not null primary key,
+    query     text not null,
+    createdAt int
+  );`,
+  `create table excludedPaths (
+    path       text not null primary key,
+    excludedAt int
+  );`,
+}
+
+// Create the current structure in a new (empty) database, recording that
+// none of the migrations need to be applied.
+//
+func createDatabaseStructure(searchDB *sql.DB) error {
+  tx, err := searchDB.Begin()
+  if err != nil {
+    return fmt.Errorf("could not start the creation transaction: %s", err)
+  }
+  if err = migrationSql(databaseStructure...)(tx); err != nil {
+    tx.Rollback()
+    return fmt.Errorf("could not create the database structure: %s", err)
+  }
+  // pragmas can not be parameterised
+  _, err = tx.Exec(fmt.Sprintf("pragma user_version = %d;", len(databaseMigrations)))
+  if err != nil {
+    tx.Rollback()
+    return fmt.Errorf("could not set database version: %s", err)
+  }
+  return tx.Commit()
+}
+
+func getDatabaseVersion(searchDB *sql.DB) (int, error) {
+  var version int
+  err := searchDB.QueryRow("pragma user_version;").Scan(&version)
+  return version, err
+}
+
+// Apply (in order, each in its own transaction) any migrations which have
+// not yet been applied to this database.
+//
+func migrateDatabase(searchDB *sql.DB) error {
+  version, err := getDatabaseVersion(searchDB)
+  if err != nil {
+    return fmt.Errorf("could not get database version: %s", err)
+  }
+
+  for ; version < len(databaseMigrations); version++ {
+    aMigration := databaseMigrations[version]
+    IndexerLogf("migrating database to version %d: %s",
+      version+1, aMigration.description,
+    )
+    tx, err := searchDB.Begin()
+    if err != nil {
+      return fmt.Errorf("could not start migration transaction: %s", err)
+    }
+    if err = aMigration.apply(tx); err != nil {
+      tx.Rollback()
+      return fmt.Errorf("could not %s: %s", aMigration.description, err)
+    }
+    // pragmas can not be parameterised
+    _, err = tx.Exec(fmt.Sprintf("pragma user_version = %d;", version+1))
+    if err != nil {
+      tx.Rollback()
+      return fmt.Errorf("could not set database version: %s", err)
+    }
+    if err = tx.Commit(); err != nil {
+      return fmt.Errorf("could not commit migration: %s", err)
+    }
+  }
+  return nil
+}
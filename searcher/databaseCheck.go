@@ -0,0 +1,265 @@
+package main
+
+/*
+
+  We check (and optionally repair) the search database using:
+
+    searcher -c <configFile> check [--repair]
+
+  The check:
+
+    - runs SQLite's `pragma integrity_check`,
+
+    - runs the FTS5 `integrity-check` of the documentSearch index against
+      its content (the documents),
+
+    - looks for documents which are missing from the documentSearch index
+      and for index entries whose document no longer exists (since
+      version 4 of the database, the documents and their text are stored
+      in ONE table, so the index is the only thing which can drift),
+
+    - looks for (indexed) files which no longer exist, and for click
+      counts of documents which no longer exist.
+
+  With --repair:
+
+    - a corrupt database has its indices rebuilt (`reindex`), if that
+      does not help, the database should be restored from a backup,
+
+    - documents missing from the index are (re)indexed,
+
+    - if the index has entries for missing documents (or still fails its
+      integrity-check) the whole index is rebuilt from the documents,
+
+    - missing files and orphaned click counts are removed.
+
+  The command exits with 0 if there are no (remaining) problems.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "database/sql"
+)
+
+type databaseCheck struct {
+  integrity        []string
+  indexIntegrity   error
+  missingFromIndex map[int64]string
+  orphanedIndex    int64
+  missingFiles     []string
+  orphanedClicks   int64
+}
+
+func (theCheck *databaseCheck) numProblems() int {
+  numProblems := len(theCheck.integrity) + len(theCheck.missingFromIndex) +
+    len(theCheck.missingFiles)
+  if theCheck.indexIntegrity != nil { numProblems = numProblems + 1 }
+  if 0 < theCheck.orphanedIndex     { numProblems = numProblems + 1 }
+  if 0 < theCheck.orphanedClicks    { numProblems = numProblems + 1 }
+  return numProblems
+}
+
+// Run SQLite's integrity check (returning any problems found).
+//
+func checkIntegrity(searchDB *sql.DB) ([]string, error) {
+  rows, err := searchDB.Query("pragma integrity_check;")
+  if err != nil { return nil, err }
+  defer rows.Close()
+  someProblems := []string{}
+  for rows.Next() {
+    var aResult string
+    if err = rows.Scan(&aResult); err != nil { return nil, err }
+    if aResult != "ok" { someProblems = append(someProblems, aResult) }
+  }
+  return someProblems, rows.Err()
+}
+
+// Run the FTS5 integrity-check (comparing the index with its content).
+//
+func checkIndexIntegrity(searchDB *sql.DB) error {
+  _, err := searchDB.Exec(`
+    insert into documentSearch ( documentSearch, rank ) values ( 'integrity-check', 1 );
+  `)
+  return err
+}
+
+func findDocumentsMissingFromIndex(searchDB *sql.DB) (map[int64]string, error) {
+  rows, err := searchDB.Query(`
+    select docId, docPath from documents
+      where docId not in ( select id from documentSearch_docsize ) ;
+  `)
+  if err != nil { return nil, err }
+  defer rows.Close()
+  missingDocs := map[int64]string{}
+  for rows.Next() {
+    var docId   int64
+    var docPath string
+    if err = rows.Scan(&docId, &docPath); err != nil { return nil, err }
+    missingDocs[docId] = docPath
+  }
+  return missingDocs, rows.Err()
+}
+
+func countOrphans(searchDB *sql.DB, orphanSql string) (int64, error) {
+  var numOrphans int64
+  err := searchDB.QueryRow(orphanSql).Scan(&numOrphans)
+  return numOrphans, err
+}
+
+func findMissingFiles(searchDB *sql.DB) ([]string, error) {
+  rows, err := searchDB.Query(
+    "select docPath from documents where source = ?", fileSource,
+  )
+  if err != nil { return nil, err }
+  defer rows.Close()
+  missingFiles := []string{}
+  for rows.Next() {
+    var aPath string
+    if err = rows.Scan(&aPath); err != nil { return nil, err }
+    if _, err := os.Stat(aPath); os.IsNotExist(err) {
+      missingFiles = append(missingFiles, aPath)
+    }
+  }
+  return missingFiles, rows.Err()
+}
+
+const (
+  orphanedIndexSql = `
+    select count(*) from documentSearch_docsize
+      where id not in ( select docId from documents ) ;`
+  orphanedClicksSql = `
+    select count(*) from docClicks
+      where docPath not in ( select docPath from documents ) ;`
+)
+
+func checkDatabase(searchDB *sql.DB) (databaseCheck, error) {
+  var theCheck databaseCheck
+  var err error
+  if theCheck.integrity, err = checkIntegrity(searchDB); err != nil {
+    return theCheck, fmt.Errorf("could not check the database's integrity: %s", err)
+  }
+  theCheck.indexIntegrity = checkIndexIntegrity(searchDB)
+  if theCheck.missingFromIndex, err = findDocumentsMissingFromIndex(searchDB); err != nil {
+    return theCheck, fmt.Errorf("could not look for unindexed documents: %s", err)
+  }
+  if theCheck.orphanedIndex, err = countOrphans(searchDB, orphanedIndexSql); err != nil {
+    return theCheck, fmt.Errorf("could not look for orphaned index entries: %s", err)
+  }
+  if theCheck.missingFiles, err = findMissingFiles(searchDB); err != nil {
+    return theCheck, fmt.Errorf("could not look for missing files: %s", err)
+  }
+  if theCheck.orphanedClicks, err = countOrphans(searchDB, orphanedClicksSql); err != nil {
+    return theCheck, fmt.Errorf("could not look for orphaned clicks: %s", err)
+  }
+  return theCheck, nil
+}
+
+func printDatabaseCheck(theCheck databaseCheck) {
+  for _, aProblem := range theCheck.integrity {
+    fmt.Printf("PROBLEM: integrity check: %s\n", aProblem)
+  }
+  if theCheck.indexIntegrity != nil {
+    fmt.Printf("PROBLEM: index integrity check: %s\n", theCheck.indexIntegrity)
+  }
+  for docId, docPath := range theCheck.missingFromIndex {
+    fmt.Printf("PROBLEM: document %d [%s] is not in the index\n", docId, docPath)
+  }
+  if 0 < theCheck.orphanedIndex {
+    fmt.Printf("PROBLEM: the index has %d entries without a document\n",
+      theCheck.orphanedIndex)
+  }
+  for _, aPath := range theCheck.missingFiles {
+    fmt.Printf("PROBLEM: the file [%s] no longer exists\n", aPath)
+  }
+  if 0 < theCheck.orphanedClicks {
+    fmt.Printf("PROBLEM: there are %d click counts without a document\n",
+      theCheck.orphanedClicks)
+  }
+}
+
+// Repair the problems found by a check.
+//
+func repairDatabase(searchDB *sql.DB, theCheck databaseCheck) error {
+  if 0 < len(theCheck.integrity) {
+    fmt.Println("REPAIR: rebuilding the database indices")
+    if _, err := searchDB.Exec("reindex;"); err != nil { return err }
+  }
+
+  for docId, docPath := range theCheck.missingFromIndex {
+    fmt.Printf("REPAIR: indexing document %d [%s]\n", docId, docPath)
+    _, err := searchDB.Exec(`
+      insert into documentSearch ( rowid, title, body, anchors )
+        select docId, title, searcherDecompress(body), anchors from documents
+          where docId = ? ;
+    `, docId)
+    if err != nil { return err }
+  }
+
+  if 0 < theCheck.orphanedIndex || theCheck.indexIntegrity != nil {
+    fmt.Println("REPAIR: rebuilding the full text index")
+    _, err := searchDB.Exec(
+      "insert into documentSearch ( documentSearch ) values ( 'rebuild' );",
+    )
+    if err != nil { return err }
+  }
+
+  for _, aPath := range theCheck.missingFiles {
+    fmt.Printf("REPAIR: removing [%s]\n", aPath)
+    if _, err := deleteDocument(searchDB, aPath); err != nil { return err }
+  }
+
+  if 0 < theCheck.orphanedClicks {
+    fmt.Println("REPAIR: removing the orphaned click counts")
+    _, err := searchDB.Exec(`
+      delete from docClicks where docPath not in ( select docPath from documents ) ;
+    `)
+    if err != nil { return err }
+  }
+  return nil
+}
+
+// The `check [--repair]` command.
+//
+func checkCommand(shouldRepair bool) int {
+  searchDB, err := openSearchDB(false)
+  if err != nil {
+    fmt.Printf("ERROR: could not open the database: %s\n", err)
+    return 1
+  }
+  defer searchDB.Close()
+
+  theCheck, err := checkDatabase(searchDB)
+  if err != nil {
+    fmt.Printf("ERROR: %s\n", err)
+    return 1
+  }
+  printDatabaseCheck(theCheck)
+  if theCheck.numProblems() < 1 {
+    fmt.Println("the database is ok")
+    return 0
+  }
+  if !shouldRepair {
+    fmt.Printf("found %d problems (use `check --repair` to repair them)\n",
+      theCheck.numProblems())
+    return 1
+  }
+
+  if err = repairDatabase(searchDB, theCheck); err != nil {
+    fmt.Printf("ERROR: could not repair the database: %s\n", err)
+    return 1
+  }
+  if theCheck, err = checkDatabase(searchDB); err != nil {
+    fmt.Printf("ERROR: %s\n", err)
+    return 1
+  }
+  printDatabaseCheck(theCheck)
+  if 0 < theCheck.numProblems() {
+    fmt.Printf("%d problems remain (consider restoring a backup)\n",
+      theCheck.numProblems())
+    return 1
+  }
+  fmt.Println("the database has been repaired")
+  return 0
+}
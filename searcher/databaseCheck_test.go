@@ -0,0 +1,86 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "path/filepath"
+  "database/sql"
+)
+
+func TestCheckRepair(t *testing.T) {
+  dataDir := t.TempDir()
+  dbPath  := filepath.Join(dataDir, "searcher.db")
+  setTestConfig(t, "DatabasePath="+dbPath)
+  searchDB, err := sql.Open(searchDBDriver, dbPath)
+  if err != nil { t.Fatalf("could not open the database: %s", err) }
+  defer searchDB.Close()
+  err = createDatabaseStructure(searchDB)
+  if err != nil && strings.Contains(err.Error(), "no such module") {
+    t.Skip("SQLite was built without fts5 (use: go test -tags fts5)")
+  }
+  if err != nil { t.Fatalf("could not create the database: %s", err) }
+
+  writeTestFiles(t, dataDir, map[string]string{
+    "a.html" : "gravity",
+    "b.html" : "badgers",
+  })
+  someDocs := []indexedDocument{
+    { path: filepath.Join(dataDir, "a.html"), title: "Gravity", kind: "html",
+      body: "all about gravity", source: fileSource },
+    { path: filepath.Join(dataDir, "b.html"), title: "Badgers", kind: "html",
+      body: "all about badgers", source: fileSource },
+    { path: filepath.Join(dataDir, "gone.html"), title: "Gone", kind: "html",
+      body: "all about gravel", source: fileSource },
+  }
+  for _, aDoc := range someDocs {
+    if err := upsertDocument(searchDB, aDoc); err != nil {
+      t.Fatalf("could not insert [%s]: %s", aDoc.path, err)
+    }
+  }
+  if got := checkCommand(false); got != 1 {
+    t.Errorf("check of a database with a missing file exited with %d, want 1", got)
+  }
+
+  // corrupt the index: drop the badgers from it, index a document which
+  // does not exist and count clicks on another
+  mustExec(t, searchDB, `
+    insert into documentSearch ( documentSearch, rowid, title, body, anchors )
+      select 'delete', docId, title, body, anchors from documents
+        where title = 'Badgers' ;
+  `)
+  mustExec(t, searchDB, `
+    insert into documentSearch ( rowid, title, body ) values ( 999, 'Ghost', 'ghostly gravity' );
+  `)
+  mustExec(t, searchDB, `insert into docClicks values ( 'missing.html', 'foo', 3, 3, 0 )`)
+
+  theCheck, err := checkDatabase(searchDB)
+  if err != nil { t.Fatalf("could not check the database: %s", err) }
+  if len(theCheck.missingFromIndex) != 1 || theCheck.orphanedIndex != 1 ||
+     len(theCheck.missingFiles) != 1 || theCheck.orphanedClicks != 1 {
+    t.Errorf("got check %+v, want one of each problem", theCheck)
+  }
+  if got := checkCommand(false); got != 1 {
+    t.Errorf("check of a corrupted database exited with %d, want 1", got)
+  }
+  if got := checkCommand(true); got != 0 {
+    t.Fatalf("check --repair exited with %d, want 0", got)
+  }
+  if got := checkCommand(false); got != 0 {
+    t.Errorf("check of a repaired database exited with %d, want 0", got)
+  }
+
+  for aQuery, wantPaths := range map[string]string{
+    "gravity" : filepath.Join(dataDir, "a.html"),
+    "badgers" : filepath.Join(dataDir, "b.html"),
+    "gravel"  : "",
+  } {
+    if got := strings.Join(searchDocPaths(t, searchDB, aQuery), " "); got != wantPaths {
+      t.Errorf("searching for %s got [%s], want [%s]", aQuery, got, wantPaths)
+    }
+  }
+  var numClicks int
+  if err := searchDB.QueryRow("select count(*) from docClicks").Scan(&numClicks); err != nil {
+    t.Fatalf("could not count the clicks: %s", err)
+  }
+  if numClicks != 0 { t.Errorf("got %d click counts, want 0", numClicks) }
+}
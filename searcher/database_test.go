@@ -0,0 +1,257 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+  "path/filepath"
+  "database/sql"
+)
+
+// Open a new (empty) database with the original (user_version 0) tables,
+// as created by the first releases of the searcher. Skips the test if SQLite was built
+// without fts5 (use: go test -tags fts5).
+//
+func openVersion0Database(t *testing.T) *sql.DB {
+  t.Helper()
+  searchDB, err := sql.Open(searchDBDriver, filepath.Join(t.TempDir(), "searcher.db"))
+  if err != nil { t.Fatalf("could not open the database: %s", err) }
+  searchDB.SetMaxOpenConns(1)
+  t.Cleanup(func() { searchDB.Close() })
+
+  _, err = searchDB.Exec(`
+    create table fileInfo (
+      filePath  text not null primary key,
+      fileMTime int,
+      fileSize  int
+    );
+    create index filePaths ON fileInfo(filePath);
+  `)
+  if err != nil { t.Fatalf("could not create fileInfo: %s", err) }
+  _, err = searchDB.Exec(`
+    create virtual table pageSearch using fts5(
+      filePath,
+      fileTitle,
+      fileStr
+    );
+  `)
+  if err != nil && strings.Contains(err.Error(), "no such module") {
+    t.Skip("SQLite was built without fts5 (use: go test -tags fts5)")
+  }
+  if err != nil { t.Fatalf("could not create pageSearch: %s", err) }
+  return searchDB
+}
+
+// Open a new, fully migrated, database.
+//
+func openTestSearchDB(t *testing.T) *sql.DB {
+  t.Helper()
+  searchDB := openVersion0Database(t)
+  if err := migrateDatabase(searchDB); err != nil {
+    t.Fatalf("could not migrate the database: %s", err)
+  }
+  return searchDB
+}
+
+func mustExec(t *testing.T, searchDB *sql.DB, aSql string, someArgs ...interface{}) {
+  t.Helper()
+  if _, err := searchDB.Exec(aSql, someArgs...); err != nil {
+    t.Fatalf("could not exec [%s]: %s", aSql, err)
+  }
+}
+
+func searchDocPaths(t *testing.T, searchDB *sql.DB, aQuery string) []string {
+  t.Helper()
+  rows, err := searchDB.Query(`
+    select documents.docPath from documentSearch
+      join documents on documents.docId = documentSearch.rowid
+      where documentSearch match ?
+      order by documents.docPath
+  `, aQuery)
+  if err != nil { t.Fatalf("could not search for [%s]: %s", aQuery, err) }
+  defer rows.Close()
+  somePaths := []string{}
+  for rows.Next() {
+    var aPath string
+    if err := rows.Scan(&aPath); err != nil { t.Fatalf("could not scan: %s", err) }
+    somePaths = append(somePaths, aPath)
+  }
+  return somePaths
+}
+
+func TestMigrateDatabase(t *testing.T) {
+  searchDB := openVersion0Database(t)
+  mustExec(t, searchDB,
+    "insert into fileInfo values ( 'files/a.html', 1000, 42 )")
+  mustExec(t, searchDB,
+    "insert into pageSearch values ( 'files/a.html', 'Aardvarks', 'all about aardvarks' )")
+
+  // stop part way (before the documents table), so that a pushed document
+  // can be added
+  allMigrations := databaseMigrations
+  databaseMigrations = allMigrations[:3]
+  err := migrateDatabase(searchDB)
+  databaseMigrations = allMigrations
+  if err != nil { t.Fatalf("could not migrate to version 3: %s", err) }
+  mustExec(t, searchDB, `insert into pushedDocs values
+    ( 'push:b', 'b', 'https://example.com/b', 'memo', 2000, 3000 )`)
+  mustExec(t, searchDB,
+    "insert into pageSearch values ( 'push:b', 'Badgers', 'all about badgers' )")
+
+  if err := migrateDatabase(searchDB); err != nil {
+    t.Fatalf("could not migrate: %s", err)
+  }
+  version, err := getDatabaseVersion(searchDB)
+  if err != nil || version != len(databaseMigrations) {
+    t.Fatalf("got version %d (%v), want %d", version, err, len(databaseMigrations))
+  }
+  // migrating an up to date database does nothing
+  if err := migrateDatabase(searchDB); err != nil {
+    t.Fatalf("could not re-migrate: %s", err)
+  }
+
+  someDocuments := []struct {
+    docPath string
+    url     string
+    mtime   int64
+    size    int64
+    source  string
+  }{
+    { "files/a.html", "",                      1000, 42, fileSource },
+    { "push:b",       "https://example.com/b", 2000, 0,  pushSource },
+  }
+  for _, aDoc := range someDocuments {
+    var docUrl sql.NullString
+    var mtime, size sql.NullInt64
+    var source string
+    err := searchDB.QueryRow(
+      "select url, mtime, size, source from documents where docPath = ?", aDoc.docPath,
+    ).Scan(&docUrl, &mtime, &size, &source)
+    if err != nil { t.Fatalf("could not find [%s]: %s", aDoc.docPath, err) }
+    if docUrl.String != aDoc.url || mtime.Int64 != aDoc.mtime ||
+      size.Int64 != aDoc.size || source != aDoc.source {
+      t.Errorf("[%s] got ( %q, %d, %d, %q )", aDoc.docPath,
+        docUrl.String, mtime.Int64, size.Int64, source)
+    }
+  }
+
+  someSearches := []struct {
+    query string
+    want  string
+  }{
+    { "aardvarks",        "files/a.html" },
+    { "title:badgers",    "push:b" },
+    { "anchors:wombats",  "" },
+  }
+  for _, aSearch := range someSearches {
+    got := strings.Join(searchDocPaths(t, searchDB, aSearch.query), " ")
+    if got != aSearch.want {
+      t.Errorf("search [%s] got [%s], want [%s]", aSearch.query, got, aSearch.want)
+    }
+  }
+}
+
+func TestMigratedTriggers(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  err := upsertDocument(searchDB, indexedDocument{
+    path : "files/c.html", title : "Cats", body : "all about cats", source : fileSource,
+  })
+  if err != nil { t.Fatalf("could not insert a document: %s", err) }
+  mustExec(t, searchDB,
+    "update documents set anchors = 'wombats' where docPath = 'files/c.html'")
+  mustExec(t, searchDB, `insert into links
+    select docId, 'files/d.html', 'dogs' from documents where docPath = 'files/c.html'`)
+
+  someSteps := []struct {
+    query string
+    want  string
+  }{
+    { "cats",            "files/c.html" },
+    { "anchors:wombats", "files/c.html" },
+  }
+  for _, aStep := range someSteps {
+    got := strings.Join(searchDocPaths(t, searchDB, aStep.query), " ")
+    if got != aStep.want {
+      t.Errorf("search [%s] got [%s], want [%s]", aStep.query, got, aStep.want)
+    }
+  }
+
+  if _, err := deleteDocument(searchDB, "files/c.html"); err != nil {
+    t.Fatalf("could not delete a document: %s", err)
+  }
+  if got := searchDocPaths(t, searchDB, "cats OR wombats"); 0 < len(got) {
+    t.Errorf("a deleted document is still found: %q", got)
+  }
+  var numLinks int
+  searchDB.QueryRow("select count(*) from links").Scan(&numLinks)
+  if numLinks != 0 {
+    t.Errorf("a deleted document's %d links were kept", numLinks)
+  }
+}
+
+// A description of a database's structure: its tables' and views' columns
+// and the (whitespace normalised) sql of its indexes, triggers and views.
+//
+func describeDatabaseStructure(t *testing.T, searchDB *sql.DB) []string {
+  t.Helper()
+  someRows, err := searchDB.Query(`
+    select type, name, coalesce(sql, '') from sqlite_master
+      where name not like 'sqlite_%' order by type, name ;
+  `)
+  if err != nil { t.Fatalf("could not list the database structure: %s", err) }
+  type schemaEntry struct { kind, name, sql string }
+  someEntries := []schemaEntry{}
+  for someRows.Next() {
+    var anEntry schemaEntry
+    if err := someRows.Scan(&anEntry.kind, &anEntry.name, &anEntry.sql); err != nil {
+      t.Fatalf("could not scan the database structure: %s", err)
+    }
+    someEntries = append(someEntries, anEntry)
+  }
+  someRows.Close()
+
+  aStructure := []string{}
+  for _, anEntry := range someEntries {
+    if anEntry.kind == "table" && !strings.Contains(anEntry.sql, "VIRTUAL") {
+      colRows, err := searchDB.Query("select name, type, \"notnull\", pk from pragma_table_info(?)", anEntry.name)
+      if err != nil { t.Fatalf("could not list the columns of %s: %s", anEntry.name, err) }
+      for colRows.Next() {
+        var colName, colType string
+        var notNull, primaryKey int
+        if err := colRows.Scan(&colName, &colType, &notNull, &primaryKey); err != nil {
+          t.Fatalf("could not scan the columns of %s: %s", anEntry.name, err)
+        }
+        aStructure = append(aStructure, fmt.Sprintf(
+          "table %s: %s %s %d %d", anEntry.name, colName, colType, notNull, primaryKey,
+        ))
+      }
+      colRows.Close()
+      continue
+    }
+    aStructure = append(aStructure, anEntry.kind+" "+anEntry.name+": "+
+      strings.Join(strings.Fields(strings.ToLower(anEntry.sql)), " "))
+  }
+  return aStructure
+}
+
+func TestDatabaseStructure(t *testing.T) {
+  migratedDB := openTestSearchDB(t)
+
+  newDB, err := sql.Open(searchDBDriver, filepath.Join(t.TempDir(), "searcher.db"))
+  if err != nil { t.Fatalf("could not open the database: %s", err) }
+  defer newDB.Close()
+  if err := createDatabaseStructure(newDB); err != nil {
+    t.Fatalf("could not create the database structure: %s", err)
+  }
+  version, err := getDatabaseVersion(newDB)
+  if err != nil || version != len(databaseMigrations) {
+    t.Fatalf("got version %d (%v), want %d", version, err, len(databaseMigrations))
+  }
+
+  gotStructure  := describeDatabaseStructure(t, newDB)
+  wantStructure := describeDatabaseStructure(t, migratedDB)
+  if strings.Join(gotStructure, "\n") != strings.Join(wantStructure, "\n") {
+    t.Errorf("the new database structure:\n  %s\ndoes not match the migrated structure:\n  %s",
+      strings.Join(gotStructure, "\n  "), strings.Join(wantStructure, "\n  "))
+  }
+}
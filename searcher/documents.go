@@ -0,0 +1,168 @@
+package main
+
+/*
+
+  Every indexed document (whether an indexed file, a pushed document or a
+  crawled page) is stored, once, in the documents table:
+
+    docId     : an integer id (also the rowid of the document in the
+                documentSearch index)
+    docPath   : the file's path (or push:<id> for a pushed document, or
+                the url of a crawled page)
+    url       : the url of a pushed document, crawled page or a file in a
+                sitemap root (the url of any other file is derived from
+                its path, the HtmlDirs and the UrlBase)
+    title     : the document's title
+    docType   : the (display) type of a pushed document or crawled page
+    mtime     : when the document was last modified (unix seconds)
+    size      : the size of the file
+    hash      : a hash of the extracted title and text
+    body      : the extracted text (possibly compressed, see:
+                compression.go)
+    source    : "file", "push" or "crawl"
+    indexedAt : when the document was last (re)indexed (unix seconds)
+
+  Crawled pages also record (see: crawler.go):
+
+    etag         : the page's ETag header
+    lastModified : the page's Last-Modified header
+    crawlDepth   : how many links the page was from its seed
+
+  And every document records (see: links.go):
+
+    anchors      : the anchor text of the links to the document
+    linkScore    : the (PageRank) score of the links to the document
+
+  The full text index (documentSearch) is an FTS5 table using the
+  documents (by way of the documentText view) as its external content, so the text is NOT stored
+  twice. The index is kept in sync with the documents table by triggers
+  (see: databaseMigrations), and all changes are made by docId (the
+  documents are only found by their (unique) docPath).
+
+  The links in each document are recorded in the links table (which the
+  delete trigger keeps in sync with the documents).
+
+  NOTE: documents MUST be changed using upsertDocument (an update) rather
+  than `insert or replace`, since replacing a row does not fire the delete
+  triggers.
+
+*/
+
+import (
+  "fmt"
+  "time"
+  "crypto/sha256"
+  "database/sql"
+)
+
+const (
+  fileSource  = "file"
+  pushSource  = "push"
+  crawlSource = "crawl"
+)
+
+type indexedDocument struct {
+  path   string
+  url    string
+  title  string
+  kind   string
+  mtime  int64
+  size   int64
+  body   string
+  source string
+}
+
+// A hash of the (extracted) contents of a document, used to avoid
+// reindexing documents whose contents have not changed.
+//
+func documentHash(aTitle string, aBody string) string {
+  return fmt.Sprintf("%x", sha256.Sum256([]byte(aTitle+"\x00"+aBody)))
+}
+
+// Find the modification time, size and hash of an indexed document.
+// Returns false if the document has not been indexed.
+//
+func findDocumentInfo(
+  searchDB *sql.DB, aPath string,
+) (int64, int64, string, bool, error) {
+  var mtime, size sql.NullInt64
+  var hash        sql.NullString
+  err := searchDB.QueryRow(
+    "select mtime, size, hash from documents where docPath = ?", aPath,
+  ).Scan(&mtime, &size, &hash)
+  if err == sql.ErrNoRows { return 0, 0, "", false, nil }
+  if err != nil { return 0, 0, "", false, err }
+  return mtime.Int64, size.Int64, hash.String, true, nil
+}
+
+// Anything which can execute sql (a database or a transaction).
+//
+type sqlExecer interface {
+  Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Insert (or update) a document (the documentSearch index is updated by
+// the triggers).
+//
+func upsertDocument(searchDB sqlExecer, aDoc indexedDocument) error {
+  body, err := documentBody(aDoc.body)
+  if err != nil { return fmt.Errorf("could not compress the body: %s", err) }
+  _, err = searchDB.Exec(`
+    insert into documents
+      ( docPath, url, title, docType, mtime, size, hash, body, source, indexedAt )
+      values ( ?, ?, ?, ?, ?, ?, ?, ?, ?, ? )
+      on conflict ( docPath ) do update set
+        url       = excluded.url,
+        title     = excluded.title,
+        docType   = excluded.docType,
+        mtime     = excluded.mtime,
+        size      = excluded.size,
+        hash      = excluded.hash,
+        body      = excluded.body,
+        source    = excluded.source,
+        indexedAt = excluded.indexedAt
+  `, aDoc.path, aDoc.url, aDoc.title, aDoc.kind, aDoc.mtime, aDoc.size,
+    documentHash(aDoc.title, aDoc.body), body, aDoc.source, time.Now().Unix(),
+  )
+  if err == nil { markLinkGraphChanged() }
+  return err
+}
+
+// Record a document's new url, modification time and size (when its
+// contents have not changed).
+//
+func touchDocument(
+  searchDB *sql.DB, aPath string, aUrl string, mtime int64, size int64,
+) error {
+  _, err := searchDB.Exec(
+    "update documents set url = ?, mtime = ?, size = ? where docPath = ?",
+    aUrl, mtime, size, aPath,
+  )
+  return err
+}
+
+// Remove a document (and its clicks). Returns false if there was no such
+// document.
+//
+func deleteDocument(searchDB *sql.DB, aPath string) (bool, error) {
+  transaction, err := searchDB.Begin()
+  if err != nil {
+    return false, fmt.Errorf("could not start deletion transaction: %s", err)
+  }
+  aResult, err := transaction.Exec("delete from documents where docPath = ?", aPath)
+  if err != nil {
+    transaction.Rollback()
+    return false, fmt.Errorf("could not delete from documents: %s", err)
+  }
+  _, err = transaction.Exec("delete from docClicks where docPath = ?", aPath)
+  if err != nil {
+    transaction.Rollback()
+    return false, fmt.Errorf("could not delete from docClicks: %s", err)
+  }
+  if err = transaction.Commit(); err != nil {
+    return false, fmt.Errorf("could not commit deletion transaction: %s", err)
+  }
+  numDeleted, _ := aResult.RowsAffected()
+  if 0 < numDeleted { markLinkGraphChanged() }
+  return 0 < numDeleted, nil
+}
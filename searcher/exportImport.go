@@ -0,0 +1,273 @@
+package main
+
+/*
+
+  We export (and import) the indexed documents in a portable format, so
+  that an index can be rebuilt on another machine (without depending upon
+  the SQLite file, its schema or tokenizer), the contents of two indexes
+  can be compared, or an index can be seeded from content produced
+  elsewhere:
+
+    searcher -c <configFile> export [path]   : (by default to stdout)
+    searcher -c <configFile> import <path>   : (use - for stdin)
+
+    GET /admin/export                        : the export (as NDJSON)
+
+  The format is NDJSON (one json object per line), one document per line,
+  ordered by path:
+
+    {
+      "path"   : "files/nginx/...html"  (or push:<id>, required)
+      "source" : "file"                 (or "push" or "crawl")
+      "url"    : "https://..."          (pushed or crawled documents only)
+      "title"  : "...",
+      "type"   : "I",                   (pushed or crawled documents only)
+      "mtime"  : "2021-11-02T10:00:00Z" (RFC3339 or unix seconds)
+      "size"   : 1234,
+      "text"   : "... (the extracted text) ..."
+    }
+
+  Imported documents are (re)indexed using their text (the files are NOT
+  read). If the source is missing, documents whose path starts with
+  `push:` are pushed documents, all others are files.
+
+  NOTE: the indexer removes any (imported) file which does not exist
+  (under the same path) on this machine. Use a "push" source (with a url)
+  to import documents whose files do not exist here.
+
+*/
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "time"
+  "bufio"
+  "strings"
+  "net/http"
+  "database/sql"
+  "encoding/json"
+  "github.com/tidwall/gjson"
+)
+
+type exportedDocument struct {
+  Path   string `json:"path"`
+  Source string `json:"source"`
+  Url    string `json:"url,omitempty"`
+  Title  string `json:"title"`
+  Type   string `json:"type,omitempty"`
+  MTime  string `json:"mtime,omitempty"`
+  Size   int64  `json:"size,omitempty"`
+  Text   string `json:"text"`
+}
+
+// Write every document (as NDJSON). Returns the number of documents
+// written.
+//
+func exportDocuments(searchDB *sql.DB, w io.Writer) (int, error) {
+  rows, err := searchDB.Query(`
+    select docPath, source, url, title, docType, mtime, size,
+        searcherDecompress(body)
+      from documents order by docPath
+  `)
+  if err != nil { return 0, err }
+  defer rows.Close()
+
+  encoder := json.NewEncoder(w)
+  encoder.SetEscapeHTML(false)
+  numDocs := 0
+  for rows.Next() {
+    var aDoc exportedDocument
+    var url, title, docType, body sql.NullString
+    var mtime, size              sql.NullInt64
+    err = rows.Scan(
+      &aDoc.Path, &aDoc.Source, &url, &title, &docType, &mtime, &size, &body,
+    )
+    if err != nil { return numDocs, err }
+    aDoc.Url   = url.String
+    aDoc.Title = title.String
+    aDoc.Type  = strings.TrimSpace(docType.String)
+    aDoc.Size  = size.Int64
+    aDoc.Text  = body.String
+    if mtime.Valid {
+      aDoc.MTime = time.Unix(mtime.Int64, 0).UTC().Format(time.RFC3339)
+    }
+    if err = encoder.Encode(aDoc); err != nil { return numDocs, err }
+    numDocs = numDocs + 1
+  }
+  return numDocs, rows.Err()
+}
+
+// Extract a document from (one line of) an export.
+//
+func parseExportedDocument(docJson gjson.Result) (indexedDocument, error) {
+  var aDoc indexedDocument
+  if !docJson.IsObject() {
+    return aDoc, fmt.Errorf("a document must be a json object")
+  }
+  aDoc.path   = docJson.Get("path").String()
+  aDoc.source = docJson.Get("source").String()
+  aDoc.url    = docJson.Get("url").String()
+  aDoc.title  = docJson.Get("title").String()
+  aDoc.kind   = docJson.Get("type").String()
+  aDoc.size   = docJson.Get("size").Int()
+  aDoc.body   = docJson.Get("text").String()
+  if len(aDoc.path) < 1 {
+    return aDoc, fmt.Errorf("a document must have a path")
+  }
+  if len(aDoc.source) < 1 {
+    aDoc.source = fileSource
+    if isPushedDocPath(aDoc.path) { aDoc.source = pushSource }
+  }
+  if aDoc.source != fileSource && aDoc.source != pushSource &&
+    aDoc.source != crawlSource {
+    return aDoc, fmt.Errorf(
+      "document [%s] has an unknown source [%s]", aDoc.path, aDoc.source,
+    )
+  }
+  if aDoc.source != fileSource {
+    if len(aDoc.url)  < 1 {
+      return aDoc, fmt.Errorf("%s document [%s] must have a url", aDoc.source, aDoc.path)
+    }
+    if len(aDoc.kind) < 1 { aDoc.kind = " " }
+  }
+  if len(aDoc.title) < 1 { aDoc.title = aDoc.path }
+
+  mtime := docJson.Get("mtime")
+  switch mtime.Type {
+    case gjson.Number : aDoc.mtime = mtime.Int()
+    case gjson.String :
+      aTime, err := time.Parse(time.RFC3339, mtime.String())
+      if err != nil {
+        return aDoc, fmt.Errorf(
+          "document [%s] has an invalid mtime: %s", aDoc.path, err,
+        )
+      }
+      aDoc.mtime = aTime.Unix()
+    default :
+      aDoc.mtime = time.Now().Unix()
+  }
+  return aDoc, nil
+}
+
+// Import (upsert) the documents in an export, in batches (each in its own
+// transaction). Problems with individual lines are reported (but do not
+// stop the import).
+//
+func importDocuments(
+  searchDB *sql.DB, ndjson io.Reader,
+) (int, []bulkIngestError, error) {
+  someErrors  := []bulkIngestError{}
+  lineScanner := bufio.NewScanner(ndjson)
+  lineScanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+  numImported := 0
+  lineNum     := 0
+  tx, err := searchDB.Begin()
+  if err != nil { return 0, someErrors, err }
+  numInBatch  := 0
+  for lineScanner.Scan() {
+    lineNum = lineNum + 1
+    aLine := strings.TrimSpace(lineScanner.Text())
+    if len(aLine) < 1 { continue }
+    aDoc, err := parseExportedDocument(gjson.Parse(aLine))
+    if err == nil { err = upsertDocument(tx, aDoc) }
+    if err != nil {
+      someErrors = append(someErrors, bulkIngestError{ lineNum, err.Error() })
+      continue
+    }
+    numInBatch = numInBatch + 1
+    if numInBatch < 1000 { continue }
+    if err = tx.Commit(); err != nil { return numImported, someErrors, err }
+    numImported = numImported + numInBatch
+    numInBatch  = 0
+    if tx, err = searchDB.Begin(); err != nil { return numImported, someErrors, err }
+  }
+  if err = lineScanner.Err(); err != nil {
+    tx.Rollback()
+    return numImported, someErrors, err
+  }
+  if err = tx.Commit(); err != nil { return numImported, someErrors, err }
+  return numImported + numInBatch, someErrors, nil
+}
+
+// The `export [path]` command.
+//
+func exportCommand(someArgs []string) int {
+  searchDB, err := openSearchDB(true)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "ERROR: could not open the database: %s\n", err)
+    return 1
+  }
+  defer searchDB.Close()
+
+  var exportFile io.Writer = os.Stdout
+  if 0 < len(someArgs) && someArgs[0] != "-" {
+    aFile, err := os.Create(someArgs[0])
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "ERROR: could not create the export: %s\n", err)
+      return 1
+    }
+    defer aFile.Close()
+    exportFile = aFile
+  }
+  bufferedFile := bufio.NewWriter(exportFile)
+  numDocs, err := exportDocuments(searchDB, bufferedFile)
+  if err == nil { err = bufferedFile.Flush() }
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "ERROR: could not export the documents: %s\n", err)
+    return 1
+  }
+  // (the export may be on stdout)
+  fmt.Fprintf(os.Stderr, "exported %d documents\n", numDocs)
+  return 0
+}
+
+// The `import <path>` command.
+//
+func importCommand(importPath string) int {
+  initDatabaseStructure()
+  searchDB, err := openSearchDB(false)
+  if err != nil {
+    fmt.Printf("ERROR: could not open the database: %s\n", err)
+    return 1
+  }
+  defer searchDB.Close()
+
+  var importFile io.Reader = os.Stdin
+  if importPath != "-" {
+    aFile, err := os.Open(importPath)
+    if err != nil {
+      fmt.Printf("ERROR: could not open the import: %s\n", err)
+      return 1
+    }
+    defer aFile.Close()
+    importFile = aFile
+  }
+  numDocs, someErrors, err := importDocuments(searchDB, importFile)
+  for _, anError := range someErrors {
+    fmt.Printf("ERROR: line %d: %s\n", anError.Line, anError.Message)
+  }
+  fmt.Printf("imported %d documents\n", numDocs)
+  if err != nil {
+    fmt.Printf("ERROR: could not import the documents: %s\n", err)
+    return 1
+  }
+  if 0 < len(someErrors) { return 1 }
+  return 0
+}
+
+func adminExportHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    w.Header().Set("Content-Disposition", `attachment; filename="searcher.ndjson"`)
+    numDocs, err := exportDocuments(searchDB, w)
+    WebserverMaybeError("could not export the documents", err)
+    WebserverLogf("admin: exported %d documents", numDocs)
+  }
+}
+
+func registerExportHandlers(mux *http.ServeMux, searchDB *sql.DB) {
+  mux.HandleFunc("/admin/export",
+    adminHandler(http.MethodGet, streamingHandler(adminExportHandler(searchDB))))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+  "github.com/tidwall/gjson"
+)
+
+func TestParseExportedDocument(t *testing.T) {
+  someTests := []struct {
+    name      string
+    docJson   string
+    wantError string
+    want      indexedDocument
+  }{
+    { name : "not an object", docJson : `"files/a.html"`,
+      wantError : "must be a json object" },
+    { name : "missing path", docJson : `{ "title" : "A" }`,
+      wantError : "must have a path" },
+    { name : "unknown source", docJson : `{ "path" : "a", "source" : "ftp" }`,
+      wantError : "unknown source [ftp]" },
+    { name : "pushed without a url", docJson : `{ "path" : "push:a" }`,
+      wantError : "push document [push:a] must have a url" },
+    { name : "invalid mtime", docJson : `{ "path" : "files/a.html", "mtime" : "today" }`,
+      wantError : "invalid mtime" },
+    { name : "file",
+      docJson : `{
+        "path" : "files/a.html", "title" : "A", "mtime" : "2021-11-02T10:00:00Z",
+        "size" : 12, "text" : "about a"
+      }`,
+      want : indexedDocument{
+        path : "files/a.html", title : "A", mtime : 1635847200, size : 12,
+        body : "about a", source : fileSource,
+      },
+    },
+    { name : "pushed (by its path)",
+      docJson : `{ "path" : "push:b", "url" : "https://example.com/b", "mtime" : 42 }`,
+      want : indexedDocument{
+        path : "push:b", url : "https://example.com/b", title : "push:b",
+        kind : " ", mtime : 42, source : pushSource,
+      },
+    },
+    { name : "crawled",
+      docJson : `{
+        "path" : "https://example.com/c", "source" : "crawl",
+        "url" : "https://example.com/c", "type" : "I", "mtime" : 7
+      }`,
+      want : indexedDocument{
+        path : "https://example.com/c", url : "https://example.com/c",
+        title : "https://example.com/c", kind : "I", mtime : 7, source : crawlSource,
+      },
+    },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aDoc, err := parseExportedDocument(gjson.Parse(aTest.docJson))
+      if 0 < len(aTest.wantError) {
+        if err == nil || !strings.Contains(err.Error(), aTest.wantError) {
+          t.Fatalf("expected an error containing [%s], got: %v", aTest.wantError, err)
+        }
+        return
+      }
+      if err != nil { t.Fatalf("unexpected error: %s", err) }
+      if aDoc != aTest.want { t.Errorf("got %+v, want %+v", aDoc, aTest.want) }
+    })
+  }
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+  srcDB := openTestSearchDB(t)
+  for _, aDoc := range []indexedDocument{
+    { path : "files/a.html", title : "A", mtime : 1000, size : 12,
+      body : "about \"a\" <b>", source : fileSource },
+    { path : "push:b", url : "https://example.com/b", title : "B", kind : "memo",
+      mtime : 2000, body : "about b", source : pushSource },
+    { path : "https://example.com/c", url : "https://example.com/c", title : "C",
+      kind : " ", mtime : 3000, body : "about c", source : crawlSource },
+  } {
+    if err := upsertDocument(srcDB, aDoc); err != nil {
+      t.Fatalf("could not insert [%s]: %s", aDoc.path, err)
+    }
+  }
+
+  var anExport bytes.Buffer
+  numExported, err := exportDocuments(srcDB, &anExport)
+  if err != nil || numExported != 3 {
+    t.Fatalf("exported %d documents (%v), want 3", numExported, err)
+  }
+
+  destDB := openTestSearchDB(t)
+  numImported, someErrors, err := importDocuments(destDB, bytes.NewReader(anExport.Bytes()))
+  if err != nil || numImported != 3 || 0 < len(someErrors) {
+    t.Fatalf("imported %d documents (%v %v), want 3", numImported, err, someErrors)
+  }
+
+  var reExport bytes.Buffer
+  if _, err := exportDocuments(destDB, &reExport); err != nil {
+    t.Fatalf("could not re-export: %s", err)
+  }
+  if reExport.String() != anExport.String() {
+    t.Errorf("the round trip changed the export:\n%s\nwant:\n%s", reExport.String(), anExport.String())
+  }
+  if got := searchDocPaths(t, destDB, "about"); len(got) != 3 {
+    t.Errorf("the imported documents are not searchable: %q", got)
+  }
+}
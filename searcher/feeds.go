@@ -0,0 +1,372 @@
+package main
+
+/*
+
+  We can render search results as an Atom or RSS feed (ordered by the
+  documents' modification times, newest first), so that searchers can
+  subscribe to "new pages matching X":
+
+    GET /search/<query>?format=atom   (or ?format=rss)
+
+  Named queries can also be saved (using the admin API) and polled as
+  feeds:
+
+    GET  /feeds/<name>[?format=rss]           : the saved search's feed
+    GET  /admin/searches                      : list the saved searches
+    POST /admin/searches/save?name=<n>&q=<q>  : save (or replace) a search
+    POST /admin/searches/delete?name=<n>      : delete a saved search
+
+  A saved search's feed contains (at most `Webserver.MaxNumResults` of) its
+  most recently indexed documents, newest first. Feed readers remember
+  the items (by their id/guid, the document's url) they have already
+  seen, so every new (or changed) document is seen, however often (or
+  rarely) the feed is polled.
+
+  Feeds are subject to the same access control (`Auth.ACL`) as the search
+  results.
+
+*/
+
+import (
+  "fmt"
+  "time"
+  "strings"
+  "net/url"
+  "net/http"
+  "database/sql"
+  "encoding/xml"
+)
+
+const (
+  atomFormat = "atom"
+  rssFormat  = "rss"
+)
+
+type feedItem struct {
+  docPath string
+  title   string
+  link    string
+  summary string
+  mtime   time.Time
+}
+
+func isFeedFormat(aFormat string) bool {
+  return aFormat == atomFormat || aFormat == rssFormat
+}
+
+// Make a (document) url absolute, so that it can be used outside of the
+// searcher.
+//
+func absoluteUrl(baseUrl string, aUrl string) string {
+  if strings.HasPrefix(aUrl, "/") { return baseUrl + aUrl }
+  return aUrl
+}
+
+// Find (at most maxNum of) the documents matching a query, newest first
+// (by their modification times, or if byIndexedAt, by when they were last
+// indexed).
+//
+func findFeedItems(
+  searchDB *sql.DB, r *http.Request, aQuery string, maxNum int, byIndexedAt bool,
+) ([]feedItem, error) {
+  aclSql, aclArgs := aclCondition(
+    "documents.docPath", allowedPathPrefixes(getSearchUser(r)),
+  )
+  orderSql := "docMTime desc"
+  if byIndexedAt { orderSql = "coalesce(documents.indexedAt, 0) desc, docMTime desc" }
+  someArgs := append([]interface{}{ aQuery }, aclArgs...)
+  someArgs  = append(someArgs, maxNum)
+  rows, err := searchDB.Query(`
+    select documents.docPath, documents.title,
+        snippet(documentSearch, 1, '', '', '...', 32),
+        coalesce(documents.mtime, 0) as docMTime,
+        documents.url
+      from documentSearch
+      join documents on documents.docId = documentSearch.rowid
+      where documentSearch match ?
+        and `+aclSql+`
+      order by `+orderSql+`
+      limit ?
+  `, someArgs...)
+  if err != nil { return nil, err }
+  defer rows.Close()
+
+  theConfig := getConfigSnapshot()
+  baseUrl   := openSearchBaseUrl(r)
+  someItems := []feedItem{}
+  for rows.Next() {
+    var anItem   feedItem
+    var docMTime int64
+    var docUrl   sql.NullString
+    err = rows.Scan(
+      &anItem.docPath, &anItem.title, &anItem.summary, &docMTime, &docUrl,
+    )
+    if err != nil { return nil, err }
+    anItem.mtime = time.Unix(docMTime, 0).UTC()
+    if 0 < len(docUrl.String) {
+      anItem.link = docUrl.String
+    } else {
+      anItem.link = fileUrl(anItem.docPath, theConfig.HtmlDirs, theConfig.UrlBase)
+    }
+    anItem.link = absoluteUrl(baseUrl, anItem.link)
+    someItems = append(someItems, anItem)
+  }
+  return someItems, rows.Err()
+}
+
+/////////////////////////////
+// Atom
+
+type atomLink struct {
+  Href string `xml:"href,attr"`
+  Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+  Id      string   `xml:"id"`
+  Title   string   `xml:"title"`
+  Updated string   `xml:"updated"`
+  Link    atomLink `xml:"link"`
+  Summary string   `xml:"summary"`
+}
+
+type atomFeed struct {
+  XMLName xml.Name    `xml:"feed"`
+  Xmlns   string      `xml:"xmlns,attr"`
+  Id      string      `xml:"id"`
+  Title   string      `xml:"title"`
+  Updated string      `xml:"updated"`
+  Links   []atomLink  `xml:"link"`
+  Author  string      `xml:"author>name"`
+  Entries []atomEntry `xml:"entry"`
+}
+
+func newAtomFeed(
+  aTitle string, selfUrl string, siteUrl string, someItems []feedItem,
+) atomFeed {
+  updated := time.Unix(0, 0).UTC()
+  theFeed := atomFeed{
+    Xmlns   : "http://www.w3.org/2005/Atom",
+    Id      : selfUrl,
+    Title   : aTitle,
+    Links   : []atomLink{
+      { Href: selfUrl, Rel: "self" },
+      { Href: siteUrl },
+    },
+    Author  : getSiteName(),
+    Entries : []atomEntry{},
+  }
+  for _, anItem := range someItems {
+    if updated.Before(anItem.mtime) { updated = anItem.mtime }
+    theFeed.Entries = append(theFeed.Entries, atomEntry{
+      Id      : anItem.link,
+      Title   : anItem.title,
+      Updated : anItem.mtime.Format(time.RFC3339),
+      Link    : atomLink{ Href: anItem.link },
+      Summary : anItem.summary,
+    })
+  }
+  theFeed.Updated = updated.Format(time.RFC3339)
+  return theFeed
+}
+
+/////////////////////////////
+// RSS
+
+type rssItem struct {
+  Title       string `xml:"title"`
+  Link        string `xml:"link"`
+  Guid        string `xml:"guid"`
+  PubDate     string `xml:"pubDate"`
+  Description string `xml:"description"`
+}
+
+type rssFeed struct {
+  XMLName     xml.Name  `xml:"rss"`
+  Version     string    `xml:"version,attr"`
+  Title       string    `xml:"channel>title"`
+  Link        string    `xml:"channel>link"`
+  Description string    `xml:"channel>description"`
+  Items       []rssItem `xml:"channel>item"`
+}
+
+func newRssFeed(aTitle string, siteUrl string, someItems []feedItem) rssFeed {
+  theFeed := rssFeed{
+    Version     : "2.0",
+    Title       : aTitle,
+    Link        : siteUrl,
+    Description : aTitle,
+    Items       : []rssItem{},
+  }
+  for _, anItem := range someItems {
+    theFeed.Items = append(theFeed.Items, rssItem{
+      Title       : anItem.title,
+      Link        : anItem.link,
+      Guid        : anItem.link,
+      PubDate     : anItem.mtime.Format(time.RFC1123Z),
+      Description : anItem.summary,
+    })
+  }
+  return theFeed
+}
+
+/////////////////////////////
+// Handlers
+
+func writeFeed(
+  w http.ResponseWriter, r *http.Request, aFormat string, aTitle string,
+  someItems []feedItem,
+) {
+  baseUrl := openSearchBaseUrl(r)
+  var theFeed interface{}
+  if aFormat == rssFormat {
+    w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+    theFeed = newRssFeed(aTitle, baseUrl+"/", someItems)
+  } else {
+    w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+    theFeed = newAtomFeed(aTitle, baseUrl+r.URL.RequestURI(), baseUrl+"/", someItems)
+  }
+  fmt.Fprint(w, xml.Header)
+  encoder := xml.NewEncoder(w)
+  encoder.Indent("", "  ")
+  WebserverMaybeError("could not encode the feed", encoder.Encode(theFeed))
+}
+
+// Render a query's results as a feed.
+//
+func serveSearchFeed(
+  w http.ResponseWriter, r *http.Request, searchDB *sql.DB,
+  aFormat string, aQuery string, maxNum int,
+) {
+  someItems, err := findFeedItems(searchDB, r, aQuery, maxNum, false)
+  if isDatabaseBusy(err) {
+    writeDatabaseBusy(w)
+    return
+  }
+  if err != nil {
+    WebserverMaybeError("could not search for the feed", err)
+    http.Error(w, "could not search for the feed", http.StatusBadRequest)
+    return
+  }
+  writeFeed(w, r, aFormat, getSiteName()+": "+aQuery, someItems)
+}
+
+func savedSearchFeedHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    aName := strings.TrimPrefix(r.URL.Path, "/feeds/")
+    var aQuery string
+    err := searchDB.QueryRow(
+      "select query from savedSearches where name = ?", aName,
+    ).Scan(&aQuery)
+    if err != nil {
+      http.NotFound(w, r)
+      return
+    }
+    aFormat := r.URL.Query().Get("format")
+    if !isFeedFormat(aFormat) { aFormat = atomFormat }
+
+    someItems, err := findFeedItems(
+      searchDB, r, aQuery, int(getConfigInt("Webserver.MaxNumResults", 100)), true,
+    )
+    if err != nil {
+      WebserverMaybeError("could not search for the saved search "+aName, err)
+      http.Error(w, "could not search for the feed", http.StatusInternalServerError)
+      return
+    }
+    writeFeed(w, r, aFormat, getSiteName()+": "+aName, someItems)
+  }
+}
+
+type savedSearch struct {
+  Name      string    `json:"name"`
+  Query     string    `json:"query"`
+  CreatedAt time.Time `json:"createdAt"`
+  Feed      string    `json:"feed"`
+}
+
+func adminSavedSearchesHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    rows, err := searchDB.Query(
+      "select name, query, createdAt from savedSearches order by name",
+    )
+    if err != nil {
+      WebserverMaybeError("could not list the saved searches", err)
+      writeJsonMessage(w, http.StatusInternalServerError, "could not list the saved searches")
+      return
+    }
+    defer rows.Close()
+    someSearches := []savedSearch{}
+    for rows.Next() {
+      var aSearch   savedSearch
+      var createdAt int64
+      if err = rows.Scan(&aSearch.Name, &aSearch.Query, &createdAt); err != nil {
+        break
+      }
+      aSearch.CreatedAt = time.Unix(createdAt, 0).UTC()
+      aSearch.Feed      = "/feeds/" + url.PathEscape(aSearch.Name)
+      someSearches = append(someSearches, aSearch)
+    }
+    WebserverMaybeError("could not list the saved searches", rows.Err())
+    writeJson(w, http.StatusOK, someSearches)
+  }
+}
+
+func adminSaveSearchHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    aName  := strings.TrimSpace(r.URL.Query().Get("name"))
+    aQuery := strings.TrimSpace(r.URL.Query().Get("q"))
+    if aName == "" || aQuery == "" || strings.Contains(aName, "/") {
+      writeJsonMessage(w, http.StatusBadRequest,
+        "a (non empty) name (without a /) and query (q) are required")
+      return
+    }
+    //
+    // check that the query is a valid (FTS5) query before saving it
+    //
+    var aCount int
+    err := searchDB.QueryRow(
+      "select count(*) from documentSearch where documentSearch match ?", aQuery,
+    ).Scan(&aCount)
+    if err != nil {
+      writeJsonMessage(w, http.StatusBadRequest, "invalid query: "+err.Error())
+      return
+    }
+    _, err = searchDB.Exec(`
+      insert or replace into savedSearches ( name, query, createdAt )
+        values ( ?, ?, ? )
+    `, aName, aQuery, time.Now().Unix())
+    if err != nil {
+      WebserverMaybeError("could not save the search "+aName, err)
+      writeJsonMessage(w, http.StatusInternalServerError, "could not save the search")
+      return
+    }
+    writeJsonMessage(w, http.StatusOK, "saved ["+aName+"]")
+  }
+}
+
+func adminDeleteSearchHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    aName := r.URL.Query().Get("name")
+    aResult, err := searchDB.Exec("delete from savedSearches where name = ?", aName)
+    if err != nil {
+      WebserverMaybeError("could not delete the search "+aName, err)
+      writeJsonMessage(w, http.StatusInternalServerError, "could not delete the search")
+      return
+    }
+    if numDeleted, _ := aResult.RowsAffected(); numDeleted < 1 {
+      writeJsonMessage(w, http.StatusNotFound, "no saved search ["+aName+"]")
+      return
+    }
+    writeJsonMessage(w, http.StatusOK, "deleted ["+aName+"]")
+  }
+}
+
+func registerFeedHandlers(mux *http.ServeMux, searchDB *sql.DB, writerDB *sql.DB) {
+  mux.HandleFunc("/feeds/", authHandler(streamingHandler(savedSearchFeedHandler(searchDB))))
+  mux.HandleFunc("/admin/searches",
+    adminHandler(http.MethodGet, adminSavedSearchesHandler(searchDB)))
+  mux.HandleFunc("/admin/searches/save",
+    adminHandler(http.MethodPost, adminSaveSearchHandler(writerDB)))
+  mux.HandleFunc("/admin/searches/delete",
+    adminHandler(http.MethodPost, adminDeleteSearchHandler(writerDB)))
+}
@@ -7,6 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/tidwall/gjson v1.12.0
 	github.com/tidwall/sjson v1.2.3
+	golang.org/x/crypto v0.14.0
 )
 
 require (
@@ -0,0 +1,70 @@
+package main
+
+/*
+
+  We provide two endpoints for container orchestrators:
+
+    /healthz : the searcher process is alive and answering requests.
+
+    /readyz  : the searcher is ready to answer searches: the database can
+               be opened, it has the tables we require, and the indexer has
+               completed its first pass.
+
+*/
+
+import (
+  "fmt"
+  "net/http"
+  "database/sql"
+  "sync/atomic"
+)
+
+var firstIndexPassDone int32 = 0
+
+func setFirstIndexPassComplete() {
+  atomic.StoreInt32(&firstIndexPassDone, 1)
+}
+
+func isFirstIndexPassComplete() bool {
+  return atomic.LoadInt32(&firstIndexPassDone) != 0
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  fmt.Fprintln(w, "ok")
+}
+
+func checkReadiness(searchDB *sql.DB) error {
+  if err := searchDB.Ping(); err != nil {
+    return fmt.Errorf("database not openable: %s", err)
+  }
+
+  var numTables int
+  err := searchDB.QueryRow(`
+    select count(*) from sqlite_master
+      where name in ( 'documents', 'documentSearch' ) ;
+  `).Scan(&numTables)
+  if err != nil {
+    return fmt.Errorf("could not read database schema: %s", err)
+  }
+  if numTables != 2 {
+    return fmt.Errorf("database schema not present")
+  }
+
+  if !isFirstIndexPassComplete() {
+    return fmt.Errorf("first index pass not yet complete")
+  }
+  return nil
+}
+
+func readyzHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    if err := checkReadiness(searchDB); err != nil {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      fmt.Fprintf(w, "not ready: %s\n", err)
+      return
+    }
+    fmt.Fprintln(w, "ready")
+  }
+}
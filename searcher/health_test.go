@@ -0,0 +1,51 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "net/http"
+  "database/sql"
+  "sync/atomic"
+  "net/http/httptest"
+)
+
+func TestHealthz(t *testing.T) {
+  aRecorder := httptest.NewRecorder()
+  healthzHandler(aRecorder, httptest.NewRequest("GET", "/healthz", nil))
+  if aRecorder.Code != http.StatusOK || aRecorder.Body.String() != "ok\n" {
+    t.Errorf("got %d %q", aRecorder.Code, aRecorder.Body.String())
+  }
+}
+
+func TestReadyz(t *testing.T) {
+  wasDone := atomic.LoadInt32(&firstIndexPassDone)
+  t.Cleanup(func() { atomic.StoreInt32(&firstIndexPassDone, wasDone) })
+  emptyDB  := openVersion0Database(t)
+  searchDB := openTestSearchDB(t)
+
+  someTests := []struct {
+    name       string
+    searchDB   *sql.DB
+    passDone   int32
+    wantStatus int
+    wantBody   string
+  }{
+    { "no schema",       emptyDB,  1, http.StatusServiceUnavailable,
+      "database schema not present" },
+    { "first pass",      searchDB, 0, http.StatusServiceUnavailable,
+      "first index pass not yet complete" },
+    { "ready",           searchDB, 1, http.StatusOK, "ready" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      atomic.StoreInt32(&firstIndexPassDone, aTest.passDone)
+      aRecorder := httptest.NewRecorder()
+      readyzHandler(aTest.searchDB)(aRecorder, httptest.NewRequest("GET", "/readyz", nil))
+      if aRecorder.Code != aTest.wantStatus ||
+        !strings.Contains(aRecorder.Body.String(), aTest.wantBody) {
+        t.Errorf("got %d %q, want %d %q", aRecorder.Code, aRecorder.Body.String(),
+          aTest.wantStatus, aTest.wantBody)
+      }
+    })
+  }
+}
@@ -14,6 +14,7 @@ import (
   "database/sql"
   _ "github.com/mattn/go-sqlite3"
   "github.com/grokify/html-strip-tags-go"
+  "github.com/tidwall/gjson"
 )
 
 func IndexerMaybeFatal(logMessage string, err error) {
@@ -24,6 +25,8 @@ func IndexerMaybeFatal(logMessage string, err error) {
 
 func IndexerMaybeError(logMessage string, err error) {
   if err != nil {
+    indexerErrors.inc()
+    recordIndexerError(logMessage+": "+err.Error())
     log.Printf("Indexer(error): %s error: %s",logMessage, err)
   }
 }
@@ -53,35 +56,40 @@ func initDatabaseStructure() {
     }
     //
     // We have been able to create the database so...
-    // ... create the tables we need...
+    // ... create the (current) structure we need...
     //
-    searchDB, err := sql.Open("sqlite3", getConfigStr("DatabasePath", ""))
+    searchDB, err := openSearchDB(false)
     IndexerMaybeFatal("could not open database file to initialize tables", err)
     defer searchDB.Close()
+    IndexerMaybeFatal("could not create tables", createDatabaseStructure(searchDB))
+  }
+  //
+  // Now bring the structure of an existing database up to date
+  //
+  searchDB, err := openSearchDB(false)
+  IndexerMaybeFatal("could not open database file to migrate tables", err)
+  defer searchDB.Close()
+  IndexerMaybeFatal("could not migrate database", migrateDatabase(searchDB))
+}
 
-    _, err = searchDB.Exec(`
-      create table fileInfo (
-        filePath  text not null primary key,
-        fileMTime int,
-        fileSize  int
-      );
-    `)
-    IndexerMaybeFatal("could not create fileInfo table", err)
+var removeSpaces *regexp.Regexp = regexp.MustCompile(`\s+`)
 
-    _, err = searchDB.Exec(`
-      create index filePaths ON fileInfo(filePath);
-    `)
-    IndexerMaybeFatal("could not create filePaths index", err)
+// Strip any html tags and collapse any runs of white space
+//
+func stripHtml(aStr string) string {
+  return removeSpaces.ReplaceAllString(strip.StripTags(aStr), " ")
+}
 
-    _, err = searchDB.Exec(`
-      create virtual table pageSearch using fts5(
-        filePath,
-        fileTitle,
-        fileStr
-      );
-    `)
-    IndexerMaybeFatal("could not create pageSearch table", err)
+// Remove a single file from the index.
+//
+func removeFileFromIndex(searchDB *sql.DB, aFile string) error {
+  _, err := deleteDocument(searchDB, aFile)
+  if err != nil {
+    IndexerMaybeError("could not remove "+aFile, err)
+    return err
   }
+  indexerFilesRemoved.inc()
+  return nil
 }
 
 func removeMissingFiles(searchDB *sql.DB) {
@@ -91,15 +99,18 @@ func removeMissingFiles(searchDB *sql.DB) {
 
   IndexerLog("removing missing files")
   //
-  // look for files which are in fileInfo database...
+  // look for files which are in the documents table...
   // ... but no longer exist... (store them for later deletion)
   //
-  rows, err := searchDB.Query("select filePath from fileInfo")
-  IndexerMaybeError("selecting filePaths from fileInfo", err)
+  rows, err := searchDB.Query(
+    "select docPath from documents where source = ?", fileSource,
+  )
+  IndexerMaybeError("selecting file paths from documents", err)
   defer rows.Close()
   //
   for {
     if maxDeletions <= numDeletions { break }
+    if isShuttingDown() { break }
     //
     hasRow := rows.Next()
     if !hasRow {
@@ -120,212 +131,300 @@ func removeMissingFiles(searchDB *sql.DB) {
   // Now actually delete the files from the database
   //
   for i := int64(0); i < numDeletions; i++ {
-    aFile := filesToDelete[i]
-    IndexerLogf("deleting(%d): [%s]", i, aFile)
-    transaction, err := searchDB.Begin()
-    if err != nil {
-      IndexerMaybeError("could not start deletion transaction", err)
-      break
-    }
-    _, err = transaction.Exec("delete from fileInfo where filePath = ?", aFile)
-    if err != nil {
-      IndexerMaybeError("deleting from fileInfo", err)
-      transaction.Rollback()
-      break
-    }
-    _, err = transaction.Exec("delete from pageSearch where filePath = ?", aFile)
-    if err != nil {
-      IndexerMaybeError("deleting from pageSearch", err)
-      transaction.Rollback()
-      break
-    }
-    err = transaction.Commit()
-    if err != nil {
-      IndexerMaybeError("could not commit deletion transaction", err)
+    if isShuttingDown() {
+      IndexerLogf("shutting down: only deleted %d of %d files", i, numDeletions)
+      numDeletions = i
       break
     }
+    aFile := filesToDelete[i]
+    IndexerLogf("deleting(%d): [%s]", i, aFile)
+    if err := removeFileFromIndex(searchDB, aFile); err != nil { break }
   }
 
   // Now shrink the database by vacuuming it...
   //
-  if 0 < numDeletions {
-    IndexerLog("vacuuming database....")
-    searchDB.Exec("vacuum;")
-    IndexerLog("finished vacuuming database.")
-  }
+  if !isShuttingDown() { maybeVacuumSearchDB(searchDB, numDeletions) }
   IndexerLogf("removed %d missing files", numDeletions)
 }
 
+// Should the file at this path be indexed at all?
+//
+func isIndexableFile(path string) bool {
+  if !strings.HasSuffix(path, ".html") { return false }
+  if strings.HasSuffix(path, "index.html") { return false }
+  if strings.HasSuffix(path, "Citations.html") { return false }
+  return true
+}
+
+func getTitleRegexp() *regexp.Regexp {
+  titlePattern  := getConfigStr("TitlePattern", "<title>(.*?)</title>")
+  IndexerLogf("TitlePattern: [%s]", titlePattern)
+  return regexp.MustCompile(titlePattern)
+}
+
+// Extract the title and the text of an html page (or file). The
+// defaultTitle is used if the page has no title.
+//
+func extractHtml(
+  pageStr string, titleRegexp *regexp.Regexp, defaultTitle string,
+) (string, string) {
+  pageStr           = strings.Replace(pageStr, "\n", " ", -1)
+  pageStr           = strings.Replace(pageStr, "\r", " ", -1)
+  pageTitleMatches := titleRegexp.FindStringSubmatch(pageStr)
+  // The following is a dirty hack to protect us from missing titles ;-(
+  pageTitle        := defaultTitle
+  IndexerLogf("titleMatches [%s]", pageTitleMatches)
+  if 0 < len(pageTitleMatches) {
+    pageTitle = string(pageTitleMatches[1])
+  }
+  //IndexerLogf("title [%s]", pageTitle)
+  //
+  return pageTitle, stripHtml(pageStr)
+}
+
+// Index (insert or update) a single file, unless it has not changed since
+// it was last indexed (and we are not forced to reindex it). Returns true
+// if the file has been (re)indexed.
+//
+// Pages listed in a sitemap have a pageUrl (and possibly a lastMod, which
+// is then used, rather than the file's modification time and size, to
+// decide if it has changed). Other files have an empty pageUrl (and a
+// zero lastMod).
+//
+func indexFile(
+  searchDB *sql.DB, path string, pageUrl string, lastMod time.Time,
+  titleRegexp *regexp.Regexp, forceIndex bool,
+) bool {
+  pageMTime, pageSize, pageHash, isIndexed, err := findDocumentInfo(searchDB, path)
+  IndexerMaybeError("looking for "+path+" in documents", err)
+  if err != nil { return false }
+  //
+  fileInfo, err := os.Stat(path)
+  if err != nil {
+    IndexerMaybeError("could not get file info for "+path, err)
+    indexerFilesFailed.inc()
+    return false
+  }
+  fileMTime   := fileInfo.ModTime().Unix()
+  isUnchanged := fileMTime == pageMTime && fileInfo.Size() == pageSize
+  if !lastMod.IsZero() {
+    fileMTime   = lastMod.Unix()
+    isUnchanged = fileMTime == pageMTime
+  }
+  if !forceIndex && isUnchanged {
+    return false
+  }
+
+  IndexerLogf("need to index [%s]", path)
+  //
+  // start by getting the values for the file itself
+  //
+  fileBytes, err   := ioutil.ReadFile(path)
+  if err != nil {
+    IndexerMaybeError("could not read "+path, err)
+    indexerFilesFailed.inc()
+    return false
+  }
+  fileTitle, fileStr := extractHtml(string(fileBytes), titleRegexp, path)
+  //
+  // now check if there is an associated *Citations.html file....
+  //   (this is a hack for the current Jekyll bases references system)
+  //
+  citationsPath := strings.Replace(path, ".html", "Citations.html", 1)
+  citationsFileBytes, err := ioutil.ReadFile(citationsPath)
+  if err == nil {
+    citationsFileStr := stripHtml(string(citationsFileBytes))
+    fileStr = fileStr + " " + citationsFileStr
+  }
+
+  if isIndexed && !forceIndex && pageHash == documentHash(fileTitle, fileStr) {
+    //
+    // only the file's modification time (or size) has changed... so there
+    // is no need to reindex its text
+    //
+    IndexerLogf("UNCHANGED: [%s]", path)
+    err = touchDocument(searchDB, path, pageUrl, fileMTime, fileInfo.Size())
+    IndexerMaybeError("trying to update the file info of "+path, err)
+    err = recordDocumentLinks(searchDB, path, pageUrl, string(fileBytes))
+    IndexerMaybeError("trying to record the links in "+path, err)
+    return false
+  }
+
+  if isIndexed {
+    IndexerLogf("UPDATING: [%s][%s]", path, fileTitle)
+  } else {
+    IndexerLogf("INSERTING: [%s][%s]", path, fileTitle)
+  }
+  err = upsertDocument(searchDB, indexedDocument{
+    path   : path,
+    url    : pageUrl,
+    title  : fileTitle,
+    mtime  : fileMTime,
+    size   : fileInfo.Size(),
+    body   : fileStr,
+    source : fileSource,
+  })
+  if err != nil {
+    IndexerMaybeError("trying to index "+path, err)
+    indexerFilesFailed.inc()
+    return false
+  }
+  err = recordDocumentLinks(searchDB, path, pageUrl, string(fileBytes))
+  IndexerMaybeError("trying to record the links in "+path, err)
+  if isIndexed {
+    indexerFilesUpdated.inc()
+  } else {
+    indexerFilesAdded.inc()
+  }
+  return true
+}
+
 func lookForNewFiles(searchDB *sql.DB) {
   maxInsertions := getConfigInt("Indexer.AddUpdateBatch", 200)
   numInsertions := int64(0)
-  titlePattern  := getConfigStr("TitlePattern", "<title>(.*?)</title>")
-  IndexerLogf("TitlePattern: [%s]", titlePattern)
-  titleRegexp   := regexp.MustCompile(titlePattern)
+  titleRegexp   := getTitleRegexp()
 
   IndexerLog("looking for new or chagned files")
   //
   // walk the html files looking for new or changed files...
   //
   htmlDirs := getConfigAStr("HtmlDirs", []string{ "files" })
+  //
+  // ... the files in a sitemap root's directory are ONLY indexed if they
+  // are listed in its sitemap (even if that directory is also inside one
+  // of the (other) HtmlDirs)
+  //
+  siteRoots := []string{}
   for _, anHtmlDir := range htmlDirs {
+    if isSitemapHtmlDir(anHtmlDir) { siteRoots = append(siteRoots, htmlDirRoot(anHtmlDir)) }
+  }
+  //
+  // ... and the paths removed by the admin API are never indexed
+  //
+  excludedPaths := findExcludedPaths(searchDB)
+  for _, anHtmlDir := range htmlDirs {
+    if isSitemapHtmlDir(anHtmlDir) {
+      numInsertions = numInsertions + indexSitemap(
+        searchDB, anHtmlDir, excludedPaths, titleRegexp, maxInsertions - numInsertions,
+      )
+      continue
+    }
     filepath.Walk(anHtmlDir,func (path string, info os.FileInfo, err error) error {
       if maxInsertions <= numInsertions {
         return nil
       }
+      if isShuttingDown() {
+        return nil
+      }
       if err != nil {
         IndexerMaybeError("walking path "+path, err)
         return nil
       }
       if info.IsDir() {
 //        IndexerLogf("walking into directory %s", path)
+        if isInDirectory(path, siteRoots) { return filepath.SkipDir }
+        if isInDirectory(path, excludedPaths) { return filepath.SkipDir }
         return nil
       }
-      if !strings.HasSuffix(path, ".html") { return nil }
-      if strings.HasSuffix(path, "index.html") { return nil }
-      if strings.HasSuffix(path, "Citations.html") { return nil }
-      var filePath  string = ""
-      var pageMTime int64  = 0
-      var pageSize  int64  = 0
-      rows, err := searchDB.Query(`
-        select * from fileInfo where filePath == ? ;
-      `, path)
-      IndexerMaybeError("looking for new files in fileInfo", err)
-      hasRows := rows.Next()
-      if hasRows {
-        rows.Scan(&filePath, &pageMTime, &pageSize)
-      } else {
-        err := rows.Err()
-        IndexerMaybeError("looking for first result from files in fileInfo", err)
-      }
-      rows.Close()
-      //
-      fileInfo, _ := os.Stat(path)
-      if fileInfo.ModTime().Unix() == pageMTime && fileInfo.Size() == pageSize {
+      if isInDirectory(path, excludedPaths) { return nil }
+      if !isIndexableFile(path) {
+        indexerFilesSkipped.inc()
         return nil
       }
-
-      IndexerLogf("need to index(%d) [%s]", numInsertions+1, path)
-      //
-      // start by getting the values for the file itself
-      //
-      fileBytes, _     := ioutil.ReadFile(path)
-      fileStr          := string(fileBytes)
-      fileStr           = strings.Replace(fileStr, "\n", " ", -1)
-      fileStr           = strings.Replace(fileStr, "\r", " ", -1)
-      fileTitleMatches := titleRegexp.FindStringSubmatch(fileStr)
-      // The following is a dirty hack to protect us from missing titles ;-(
-      fileTitle        := path
-      IndexerLogf("titleMatches [%s]", fileTitleMatches)
-      if 0 < len(fileTitleMatches) {
-        fileTitle = string(fileTitleMatches[1])
-      }
-      //IndexerLogf("title [%s]", fileTitle)
-      //
-      fileStr = strip.StripTags(fileStr)
-      removeSpaces, _ := regexp.Compile(`\s+`)
-      fileStr = removeSpaces.ReplaceAllString(fileStr, " ")
-      //
-      // now check if there is an associated *Citations.html file....
-      //   (this is a hack for the current Jekyll bases references system)
-      //
-      citationsPath := strings.Replace(path, ".html", "Citations.html", 1)
-      citationsFileBytes, err := ioutil.ReadFile(citationsPath)
-      if err == nil {
-        citationsFileStr := string(citationsFileBytes)
-        citationsFileStr  = strip.StripTags(citationsFileStr)
-        citationsFileStr  = removeSpaces.ReplaceAllString(citationsFileStr, " ")
-        fileStr = fileStr + " " + citationsFileStr
-      }
-
-      if filePath != path {
-        //
-        // this file has not yet been indexed... so insert it...
-        //
-        IndexerLogf("INSERTING: [%s][%s]", path, fileTitle)
-        transaction, err := searchDB.Begin()
-        if err != nil {
-          IndexerMaybeError("could not start insertions transaction", err)
-          return nil
-        }
-        _, err = transaction.Exec(`
-          insert into fileInfo ( filePath, fileMTime, fileSize ) values ( ?, ?, ?)
-        `, path, fileInfo.ModTime().Unix(), fileInfo.Size())
-        if err != nil {
-          IndexerMaybeError("trying to insert new file into fileInfo", err)
-          transaction.Rollback()
-          return nil
-        }
-        _, err = transaction.Exec(`
-          insert into pageSearch ( filePath, fileTitle, fileStr ) values ( ?, ?, ?)
-        `, path, fileTitle, fileStr)
-        if err != nil {
-          IndexerMaybeError("trying to insert new file into pageSearch", err)
-          transaction.Rollback()
-          return nil
-        }
-        err = transaction.Commit()
-        if err != nil {
-          IndexerMaybeError("could not commit insertions transaction", err)
-          return nil
-        }
+      if indexFile(searchDB, path, "", time.Time{}, titleRegexp, false) {
         numInsertions = numInsertions + 1
-        return nil
       }
-
-      //
-      // this file has already been indexed... so update it...
-      //
-      IndexerLogf("UPDATING: [%s][%s]", path, fileTitle)
-      transaction, err := searchDB.Begin()
-      if err != nil {
-        IndexerMaybeError("could not start update transaction", err)
-        return nil
-      }
-      _, err = transaction.Exec(`
-        update fileInfo set fileMTime = ?, fileSize = ? where filePath = ?
-      `, fileInfo.ModTime().Unix(), fileInfo.Size(), path)
-      if err != nil {
-        IndexerMaybeError("trying to update changed file into fileInfo", err)
-        transaction.Rollback()
-        return nil
-      }
-      _, err = transaction.Exec(`
-        update pageSearch set fileTitle = ?, fileStr = ? where filePath = ?
-      `, fileTitle, fileStr, path)
-      if err != nil {
-        IndexerMaybeError("trying to update changed file into pageSearch", err)
-        transaction.Rollback()
-        return nil
-      }
-      err = transaction.Commit()
-      if err != nil {
-        IndexerMaybeError("could not commit update transaction", err)
-        return nil
-      }
-      numInsertions = numInsertions + 1
       return nil
     })
   }
   IndexerLogf("Indexer: found %d new or changed files", numInsertions)
 }
 
+func runIndexPass(searchDB *sql.DB) {
+  IndexerLog("starting");
+  passStart := time.Now()
+  indexerPassStarted(passStart)
+  // (the counters at the start of the pass)
+  passCounters := []*metricsCounter{
+    indexerFilesAdded, indexerFilesUpdated, indexerFilesRemoved,
+    indexerFilesSkipped, indexerFilesFailed,
+  }
+  startCounts := []uint64{}
+  for _, aCounter := range passCounters {
+    startCounts = append(startCounts, aCounter.get())
+  }
+  removeMissingFiles(searchDB)
+  lookForNewFiles(searchDB)
+  crawlSites(searchDB)
+  if !isShuttingDown() { maybeUpdateLinkScores(searchDB) }
+  passEnd := time.Now()
+  indexerPassDuration.observe(passEnd.Sub(passStart).Seconds())
+  indexerPassFinished(passEnd)
+  passCounts := []interface{}{ passStart.Unix(), passEnd.Unix() }
+  for anIndex, aCounter := range passCounters {
+    passCounts = append(passCounts, aCounter.get() - startCounts[anIndex])
+  }
+  _, err := searchDB.Exec(`
+    insert into indexPasses
+      ( passStart, passEnd,
+        filesAdded, filesUpdated, filesRemoved, filesSkipped, filesFailed )
+      values ( ?, ?, ?, ?, ?, ?, ? )
+  `, passCounts...)
+  IndexerMaybeError("could not record the index pass", err)
+  _, err = searchDB.Exec(`
+    delete from indexPasses
+      where rowid <= ( select max(rowid) from indexPasses ) - 100
+  `)
+  IndexerMaybeError("could not remove old index passes", err)
+  setFirstIndexPassComplete()
+  IndexerLog("finished");
+}
+
 func indexFiles() {
   //
   // Begin by opening the database
   //
-  searchDB, err := sql.Open("sqlite3", getConfigStr("DatabasePath", ""))
+  searchDB, err := getSearchWriter()
   IndexerMaybeFatal("could not open database", err)
-  defer searchDB.Close()
   //
-  // Now periodically scan the file system for new pages
+  // Any new HtmlDirs should be indexed immediately
+  //
+  subscribeToConfig("indexer", func(oldConfig, newConfig *configSnapshot) {
+    if !oldConfig.hasSameHtmlDirs(newConfig) {
+      IndexerLogf("HtmlDirs changed to %v", newConfig.HtmlDirs)
+      requestIndexPass()
+    }
+    // (only the links in public documents count, see: links.go)
+    if gjson.Get(oldConfig.raw, "Auth.ACL").Raw != gjson.Get(newConfig.raw, "Auth.ACL").Raw {
+      markLinkGraphChanged()
+    }
+  })
+  //
+  // Now periodically scan the file system for new pages (until we are
+  // asked to shutdown). While waiting we handle any queued indexer tasks.
   //
   for {
-    IndexerLog("starting");
-    removeMissingFiles(searchDB)
-    lookForNewFiles(searchDB)
-    IndexerLog("finished");
+    runIndexPass(searchDB)
     sleepSeconds := getConfigInt("Indexer.SleepSeconds", 60)
-    time.Sleep(time.Duration(rand.Int63n(sleepSeconds)) * time.Second)
+    sleepTimer   := time.NewTimer(
+      time.Duration(rand.Int63n(sleepSeconds)) * time.Second,
+    )
+    waiting := true
+    for waiting {
+      select {
+        case <-shutdownRequested :
+          sleepTimer.Stop()
+          IndexerLog("shutting down")
+          return
+        case <-indexPassRequested :
+          sleepTimer.Stop()
+          IndexerLog("index pass requested")
+          waiting = false
+        case aTask := <-indexerTasks :
+          runIndexerTask(searchDB, aTask)
+        case <-sleepTimer.C :
+          waiting = false
+      }
+    }
   }
 }
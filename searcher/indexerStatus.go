@@ -0,0 +1,243 @@
+package main
+
+/*
+
+  We keep track of what the indexer is doing (so that it can be reported
+  by the admin API), as well as a queue of indexer tasks (requested by the
+  admin API) which the indexer runs between its indexing passes.
+
+  The status is updated by the indexer goroutine and read by the webServer
+  goroutines, so it is protected by a RWMutex.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "sync"
+  "time"
+  "path/filepath"
+  "database/sql"
+)
+
+const maxIndexerErrors = 20
+
+type indexerError struct {
+  Time    time.Time `json:"time"`
+  Message string    `json:"message"`
+}
+
+type indexerStatus struct {
+  PassRunning      bool           `json:"passRunning"`
+  NumPasses        int64          `json:"numPasses"`
+  LastPassStart    time.Time      `json:"lastPassStart"`
+  LastPassEnd      time.Time      `json:"lastPassEnd"`
+  LastPassDuration string         `json:"lastPassDuration"`
+  QueueLength      int            `json:"queueLength"`
+  LastErrors       []indexerError `json:"lastErrors"`
+}
+
+var currentIndexerStatus indexerStatus
+var updateIndexerStatus  sync.RWMutex
+
+func indexerPassStarted(passStart time.Time) {
+  updateIndexerStatus.Lock()
+  defer updateIndexerStatus.Unlock()
+
+  currentIndexerStatus.PassRunning   = true
+  currentIndexerStatus.LastPassStart = passStart
+}
+
+func indexerPassFinished(passEnd time.Time) {
+  updateIndexerStatus.Lock()
+  defer updateIndexerStatus.Unlock()
+
+  currentIndexerStatus.PassRunning      = false
+  currentIndexerStatus.NumPasses        = currentIndexerStatus.NumPasses + 1
+  currentIndexerStatus.LastPassEnd      = passEnd
+  currentIndexerStatus.LastPassDuration =
+    passEnd.Sub(currentIndexerStatus.LastPassStart).String()
+}
+
+func recordIndexerError(aMessage string) {
+  updateIndexerStatus.Lock()
+  defer updateIndexerStatus.Unlock()
+
+  lastErrors := append(
+    currentIndexerStatus.LastErrors,
+    indexerError{ Time: time.Now(), Message: aMessage },
+  )
+  if maxIndexerErrors < len(lastErrors) {
+    lastErrors = lastErrors[len(lastErrors)-maxIndexerErrors:]
+  }
+  currentIndexerStatus.LastErrors = lastErrors
+}
+
+func getIndexerStatus() indexerStatus {
+  updateIndexerStatus.RLock()
+  defer updateIndexerStatus.RUnlock()
+
+  theStatus := currentIndexerStatus
+  theStatus.LastErrors  = append([]indexerError{}, currentIndexerStatus.LastErrors...)
+  theStatus.QueueLength = len(indexerTasks)
+  return theStatus
+}
+
+/////////////////////////////
+// Indexer tasks
+//
+
+const (
+  reindexPathTask = "reindex"
+  removePathTask  = "remove"
+)
+
+type indexerTask struct {
+  kind string
+  path string
+}
+
+var indexerTasks chan indexerTask = make(chan indexerTask, 100)
+
+// Queue an indexer task, returning an error if the queue is full.
+//
+func queueIndexerTask(aKind string, aPath string) error {
+  select {
+    case indexerTasks <- indexerTask{ kind: aKind, path: aPath } :
+      return nil
+    default :
+      return fmt.Errorf("the indexer task queue is full")
+  }
+}
+
+// Is this path inside one of the HtmlDirs? (We never touch anything else)
+//
+func isInHtmlDirs(aPath string) bool {
+  htmlRoots := []string{}
+  for _, anHtmlDir := range getConfigAStr("HtmlDirs", []string{ "files" }) {
+    htmlRoots = append(htmlRoots, htmlDirRoot(anHtmlDir))
+  }
+  return isInDirectory(aPath, htmlRoots)
+}
+
+func runIndexerTask(searchDB *sql.DB, aTask indexerTask) {
+  IndexerLogf("running task: %s [%s]", aTask.kind, aTask.path)
+  switch aTask.kind {
+    case reindexPathTask : reindexPath(searchDB, aTask.path)
+    case removePathTask  : removePath(searchDB, aTask.path)
+    default :
+      IndexerMaybeError("running task", fmt.Errorf("unknown task %s", aTask.kind))
+  }
+}
+
+// Reindex a file, or all of the files in a directory, whether or not they
+// have changed. (In a sitemap root, only the pages listed in its sitemap
+// are reindexed.)
+//
+// Reindexing a path which has been removed (see: removePath) indexes it
+// again, unless it is inside a (larger) removed directory.
+//
+func reindexPath(searchDB *sql.DB, aPath string) {
+  err := includePath(searchDB, aPath)
+  IndexerMaybeError("could not stop excluding "+aPath, err)
+  excludedPaths := findExcludedPaths(searchDB)
+  titleRegexp   := getTitleRegexp()
+  sitemapPages, siteRoots := findSitemapPages()
+  numReindexed := 0
+  err = filepath.Walk(aPath, func(path string, info os.FileInfo, err error) error {
+    if isShuttingDown() { return nil }
+    if err != nil {
+      IndexerMaybeError("walking path "+path, err)
+      return nil
+    }
+    if isInDirectory(path, excludedPaths) {
+      if info.IsDir() { return filepath.SkipDir }
+      return nil
+    }
+    if info.IsDir() { return nil }
+    pageUrl, lastMod := "", time.Time{}
+    if anEntry, isListed := sitemapPages[path]; isListed {
+      pageUrl, lastMod = anEntry.url, anEntry.lastMod
+    } else if isInDirectory(path, siteRoots) || !isIndexableFile(path) {
+      return nil
+    }
+    if indexFile(searchDB, path, pageUrl, lastMod, titleRegexp, true) {
+      numReindexed = numReindexed + 1
+    }
+    return nil
+  })
+  IndexerMaybeError("reindexing "+aPath, err)
+  IndexerLogf("reindexed %d files in [%s]", numReindexed, aPath)
+}
+
+// Remove a file, or all of the files in a directory, from the index. The
+// path is then excluded from any later indexing passes (until it is
+// reindexed, see: reindexPath).
+//
+func removePath(searchDB *sql.DB, aPath string) {
+  cleanPath := filepath.Clean(aPath)
+  if err := excludePath(searchDB, cleanPath); err != nil {
+    IndexerMaybeError("could not exclude "+cleanPath, err)
+    return
+  }
+  dirPrefix := cleanPath+string(filepath.Separator)
+  rows, err := searchDB.Query(`
+    select docPath from documents
+      where source = ? and ( docPath = ? or substr(docPath, 1, ?) = ? ) ;
+  `, fileSource, cleanPath, len(dirPrefix), dirPrefix)
+  IndexerMaybeError("selecting filePaths to remove", err)
+  if err != nil { return }
+  filesToDelete := []string{}
+  for rows.Next() {
+    var aFile string
+    if err := rows.Scan(&aFile); err == nil {
+      filesToDelete = append(filesToDelete, aFile)
+    }
+  }
+  IndexerMaybeError("stepping through filePaths to remove", rows.Err())
+  rows.Close()
+
+  for _, aFile := range filesToDelete {
+    IndexerLogf("removing: [%s]", aFile)
+    if err := removeFileFromIndex(searchDB, aFile); err != nil { break }
+  }
+  IndexerLogf("removed %d files in [%s]", len(filesToDelete), aPath)
+}
+
+// The paths (files or directories) removed by the admin API, which are no
+// longer indexed.
+//
+func findExcludedPaths(searchDB *sql.DB) []string {
+  excludedPaths := []string{}
+  rows, err := searchDB.Query("select path from excludedPaths order by path")
+  IndexerMaybeError("selecting the excluded paths", err)
+  if err != nil { return excludedPaths }
+  defer rows.Close()
+  for rows.Next() {
+    var aPath string
+    if err := rows.Scan(&aPath); err == nil {
+      excludedPaths = append(excludedPaths, aPath)
+    }
+  }
+  IndexerMaybeError("stepping through the excluded paths", rows.Err())
+  return excludedPaths
+}
+
+func excludePath(searchDB *sql.DB, aPath string) error {
+  _, err := searchDB.Exec(`
+    insert or replace into excludedPaths ( path, excludedAt ) values ( ?, ? )
+  `, filepath.Clean(aPath), time.Now().Unix())
+  return err
+}
+
+// Stop excluding a path (and anything inside it).
+//
+func includePath(searchDB *sql.DB, aPath string) error {
+  cleanPath := filepath.Clean(aPath)
+  dirPrefix := cleanPath+string(filepath.Separator)
+  _, err := searchDB.Exec(`
+    delete from excludedPaths
+      where path = ? or substr(path, 1, ?) = ?
+  `, cleanPath, len(dirPrefix), dirPrefix)
+  return err
+}
@@ -0,0 +1,259 @@
+package main
+
+/*
+
+  We provide an ingestion API (all under /ingest/) which allows content
+  which does not exist as files under the HtmlDirs (issue trackers, wiki
+  exports, ...) to be pushed directly into the index:
+
+    POST   /ingest/documents      : upsert one (json) document
+    DELETE /ingest/documents/<id> : delete one document
+    POST   /ingest/bulk           : upsert and/or delete many documents
+                                    (one json object per line, NDJSON)
+
+  A document is a json object:
+
+    {
+      "id"        : "ISSUE-42",             (required)
+      "url"       : "https://.../ISSUE-42", (required)
+      "title"     : "...",
+      "type"      : "I",
+      "body"      : "... (text or html) ...",
+      "timestamp" : "2021-11-02T10:00:00Z"  (RFC3339 or unix seconds)
+    }
+
+  In a bulk upload, a line of the form `{ "delete": "<id>" }` deletes the
+  document with that id.
+
+  Pushed documents are stored in the documents table (with a docPath of
+  `push:<id>` and a source of "push") alongside the crawled files. Since
+  they are not files, they are never removed by removeMissingFiles.
+
+  Every request must be authenticated using one of the bearer tokens listed
+  in the `Ingest.Tokens` configuration. If no tokens are configured, the
+  ingestion API is disabled.
+
+*/
+
+import (
+  "io"
+  "fmt"
+  "time"
+  "bufio"
+  "strings"
+  "net/http"
+  "io/ioutil"
+  "database/sql"
+  "github.com/tidwall/gjson"
+)
+
+const pushedDocPrefix = "push:"
+
+type pushedDocument struct {
+  id    string
+  url   string
+  title string
+  kind  string
+  body  string
+  mtime int64
+}
+
+func pushedDocPath(anId string) string {
+  return pushedDocPrefix + anId
+}
+
+func isPushedDocPath(aPath string) bool {
+  return strings.HasPrefix(aPath, pushedDocPrefix)
+}
+
+// Extract a pushed document from its json description.
+//
+func parsePushedDocument(docJson gjson.Result) (pushedDocument, error) {
+  var aDoc pushedDocument
+  if !docJson.IsObject() {
+    return aDoc, fmt.Errorf("a document must be a json object")
+  }
+  aDoc.id    = docJson.Get("id").String()
+  aDoc.url   = docJson.Get("url").String()
+  aDoc.title = docJson.Get("title").String()
+  aDoc.kind  = docJson.Get("type").String()
+  aDoc.body  = stripHtml(docJson.Get("body").String())
+  if len(aDoc.id) < 1 {
+    return aDoc, fmt.Errorf("a document must have an id")
+  }
+  if len(aDoc.url) < 1 {
+    return aDoc, fmt.Errorf("document [%s] must have a url", aDoc.id)
+  }
+  if len(aDoc.title) < 1 { aDoc.title = aDoc.url }
+  if len(aDoc.kind)  < 1 { aDoc.kind  = " " }
+
+  aDoc.mtime = time.Now().Unix()
+  timestamp := docJson.Get("timestamp")
+  switch timestamp.Type {
+    case gjson.Number : aDoc.mtime = timestamp.Int()
+    case gjson.String :
+      aTime, err := time.Parse(time.RFC3339, timestamp.String())
+      if err != nil {
+        return aDoc, fmt.Errorf(
+          "document [%s] has an invalid timestamp: %s", aDoc.id, err,
+        )
+      }
+      aDoc.mtime = aTime.Unix()
+  }
+  return aDoc, nil
+}
+
+// Insert (or update) a pushed document.
+//
+func upsertPushedDocument(searchDB *sql.DB, aDoc pushedDocument) error {
+  err := upsertDocument(searchDB, indexedDocument{
+    path   : pushedDocPath(aDoc.id),
+    url    : aDoc.url,
+    title  : aDoc.title,
+    kind   : aDoc.kind,
+    mtime  : aDoc.mtime,
+    body   : aDoc.body,
+    source : pushSource,
+  })
+  if err != nil {
+    return fmt.Errorf("could not upsert into documents: %s", err)
+  }
+  return nil
+}
+
+// Delete a pushed document.
+//
+func deletePushedDocument(searchDB *sql.DB, anId string) error {
+  _, err := deleteDocument(searchDB, pushedDocPath(anId))
+  return err
+}
+
+// Wrap an ingestion handler so that it is only run for authenticated
+// requests, and limit the size of the request body.
+//
+func ingestHandler(handler http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    ingestTokens := getConfigAStr("Ingest.Tokens", []string{})
+    if len(ingestTokens) < 1 {
+      http.NotFound(w, r)
+      return
+    }
+    if !hasValidBearerToken(r, ingestTokens) {
+      WebserverLogf("ingest: unauthorized request for [%s]", r.URL.Path)
+      w.Header().Set("WWW-Authenticate", `Bearer realm="searcher-ingest"`)
+      http.Error(w, "unauthorized", http.StatusUnauthorized)
+      return
+    }
+    maxBodyBytes := getConfigInt("Ingest.MaxBodyBytes", 10*1024*1024)
+    r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+    WebserverLogf("ingest: %s [%s]", r.Method, r.URL)
+    handler(w, r)
+  }
+}
+
+func ingestDocumentHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+      case http.MethodPost, http.MethodPut :
+        bodyBytes, err := ioutil.ReadAll(r.Body)
+        if err != nil {
+          writeJsonMessage(w, http.StatusBadRequest, err.Error())
+          return
+        }
+        aDoc, err := parsePushedDocument(gjson.ParseBytes(bodyBytes))
+        if err != nil {
+          writeJsonMessage(w, http.StatusBadRequest, err.Error())
+          return
+        }
+        if err = upsertPushedDocument(searchDB, aDoc); err != nil {
+          WebserverMaybeError("ingesting document", err)
+          writeJsonMessage(w, http.StatusInternalServerError, err.Error())
+          return
+        }
+        writeJsonMessage(w, http.StatusOK, "upserted ["+aDoc.id+"]")
+      case http.MethodDelete :
+        anId := strings.TrimPrefix(r.URL.Path, "/ingest/documents/")
+        if len(anId) < 1 || anId == r.URL.Path {
+          writeJsonMessage(w, http.StatusBadRequest, "no document id supplied")
+          return
+        }
+        if err := deletePushedDocument(searchDB, anId); err != nil {
+          WebserverMaybeError("deleting document", err)
+          writeJsonMessage(w, http.StatusInternalServerError, err.Error())
+          return
+        }
+        writeJsonMessage(w, http.StatusOK, "deleted ["+anId+"]")
+      default :
+        w.Header().Set("Allow", "POST, PUT, DELETE")
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+  }
+}
+
+type bulkIngestError struct {
+  Line    int    `json:"line"`
+  Message string `json:"message"`
+}
+
+type bulkIngestResult struct {
+  Upserted int               `json:"upserted"`
+  Deleted  int               `json:"deleted"`
+  Errors   []bulkIngestError `json:"errors"`
+}
+
+// Upsert and/or delete documents, one (json) line at a time.
+//
+func bulkIngest(searchDB *sql.DB, ndjson io.Reader) (bulkIngestResult, error) {
+  result := bulkIngestResult{ Errors: []bulkIngestError{} }
+  lineScanner := bufio.NewScanner(ndjson)
+  lineScanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+  lineNum := 0
+  for lineScanner.Scan() {
+    lineNum = lineNum + 1
+    aLine := strings.TrimSpace(lineScanner.Text())
+    if len(aLine) < 1 { continue }
+    lineJson := gjson.Parse(aLine)
+    if toDelete := lineJson.Get("delete"); toDelete.Exists() {
+      if err := deletePushedDocument(searchDB, toDelete.String()); err != nil {
+        result.Errors = append(result.Errors, bulkIngestError{ lineNum, err.Error() })
+        continue
+      }
+      result.Deleted = result.Deleted + 1
+      continue
+    }
+    aDoc, err := parsePushedDocument(lineJson)
+    if err == nil { err = upsertPushedDocument(searchDB, aDoc) }
+    if err != nil {
+      result.Errors = append(result.Errors, bulkIngestError{ lineNum, err.Error() })
+      continue
+    }
+    result.Upserted = result.Upserted + 1
+  }
+  return result, lineScanner.Err()
+}
+
+func ingestBulkHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+      w.Header().Set("Allow", http.MethodPost)
+      http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+      return
+    }
+    result, err := bulkIngest(searchDB, r.Body)
+    WebserverLogf("ingest: bulk upserted %d deleted %d with %d errors",
+      result.Upserted, result.Deleted, len(result.Errors),
+    )
+    if err != nil {
+      result.Errors = append(result.Errors, bulkIngestError{ 0, err.Error() })
+      writeJson(w, http.StatusBadRequest, result)
+      return
+    }
+    writeJson(w, http.StatusOK, result)
+  }
+}
+
+func registerIngestHandlers(mux *http.ServeMux, searchDB *sql.DB) {
+  mux.HandleFunc("/ingest/documents",  ingestHandler(ingestDocumentHandler(searchDB)))
+  mux.HandleFunc("/ingest/documents/", ingestHandler(ingestDocumentHandler(searchDB)))
+  mux.HandleFunc("/ingest/bulk",       ingestHandler(ingestBulkHandler(searchDB)))
+}
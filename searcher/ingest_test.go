@@ -0,0 +1,69 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "github.com/tidwall/gjson"
+)
+
+func TestParsePushedDocument(t *testing.T) {
+  someTests := []struct {
+    name      string
+    docJson   string
+    wantError string
+    want      pushedDocument
+  }{
+    { name : "not an object", docJson : `[ 1, 2 ]`,
+      wantError : "must be a json object" },
+    { name : "missing id", docJson : `{ "url" : "https://example.com/a" }`,
+      wantError : "must have an id" },
+    { name : "missing url", docJson : `{ "id" : "a" }`,
+      wantError : "must have a url" },
+    { name : "defaults",
+      docJson : `{ "id" : "a", "url" : "https://example.com/a", "timestamp" : 42 }`,
+      want    : pushedDocument{
+        id : "a", url : "https://example.com/a", title : "https://example.com/a",
+        kind : " ", mtime : 42,
+      },
+    },
+    { name : "everything",
+      docJson : `{
+        "id" : "b", "url" : "https://example.com/b", "title" : "Bee",
+        "type" : "memo", "body" : "<p>Some text</p>",
+        "timestamp" : "2021-11-02T10:00:00Z"
+      }`,
+      want : pushedDocument{
+        id : "b", url : "https://example.com/b", title : "Bee",
+        kind : "memo", body : "Some text", mtime : 1635847200,
+      },
+    },
+    { name : "invalid timestamp",
+      docJson : `{ "id" : "c", "url" : "https://example.com/c", "timestamp" : "yesterday" }`,
+      wantError : "invalid timestamp",
+    },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aDoc, err := parsePushedDocument(gjson.Parse(aTest.docJson))
+      if 0 < len(aTest.wantError) {
+        if err == nil || !strings.Contains(err.Error(), aTest.wantError) {
+          t.Fatalf("expected an error containing [%s], got: %v", aTest.wantError, err)
+        }
+        return
+      }
+      if err != nil { t.Fatalf("unexpected error: %s", err) }
+      aDoc.body = strings.TrimSpace(aDoc.body)
+      if aDoc != aTest.want {
+        t.Errorf("got %+v, want %+v", aDoc, aTest.want)
+      }
+    })
+  }
+}
+
+func TestParsePushedDocumentDefaultsTheTimestamp(t *testing.T) {
+  aDoc, err := parsePushedDocument(gjson.Parse(
+    `{ "id" : "a", "url" : "https://example.com/a" }`,
+  ))
+  if err != nil { t.Fatalf("unexpected error: %s", err) }
+  if aDoc.mtime < 1 { t.Errorf("expected the current time, got %d", aDoc.mtime) }
+}
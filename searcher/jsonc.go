@@ -0,0 +1,100 @@
+package main
+
+/*
+
+  The searcher's configuration is written in JSONC (json with golang style
+  comments, and, since gjson is very tolerant, optional commas). The gjson
+  package ignores the comments when getting a path, but NOT when parsing
+  (or iterating over) a whole document. So we provide tools to strip the
+  comments and to rewrite a (tolerantly parsed) document as strict json.
+
+*/
+
+import (
+  "strings"
+  "encoding/json"
+  "github.com/tidwall/gjson"
+)
+
+// Remove any `// ...` and `/* ... */` comments from a JSONC string,
+// leaving anything inside a json string untouched.
+//
+func stripJsonComments(jsonc string) string {
+  var result strings.Builder
+  inString  := false
+  isEscaped := false
+  for i := 0; i < len(jsonc); i++ {
+    aChar := jsonc[i]
+    if inString {
+      result.WriteByte(aChar)
+      switch {
+        case isEscaped    : isEscaped = false
+        case aChar == '\\': isEscaped = true
+        case aChar == '"' : inString  = false
+      }
+      continue
+    }
+    if aChar == '"' {
+      inString = true
+      result.WriteByte(aChar)
+      continue
+    }
+    if aChar == '/' && i+1 < len(jsonc) && jsonc[i+1] == '/' {
+      for i < len(jsonc) && jsonc[i] != '\n' { i++ }
+      result.WriteByte('\n')
+      continue
+    }
+    if aChar == '/' && i+1 < len(jsonc) && jsonc[i+1] == '*' {
+      i = i + 2
+      for i+1 < len(jsonc) && !(jsonc[i] == '*' && jsonc[i+1] == '/') { i++ }
+      i = i + 1
+      result.WriteByte(' ')
+      continue
+    }
+    result.WriteByte(aChar)
+  }
+  return result.String()
+}
+
+// Quote a string as json (without escaping any html characters).
+//
+func quoteJsonString(aStr string) string {
+  var quoted strings.Builder
+  encoder := json.NewEncoder(&quoted)
+  encoder.SetEscapeHTML(false)
+  encoder.Encode(aStr)
+  return strings.TrimSuffix(quoted.String(), "\n")
+}
+
+// Rewrite a (tolerantly parsed) gjson value as strict json.
+//
+func toStrictJson(aValue gjson.Result) string {
+  switch {
+    case aValue.IsObject() :
+      someMembers := []string{}
+      aValue.ForEach(func(aKey, aMember gjson.Result) bool {
+        someMembers = append(
+          someMembers, quoteJsonString(aKey.String())+":"+toStrictJson(aMember),
+        )
+        return true
+      })
+      return "{"+strings.Join(someMembers, ",")+"}"
+    case aValue.IsArray() :
+      someItems := []string{}
+      for _, anItem := range aValue.Array() {
+        someItems = append(someItems, toStrictJson(anItem))
+      }
+      return "["+strings.Join(someItems, ",")+"]"
+    case aValue.Type == gjson.String :
+      return quoteJsonString(aValue.String())
+    case !aValue.Exists() :
+      return "null"
+  }
+  return aValue.Raw
+}
+
+// Convert a JSONC document into strict json.
+//
+func jsoncToJson(jsonc string) string {
+  return toStrictJson(gjson.Parse(stripJsonComments(jsonc)))
+}
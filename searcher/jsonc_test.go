@@ -0,0 +1,79 @@
+package main
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestStripJsonComments(t *testing.T) {
+  someTests := []struct {
+    name  string
+    jsonc string
+    want  string
+  }{
+    { "no comments", `{ "a" : 1 }`, `{ "a" : 1 }` },
+    { "line comment", "{ \"a\" : 1 // one\n}", "{ \"a\" : 1 \n}" },
+    { "block comment", `{ /* the a */ "a" : 1 }`, `{   "a" : 1 }` },
+    { "multi-line block comment", "{ /* one\n two */ \"a\" : 1 }", "{   \"a\" : 1 }" },
+    { "comment markers in a string", `{ "a" : "http://x/*y*/" }`, `{ "a" : "http://x/*y*/" }` },
+    { "escaped quote in a string", `{ "a" : "say \"//\"" } // done`, `{ "a" : "say \"//\"" } ` + "\n" },
+    { "unterminated block comment", `{ "a" : 1 } /* oops`, `{ "a" : 1 }  ` },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      if got := stripJsonComments(aTest.jsonc); got != aTest.want {
+        t.Errorf("got %q, want %q", got, aTest.want)
+      }
+    })
+  }
+}
+
+func TestValidateConfig(t *testing.T) {
+  someTests := []struct {
+    name         string
+    jsonc        string
+    wantErrors   []string
+    wantWarnings []string
+  }{
+    { name : "empty", jsonc : `{}` },
+    { name : "not an object", jsonc : `[]`,
+      wantErrors : []string{ "must be a json object" } },
+    { name : "comments and no commas",
+      jsonc : `{
+        // the port
+        "Port" : 8080
+        "HtmlDirs" : [ "files" ] /* where the files are */
+      }` },
+    { name : "wrong type", jsonc : `{ "Port" : "8080" }`,
+      wantErrors : []string{ "[Port] must be of type int" } },
+    { name : "not an int", jsonc : `{ "Port" : 80.5 }`,
+      wantErrors : []string{ "[Port] must be of type int" } },
+    { name : "unknown key", jsonc : `{ "Indexer" : { "Sleep" : 1 } }`,
+      wantWarnings : []string{ "unknown configuration key [Indexer.Sleep]" } },
+    { name : "nested validator", jsonc : `{ "Ranking" : { "Candidates" : 0 } }`,
+      wantErrors : []string{ "[Ranking.Candidates]" } },
+    { name : "too many candidates", jsonc : `{ "Ranking" : { "Candidates" : 100000 } }`,
+      wantErrors : []string{ "[Ranking.Candidates] must be between 1 and 1000" } },
+    { name : "bad ACL", jsonc : `{ "Auth" : { "ACL" : { "bob" : [ "/" ] } } }`,
+      wantErrors : []string{ "principal [bob]" } },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      problems := validateConfig(aTest.jsonc)
+      checkProblems(t, "errors",   problems.errors,   aTest.wantErrors)
+      checkProblems(t, "warnings", problems.warnings, aTest.wantWarnings)
+    })
+  }
+}
+
+func checkProblems(t *testing.T, aKind string, got []string, want []string) {
+  t.Helper()
+  if len(got) != len(want) {
+    t.Fatalf("got %s %q, want %q", aKind, got, want)
+  }
+  for i, aWant := range want {
+    if !strings.Contains(got[i], aWant) {
+      t.Errorf("got %s %q, want %q", aKind, got, want)
+    }
+  }
+}
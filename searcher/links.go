@@ -0,0 +1,439 @@
+package main
+
+/*
+
+  Pages (such as those of a wiki mirror) which link heavily to each other
+  tell us which pages matter, and what they are about. So, whenever an
+  html file or crawled page is (re)indexed, its `<a href>` links are
+  recorded in the links table:
+
+    fromDocId  : the docId of the linking document
+    toPath     : the path (or url) of the linked document
+    anchorText : the (stripped) text of the link
+
+  Links in documents with a url (crawled pages and the pages in a sitemap
+  root) are resolved against that url, so their toPath is the url of the
+  document they link to. Links in other files are resolved to the file
+  they link to (just as a web server would, see: sitemapPageFile), trying
+  the link's path as is, with .html added and (for a directory) its
+  index.html. Links starting with `/` are resolved against the file's
+  site directory, that is the nearest directory (above the file, up to
+  its HtmlDirs root) containing the linked file. So in a mirror such as
+  `files/nginx/en.wikipedia.org/wiki/...` a link to `/wiki/Gravity`
+  points to `files/nginx/en.wikipedia.org/wiki/Gravity.html`. (A link to
+  a file which does not exist (yet) is resolved against the file's
+  directory, or its HtmlDirs root.) Links to a document from itself are
+  ignored.
+
+  After an index pass which has changed any documents or links, we
+  recompute, for every document:
+
+    - its linkScore, a PageRank (with a damping factor of linkDamping)
+      over the links between documents, scaled so that the average
+      document scores 1,
+
+    - its anchors, the (distinct) anchor texts of the links to it (at
+      most maxAnchorBytes), which are indexed (as the documentSearch
+      index's anchors column) so that a document can be found by what
+      other documents call it.
+
+  Only the links in documents which everyone may search (see: `Auth.ACL`)
+  are used, so that neither the anchors nor the link scores of a
+  document reveal anything about the documents a searcher may not see.
+  (The link scores are recomputed whenever the `Auth.ACL` changes.)
+
+  If `Ranking.LinkWeight` is greater than zero, search results are
+  re-ranked (along with their popularity, see: popularity.go) using:
+
+    score = relevance + LinkWeight * ln(1 + linkScore)
+
+  NOTE: the links of documents indexed before the links table existed are
+  only recorded when they are next reindexed (see: /admin/reindex).
+
+*/
+
+import (
+  "math"
+  "path"
+  "regexp"
+  "strings"
+  "net/url"
+  "sync/atomic"
+  "path/filepath"
+  "database/sql"
+)
+
+const (
+  linkDamping    = 0.85
+  maxAnchorBytes = 10000
+)
+
+func linkWeight() float64 {
+  return getConfigFloat("Ranking.LinkWeight", 0)
+}
+
+// (an anchor's closing tag is optional, so unclosed anchors are still
+// found)
+//
+var linkRegexp *regexp.Regexp = regexp.MustCompile(
+  `(?is)<a\s[^>]*?href\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))(?:[^>]*>(.*?)</a>)?`,
+)
+
+type pageLink struct {
+  href string
+  text string
+}
+
+// The (unresolved) links in a page.
+//
+func findPageLinks(pageStr string) []pageLink {
+  someLinks := []pageLink{}
+  for _, aMatch := range linkRegexp.FindAllStringSubmatch(pageStr, -1) {
+    aHref := strings.TrimSpace(aMatch[1] + aMatch[2] + aMatch[3])
+    someLinks = append(someLinks, pageLink{
+      href : strings.Replace(aHref, "&amp;", "&", -1),
+      text : strings.TrimSpace(stripHtml(aMatch[4])),
+    })
+  }
+  return someLinks
+}
+
+// The HtmlDirs root containing a file (or "" if there is none).
+//
+func findHtmlDirRoot(filePath string) string {
+  for _, anHtmlDir := range getConfigAStr("HtmlDirs", []string{ "files" }) {
+    aRoot := htmlDirRoot(anHtmlDir)
+    if isInDirectory(filePath, []string{ aRoot }) { return aRoot }
+  }
+  return ""
+}
+
+// The path (or url) of the document a link (in a document) points to, or
+// "" if the link can not point to a document.
+//
+func linkTarget(docPath string, docUrl string, aHref string) string {
+  hrefUrl, err := url.Parse(aHref)
+  if err != nil { return "" }
+  if hrefUrl.Scheme != "" && hrefUrl.Scheme != "http" && hrefUrl.Scheme != "https" {
+    return ""
+  }
+  if 0 < len(docUrl) || hrefUrl.IsAbs() {
+    baseUrl, err := url.Parse(docUrl)
+    if err != nil { return "" }
+    targetUrl, err := baseUrl.Parse(aHref)
+    if err != nil || !targetUrl.IsAbs() { return "" }
+    return crawlUrl(targetUrl)
+  }
+
+  // (a link in a file)
+  if len(hrefUrl.Path) < 1 { return "" }
+  isDirectory := strings.HasSuffix(hrefUrl.Path, "/")
+  targetPath  := filepath.FromSlash(path.Clean(hrefUrl.Path))
+  // (the file a link points to, if it does not exist)
+  missingFile := func(aDir string) string {
+    filePath := filepath.Join(aDir, targetPath)
+    if isDirectory { filePath = filepath.Join(filePath, "index.html") }
+    return filePath
+  }
+  if !strings.HasPrefix(hrefUrl.Path, "/") {
+    docDir := filepath.Dir(docPath)
+    if aFile := findPageFile(filepath.Join(docDir, targetPath), isDirectory); 0 < len(aFile) {
+      return aFile
+    }
+    return missingFile(docDir)
+  }
+  aRoot := findHtmlDirRoot(docPath)
+  if len(aRoot) < 1 { return "" }
+  aRoot = filepath.Clean(aRoot)
+  for aDir := filepath.Dir(docPath); isInDirectory(aDir, []string{ aRoot }); aDir = filepath.Dir(aDir) {
+    if aFile := findPageFile(filepath.Join(aDir, targetPath), isDirectory); 0 < len(aFile) {
+      return aFile
+    }
+    if filepath.Clean(aDir) == aRoot { break }
+  }
+  return missingFile(aRoot)
+}
+
+/////////////////////////////
+// Recording links
+
+var linkGraphChanged int32 = 1
+
+// Note that the documents (or their links) have changed, so the link
+// scores need to be recomputed.
+//
+func markLinkGraphChanged() {
+  atomic.StoreInt32(&linkGraphChanged, 1)
+}
+
+// Record (replace) the links in an (indexed) html document.
+//
+func recordDocumentLinks(
+  searchDB *sql.DB, docPath string, docUrl string, pageStr string,
+) error {
+  newLinks := [][2]string{}
+  for _, aLink := range findPageLinks(pageStr) {
+    aTarget := linkTarget(docPath, docUrl, aLink.href)
+    if len(aTarget) < 1 || aTarget == docPath || aTarget == docUrl { continue }
+    newLinks = append(newLinks, [2]string{ aTarget, aLink.text })
+  }
+
+  tx, err := searchDB.Begin()
+  if err != nil { return err }
+  var docId int64
+  err = tx.QueryRow("select docId from documents where docPath = ?", docPath).Scan(&docId)
+  if err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  // (only change the links if they have changed)
+  rows, err := tx.Query(
+    "select toPath, anchorText from links where fromDocId = ? order by rowid", docId,
+  )
+  if err != nil {
+    tx.Rollback()
+    return err
+  }
+  oldLinks := [][2]string{}
+  for rows.Next() {
+    var toPath     string
+    var anchorText sql.NullString
+    if err = rows.Scan(&toPath, &anchorText); err != nil { break }
+    oldLinks = append(oldLinks, [2]string{ toPath, anchorText.String })
+  }
+  if err == nil { err = rows.Err() }
+  rows.Close()
+  if err != nil {
+    tx.Rollback()
+    return err
+  }
+  isUnchanged := len(oldLinks) == len(newLinks)
+  for anIndex := 0; isUnchanged && anIndex < len(newLinks); anIndex++ {
+    isUnchanged = oldLinks[anIndex] == newLinks[anIndex]
+  }
+  if isUnchanged { return tx.Rollback() }
+
+  if _, err = tx.Exec("delete from links where fromDocId = ?", docId); err != nil {
+    tx.Rollback()
+    return err
+  }
+  for _, aLink := range newLinks {
+    _, err = tx.Exec(
+      "insert into links ( fromDocId, toPath, anchorText ) values ( ?, ?, ? )",
+      docId, aLink[0], aLink[1],
+    )
+    if err != nil {
+      tx.Rollback()
+      return err
+    }
+  }
+  if err = tx.Commit(); err != nil { return err }
+  markLinkGraphChanged()
+  return nil
+}
+
+/////////////////////////////
+// Link scores
+
+type linkedDocument struct {
+  docId      int64
+  anchors    string
+  linkScore  float64
+  outLinks   []int
+  newAnchors []string
+}
+
+// Compute the PageRank of each document (scaled so that the average
+// document scores 1).
+//
+func computeLinkScores(someDocs []linkedDocument) []float64 {
+  numDocs := len(someDocs)
+  ranks   := make([]float64, numDocs)
+  for i := range ranks { ranks[i] = 1 / float64(numDocs) }
+  for iteration := 0; iteration < 100; iteration++ {
+    danglingRank := 0.0
+    for i, aDoc := range someDocs {
+      if len(aDoc.outLinks) < 1 { danglingRank = danglingRank + ranks[i] }
+    }
+    // (the rank of documents without links is shared by every document)
+    baseRank := (1 - linkDamping + linkDamping * danglingRank) / float64(numDocs)
+    newRanks := make([]float64, numDocs)
+    for i := range newRanks { newRanks[i] = baseRank }
+    for i, aDoc := range someDocs {
+      if len(aDoc.outLinks) < 1 { continue }
+      aShare := linkDamping * ranks[i] / float64(len(aDoc.outLinks))
+      for _, j := range aDoc.outLinks { newRanks[j] = newRanks[j] + aShare }
+    }
+    aChange := 0.0
+    for i := range ranks { aChange = aChange + math.Abs(newRanks[i] - ranks[i]) }
+    ranks = newRanks
+    if aChange < 1e-6 { break }
+  }
+  for i := range ranks { ranks[i] = ranks[i] * float64(numDocs) }
+  return ranks
+}
+
+// Load the documents and the links between them.
+//
+func loadLinkGraph(searchDB *sql.DB) ([]linkedDocument, error) {
+  rows, err := searchDB.Query(
+    "select docId, docPath, url, anchors, linkScore from documents order by docId",
+  )
+  if err != nil { return nil, err }
+  someDocs   := []linkedDocument{}
+  docIndexes := map[string]int{}
+  idIndexes  := map[int64]int{}
+  for rows.Next() {
+    var aDoc     linkedDocument
+    var docPath  string
+    var docUrl   sql.NullString
+    var anchors  sql.NullString
+    var aScore   sql.NullFloat64
+    if err = rows.Scan(&aDoc.docId, &docPath, &docUrl, &anchors, &aScore); err != nil {
+      rows.Close()
+      return nil, err
+    }
+    aDoc.anchors   = anchors.String
+    aDoc.linkScore = aScore.Float64
+    docIndexes[docPath] = len(someDocs)
+    if 0 < len(docUrl.String) { docIndexes[docUrl.String] = len(someDocs) }
+    idIndexes[aDoc.docId] = len(someDocs)
+    someDocs = append(someDocs, aDoc)
+  }
+  err = rows.Err()
+  rows.Close()
+  if err != nil { return nil, err }
+
+  // (only the links in documents which everyone may search)
+  aclSql, aclArgs := aclCondition("documents.docPath", allowedPathPrefixes(nil))
+  rows, err = searchDB.Query(`
+    select links.fromDocId, links.toPath, links.anchorText from links
+      join documents on documents.docId = links.fromDocId
+      where `+aclSql+`
+      order by links.rowid
+  `, aclArgs...)
+  if err != nil { return nil, err }
+  defer rows.Close()
+  hasLink   := map[[2]int]bool{}
+  hasAnchor := map[int]map[string]bool{}
+  for rows.Next() {
+    var fromDocId  int64
+    var toPath     string
+    var anchorText sql.NullString
+    if err = rows.Scan(&fromDocId, &toPath, &anchorText); err != nil { return nil, err }
+    fromIndex, isFound := idIndexes[fromDocId]
+    if !isFound { continue }
+    toIndex, isFound := docIndexes[toPath]
+    if !isFound || toIndex == fromIndex { continue }
+    if !hasLink[[2]int{ fromIndex, toIndex }] {
+      hasLink[[2]int{ fromIndex, toIndex }] = true
+      someDocs[fromIndex].outLinks = append(someDocs[fromIndex].outLinks, toIndex)
+    }
+    if len(anchorText.String) < 1 { continue }
+    if hasAnchor[toIndex] == nil { hasAnchor[toIndex] = map[string]bool{} }
+    if hasAnchor[toIndex][anchorText.String] { continue }
+    hasAnchor[toIndex][anchorText.String] = true
+    someDocs[toIndex].newAnchors = append(someDocs[toIndex].newAnchors, anchorText.String)
+  }
+  return someDocs, rows.Err()
+}
+
+// The anchors of a document (at most maxAnchorBytes of its anchor texts).
+//
+func documentAnchors(someAnchors []string) string {
+  anchors := ""
+  for _, anAnchor := range someAnchors {
+    if maxAnchorBytes < len(anchors) + len(anAnchor) + 1 { break }
+    if 0 < len(anchors) { anchors = anchors + " " }
+    anchors = anchors + anAnchor
+  }
+  return anchors
+}
+
+// Recompute (and store) the link score and the anchors of every document,
+// in batches (each in its own transaction). Returns the number of
+// documents changed.
+//
+func updateLinkScores(searchDB *sql.DB) (int, error) {
+  someDocs, err := loadLinkGraph(searchDB)
+  if err != nil || len(someDocs) < 1 { return 0, err }
+  linkScores := computeLinkScores(someDocs)
+
+  numChanged := 0
+  tx, err := searchDB.Begin()
+  if err != nil { return 0, err }
+  numInBatch := 0
+  for i, aDoc := range someDocs {
+    anchors := documentAnchors(aDoc.newAnchors)
+    if anchors == aDoc.anchors && math.Abs(linkScores[i] - aDoc.linkScore) < 1e-3 {
+      continue
+    }
+    _, err = tx.Exec(
+      "update documents set anchors = ?, linkScore = ? where docId = ?",
+      anchors, linkScores[i], aDoc.docId,
+    )
+    if err != nil {
+      tx.Rollback()
+      return numChanged, err
+    }
+    numInBatch = numInBatch + 1
+    if numInBatch < 1000 { continue }
+    if err = tx.Commit(); err != nil { return numChanged, err }
+    numChanged = numChanged + numInBatch
+    numInBatch = 0
+    if isShuttingDown() { return numChanged, nil }
+    if tx, err = searchDB.Begin(); err != nil { return numChanged, err }
+  }
+  if err = tx.Commit(); err != nil { return numChanged, err }
+  return numChanged + numInBatch, nil
+}
+
+// Recompute the link scores (if any documents or links have changed).
+//
+func maybeUpdateLinkScores(searchDB *sql.DB) {
+  if !atomic.CompareAndSwapInt32(&linkGraphChanged, 1, 0) { return }
+  IndexerLog("updating the link scores")
+  numChanged, err := updateLinkScores(searchDB)
+  if err != nil {
+    IndexerMaybeError("could not update the link scores", err)
+    markLinkGraphChanged()
+  }
+  IndexerLogf("updated the link scores of %d documents", numChanged)
+}
+
+// Add the (weighted) link score of each of the search results to its
+// relevance.
+//
+func addLinkBoost(searchDB *sql.DB, results []SearchResults) {
+  weight := linkWeight()
+  if weight <= 0 || len(results) < 1 { return }
+
+  someArgs := []interface{}{}
+  for _, aResult := range results {
+    someArgs = append(someArgs, aResult.docPath)
+  }
+  rows, err := searchDB.Query(`
+    select docPath, coalesce(linkScore, 0) from documents
+      where docPath in ( ?`+strings.Repeat(", ?", len(results)-1)+` )
+  `, someArgs...)
+  if err != nil {
+    WebserverMaybeError("could not get the link scores of the results", err)
+    return
+  }
+  defer rows.Close()
+  linkScores := map[string]float64{}
+  for rows.Next() {
+    var docPath string
+    var aScore  float64
+    if err = rows.Scan(&docPath, &aScore); err != nil {
+      WebserverMaybeError("could not get the link scores of the results", err)
+      return
+    }
+    linkScores[docPath] = aScore
+  }
+  for i := range results {
+    results[i].relevance = results[i].relevance +
+      weight * math.Log1p(linkScores[results[i].docPath])
+  }
+}
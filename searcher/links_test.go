@@ -0,0 +1,147 @@
+package main
+
+import (
+  "math"
+  "testing"
+  "path/filepath"
+)
+
+// Override (some of) the configuration for the duration of a test.
+//
+func setTestConfig(t *testing.T, someSets ...string) {
+  t.Helper()
+  if problems := setConfigOverrides(someSets, "", 0); 0 < len(problems.errors) {
+    t.Fatalf("could not set the configuration: %q", problems.errors)
+  }
+  t.Cleanup(func() { setConfigOverrides([]string{}, "", 0) })
+}
+
+func TestLinkTarget(t *testing.T) {
+  setTestConfig(t, `HtmlDirs=["../files"]`)
+  wikiDir := filepath.Join("..", "files", "nginx", "en.wikipedia.org", "wiki")
+  docPath := filepath.Join(wikiDir, "Quantum_gravity.html")
+  someTests := []struct {
+    name   string
+    docUrl string
+    href   string
+    want   string
+  }{
+    { "root relative", "", "/wiki/Quantum_gravity", docPath },
+    { "root relative with .html", "", "/wiki/Quantum_gravity.html", docPath },
+    { "root relative with a query", "", "/wiki/Quantum_gravity?action=view#top", docPath },
+    { "relative", "", "Quantum_gravity", docPath },
+    { "relative to a parent", "", "../wiki/Quantum_gravity", docPath },
+    { "missing root relative", "", "/wiki/Quantum_mechanics",
+      filepath.Join("..", "files", "wiki", "Quantum_mechanics") },
+    { "missing relative", "", "Quantum_mechanics",
+      filepath.Join(wikiDir, "Quantum_mechanics") },
+    { "missing directory", "", "/wiki/",
+      filepath.Join("..", "files", "wiki", "index.html") },
+    { "fragment only", "", "#Overview", "" },
+    { "other scheme", "", "mw-data:TemplateStyles:r1", "" },
+    { "mailto", "", "mailto:someone@example.com", "" },
+    { "absolute", "", "https://EN.wikipedia.org/wiki/Gravity#top",
+      "https://en.wikipedia.org/wiki/Gravity" },
+    { "with a url", "https://en.wikipedia.org/wiki/Quantum_gravity", "/wiki/Gravity",
+      "https://en.wikipedia.org/wiki/Gravity" },
+    { "relative with a url", "https://en.wikipedia.org/wiki/Quantum_gravity", "Gravity",
+      "https://en.wikipedia.org/wiki/Gravity" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      if got := linkTarget(docPath, aTest.docUrl, aTest.href); got != aTest.want {
+        t.Errorf("linkTarget(%q) = %q, want %q", aTest.href, got, aTest.want)
+      }
+    })
+  }
+}
+
+func TestComputeLinkScores(t *testing.T) {
+  someTests := []struct {
+    name     string
+    outLinks [][]int
+    want     []float64
+  }{
+    { "no links", [][]int{ {}, {}, {} }, []float64{ 1, 1, 1 } },
+    { "a cycle", [][]int{ { 1 }, { 2 }, { 0 } }, []float64{ 1, 1, 1 } },
+    { "a pair", [][]int{ { 1 }, { 0 } }, []float64{ 1, 1 } },
+    // (the leaves only get the base rank, l = (0.15 + 0.85*h)/3, and the
+    // hub h = l + 0.85*2*l, so l = 1/4.7, scaled by 3)
+    { "a star", [][]int{ {}, { 0 }, { 0 } }, []float64{ 1.7234, 0.6383, 0.6383 } },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      someDocs := []linkedDocument{}
+      for _, someLinks := range aTest.outLinks {
+        someDocs = append(someDocs, linkedDocument{ outLinks: someLinks })
+      }
+      got := computeLinkScores(someDocs)
+      totalScore := 0.0
+      for i := range got {
+        totalScore = totalScore + got[i]
+        if 1e-3 < math.Abs(got[i] - aTest.want[i]) {
+          t.Errorf("got score %g for document %d, want %g", got[i], i, aTest.want[i])
+        }
+      }
+      // (the average document scores 1)
+      if 1e-6 < math.Abs(totalScore - float64(len(got))) {
+        t.Errorf("got a total score of %g, want %d", totalScore, len(got))
+      }
+    })
+  }
+}
+
+func TestRestrictedLinksAreIgnored(t *testing.T) {
+  setTestConfig(t, `Auth.ACL={"*":["files/public/"],"user:alice":["files/private/"]}`)
+  searchDB := openTestSearchDB(t)
+  for _, aPath := range []string{
+    "files/public/a.html", "files/public/b.html", "files/private/secret.html",
+  } {
+    err := upsertDocument(searchDB, indexedDocument{
+      path : aPath, title : aPath, body : "a page", source : fileSource,
+    })
+    if err != nil { t.Fatalf("could not insert [%s]: %s", aPath, err) }
+  }
+  someLinks := [][3]string{
+    { "files/public/b.html",       "files/public/a.html", "alpha page" },
+    { "files/private/secret.html", "files/public/a.html", "classified codename" },
+    { "files/private/secret.html", "files/public/b.html", "classified codename" },
+  }
+  for _, aLink := range someLinks {
+    mustExec(t, searchDB, `insert into links
+      select docId, ?, ? from documents where docPath = ?`, aLink[1], aLink[2], aLink[0])
+  }
+
+  if _, err := updateLinkScores(searchDB); err != nil {
+    t.Fatalf("could not update the link scores: %s", err)
+  }
+  someDocs := []struct {
+    docPath     string
+    wantAnchors string
+  }{
+    { "files/public/a.html",       "alpha page" },
+    { "files/public/b.html",       "" },
+    { "files/private/secret.html", "" },
+  }
+  linkScores := map[string]float64{}
+  for _, aDoc := range someDocs {
+    var anchors string
+    var aScore  float64
+    err := searchDB.QueryRow(
+      "select coalesce(anchors, ''), linkScore from documents where docPath = ?", aDoc.docPath,
+    ).Scan(&anchors, &aScore)
+    if err != nil { t.Fatalf("could not find [%s]: %s", aDoc.docPath, err) }
+    if anchors != aDoc.wantAnchors {
+      t.Errorf("[%s] got anchors %q, want %q", aDoc.docPath, anchors, aDoc.wantAnchors)
+    }
+    linkScores[aDoc.docPath] = aScore
+  }
+  // (b is linked to by the secret document alone, so it scores the same
+  // as that document)
+  if 1e-3 < math.Abs(linkScores["files/public/b.html"] - linkScores["files/private/secret.html"]) {
+    t.Errorf("a restricted document's links changed the link scores: %v", linkScores)
+  }
+  if got := searchDocPaths(t, searchDB, "anchors:classified"); 0 < len(got) {
+    t.Errorf("found restricted anchor text in %q", got)
+  }
+}
@@ -4,9 +4,11 @@ package main
 
 import (
   "os"
+  "fmt"
   "log"
   "time"
   "flag"
+  "sync"
   "math/rand"
 )
 
@@ -23,7 +25,18 @@ func main() {
   logFilePath := flag.String(
     "l", "stderr", "The searcher log file path",
   )
+  var configSets configSetFlags
+  flag.Var(
+    &configSets, "set",
+    "Override a configuration value (Path=value, may be repeated)",
+  )
 
+  flag.Usage = func() {
+    commandUsage()
+    fmt.Fprintln(os.Stderr, "")
+    fmt.Fprintln(os.Stderr, "flags:")
+    flag.PrintDefaults()
+  }
   flag.Parse()
 
   // Setup logging
@@ -41,22 +54,75 @@ func main() {
       defer logFile.Close()
       log.SetOutput(logFile)
   }
+  // load the configuration file (and any environment or command line
+  // overrides)
+  setConfigFilePath(*configFilePath)
+  problems := setConfigOverrides(
+    configSets, *webServerHost, int64(*webServerPort),
+  )
+  for _, anError := range problems.errors {
+    log.Printf("Searcher(error): %s", anError)
+  }
+  if 0 < len(problems.errors) {
+    log.Fatal("Searcher(fatal): invalid configuration overrides")
+  }
+
+  // run a (one shot) command if one has been given
+  if 0 < flag.NArg() {
+    os.Exit(runCommand(flag.Args()))
+  }
+
   log.Print("Searcher: starting");
   defer log.Print("Searcher: finished");
 
-  // load the configuration file
-  setConfigFilePath(*configFilePath)
-
   // setup sleep's random number generator
   rand.Seed(time.Now().UnixNano())
 
   // ensure the database exists and has the structure we require
   initDatabaseStructure()
 
-  go runWebServer(*webServerHost, int64(*webServerPort))
+  // open the (one) writer connection before any readers
+  writerDB, err := getSearchWriter()
+  if err != nil { log.Fatalf("Searcher(fatal): could not open the database: %s", err) }
+  defer writerDB.Close()
+
+  // handle SIGTERM/SIGINT (shutdown), SIGHUP (reload) and SIGUSR1 (index)
+  go handleSignals()
+
+  // reload the configuration file whenever it changes
+  go watchConfigFile()
+
+  // record search analytics (until the webServer has finished)
+  webServerFinished := make(chan struct{})
+  var analyticsDone sync.WaitGroup
+  analyticsDone.Add(1)
+  go func() {
+    defer analyticsDone.Done()
+    runAnalytics(writerDB, webServerFinished)
+  }()
+
+  // take any scheduled backups
+  var backupsDone sync.WaitGroup
+  backupsDone.Add(1)
+  go func() {
+    defer backupsDone.Done()
+    runScheduledBackups()
+  }()
+
+  go func() {
+    defer close(webServerFinished)
+    runWebServer()
+  }()
 
+  // index files until we are asked to shutdown...
   indexFiles()
 
+  // ... then wait for the webServer to drain any in-flight requests (and
+  // then for any of their analytics to be recorded), as well as for any
+  // backup in progress
+  <-webServerFinished
+  analyticsDone.Wait()
+  backupsDone.Wait()
 }
 
 
@@ -0,0 +1,220 @@
+package main
+
+/*
+
+  We implement a (very) small subset of the Prometheus metrics types
+  (counters and histograms) and their text exposition format. SEE:
+  https://prometheus.io/docs/instrumenting/exposition_formats/
+
+  This keeps the searcher free of the (rather large) Prometheus client
+  library dependencies.
+
+  Counters are updated using sync/atomic, histograms are protected by a
+  Mutex. All metrics register themselves (in declaration order) so that
+  the /metrics handler can write them all out.
+
+*/
+
+import (
+  "io"
+  "fmt"
+  "sync"
+  "time"
+  "strconv"
+  "net/http"
+  "sync/atomic"
+)
+
+type metric interface {
+  writeMetric(w io.Writer)
+}
+
+var allMetrics []metric
+
+type metricsCounter struct {
+  name  string
+  help  string
+  value uint64
+}
+
+func newCounter(aName string, aHelp string) *metricsCounter {
+  mc := &metricsCounter{ name: aName, help: aHelp }
+  allMetrics = append(allMetrics, mc)
+  return mc
+}
+
+func (mc *metricsCounter) inc() {
+  atomic.AddUint64(&mc.value, 1)
+}
+
+func (mc *metricsCounter) add(delta uint64) {
+  atomic.AddUint64(&mc.value, delta)
+}
+
+func (mc *metricsCounter) get() uint64 {
+  return atomic.LoadUint64(&mc.value)
+}
+
+func (mc *metricsCounter) writeMetric(w io.Writer) {
+  fmt.Fprintf(w, "# HELP %s %s\n", mc.name, mc.help)
+  fmt.Fprintf(w, "# TYPE %s counter\n", mc.name)
+  fmt.Fprintf(w, "%s %d\n", mc.name, atomic.LoadUint64(&mc.value))
+}
+
+type metricsHistogram struct {
+  name    string
+  help    string
+  buckets []float64
+  counts  []uint64
+  sum     float64
+  count   uint64
+  update  sync.Mutex
+}
+
+func newHistogram(
+  aName string, aHelp string, someBuckets []float64,
+) *metricsHistogram {
+  mh := &metricsHistogram{
+    name    : aName,
+    help    : aHelp,
+    buckets : someBuckets,
+    counts  : make([]uint64, len(someBuckets)),
+  }
+  allMetrics = append(allMetrics, mh)
+  return mh
+}
+
+func (mh *metricsHistogram) observe(aValue float64) {
+  mh.update.Lock()
+  defer mh.update.Unlock()
+
+  for i, upperBound := range mh.buckets {
+    if aValue <= upperBound { mh.counts[i]++ }
+  }
+  mh.sum   += aValue
+  mh.count++
+}
+
+func (mh *metricsHistogram) writeMetric(w io.Writer) {
+  mh.update.Lock()
+  defer mh.update.Unlock()
+
+  fmt.Fprintf(w, "# HELP %s %s\n", mh.name, mh.help)
+  fmt.Fprintf(w, "# TYPE %s histogram\n", mh.name)
+  for i, upperBound := range mh.buckets {
+    fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n",
+      mh.name, strconv.FormatFloat(upperBound, 'g', -1, 64), mh.counts[i],
+    )
+  }
+  fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", mh.name, mh.count)
+  fmt.Fprintf(w, "%s_sum %s\n", mh.name, strconv.FormatFloat(mh.sum, 'g', -1, 64))
+  fmt.Fprintf(w, "%s_count %d\n", mh.name, mh.count)
+}
+
+/////////////////////////////
+// The searcher's metrics
+//
+
+var latencyBuckets []float64 = []float64{
+  0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+var queryDuration = newHistogram(
+  "searcher_query_duration_seconds",
+  "The time taken to answer a search query.",
+  latencyBuckets,
+)
+
+var queryResults = newHistogram(
+  "searcher_query_results",
+  "The number of results returned by a search query.",
+  []float64{ 0, 1, 5, 10, 25, 50, 100, 200 },
+)
+
+var queriesTotal = newCounter(
+  "searcher_queries_total",
+  "The total number of search queries answered.",
+)
+
+var zeroResultQueries = newCounter(
+  "searcher_zero_result_queries_total",
+  "The total number of search queries which found no results.",
+)
+
+var webserverErrors = newCounter(
+  "searcher_webserver_errors_total",
+  "The total number of errors logged by the webServer.",
+)
+
+var indexerPassDuration = newHistogram(
+  "searcher_indexer_pass_duration_seconds",
+  "The time taken by an indexer pass.",
+  []float64{ 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600 },
+)
+
+var indexerFilesAdded = newCounter(
+  "searcher_indexer_files_added_total",
+  "The total number of files added to the index.",
+)
+
+var indexerFilesUpdated = newCounter(
+  "searcher_indexer_files_updated_total",
+  "The total number of changed files updated in the index.",
+)
+
+var indexerFilesRemoved = newCounter(
+  "searcher_indexer_files_removed_total",
+  "The total number of missing files removed from the index.",
+)
+
+var indexerFilesSkipped = newCounter(
+  "searcher_indexer_files_skipped_total",
+  "The total number of files skipped since they are not indexable.",
+)
+
+var indexerFilesFailed = newCounter(
+  "searcher_indexer_files_failed_total",
+  "The total number of files which could not be indexed.",
+)
+
+var crawlerPagesFetched = newCounter(
+  "searcher_crawler_pages_fetched_total",
+  "The total number of (changed) pages fetched by the crawler.",
+)
+
+var crawlerPagesNotModified = newCounter(
+  "searcher_crawler_pages_not_modified_total",
+  "The total number of pages the crawler found had not been modified.",
+)
+
+var crawlerPagesRemoved = newCounter(
+  "searcher_crawler_pages_removed_total",
+  "The total number of crawled pages removed from the index.",
+)
+
+var crawlerPagesFailed = newCounter(
+  "searcher_crawler_pages_failed_total",
+  "The total number of pages which could not be crawled.",
+)
+
+var indexerErrors = newCounter(
+  "searcher_indexer_errors_total",
+  "The total number of errors logged by the indexer.",
+)
+
+// Record the metrics of an (answered) search query. Only real searches
+// are counted, not views of the (empty) search form.
+//
+func observeSearchQuery(numResults int, aDuration time.Duration) {
+  queriesTotal.inc()
+  if numResults == 0 { zeroResultQueries.inc() }
+  queryResults.observe(float64(numResults))
+  queryDuration.observe(aDuration.Seconds())
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+  for _, aMetric := range allMetrics {
+    aMetric.writeMetric(w)
+  }
+}
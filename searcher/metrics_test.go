@@ -0,0 +1,72 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "net/http/httptest"
+)
+
+func TestMetricsExposition(t *testing.T) {
+  aCounter := &metricsCounter{ name : "test_total", help : "A test counter." }
+  aCounter.inc()
+  aCounter.add(2)
+  aHistogram := &metricsHistogram{
+    name : "test_seconds", help : "A test histogram.",
+    buckets : []float64{ 0.5, 1 }, counts : make([]uint64, 2),
+  }
+  aHistogram.observe(0.25)
+  aHistogram.observe(2)
+
+  var theText strings.Builder
+  aCounter.writeMetric(&theText)
+  aHistogram.writeMetric(&theText)
+  want := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total 3
+# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.5"} 1
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="+Inf"} 2
+test_seconds_sum 2.25
+test_seconds_count 2
+`
+  if theText.String() != want {
+    t.Errorf("got:\n%s\nwant:\n%s", theText.String(), want)
+  }
+}
+
+// Only real searches are counted, not views of the search form.
+//
+func TestQueryMetrics(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  aHandler := searchHandler(searchDB, CreateTemplate("../config/searchForm.html"))
+  someTests := []struct {
+    path          string
+    wantQueries   uint64
+    wantZeroHits  uint64
+  }{
+    { "/",                0, 0 },
+    { "/favicon.ico",     0, 0 },
+    { "/search/",         0, 0 },
+    { "/search/wombats",  1, 1 },
+  }
+  for _, aTest := range someTests {
+    numQueries  := queriesTotal.get()
+    numZeroHits := zeroResultQueries.get()
+    aHandler(httptest.NewRecorder(), httptest.NewRequest("GET", aTest.path, nil))
+    if got := queriesTotal.get() - numQueries; got != aTest.wantQueries {
+      t.Errorf("[%s] counted %d queries, want %d", aTest.path, got, aTest.wantQueries)
+    }
+    if got := zeroResultQueries.get() - numZeroHits; got != aTest.wantZeroHits {
+      t.Errorf("[%s] counted %d zero result queries, want %d",
+        aTest.path, got, aTest.wantZeroHits)
+    }
+  }
+
+  aRecorder := httptest.NewRecorder()
+  metricsHandler(aRecorder, httptest.NewRequest("GET", "/metrics", nil))
+  if !strings.Contains(aRecorder.Body.String(), "\nsearcher_queries_total ") {
+    t.Errorf("the queries counter is not exposed:\n%s", aRecorder.Body.String())
+  }
+}
@@ -0,0 +1,191 @@
+package main
+
+/*
+
+  We describe the searcher using an OpenSearch description document (see:
+  https://github.com/dewitt/opensearch), so that the searcher can be added
+  to a browser's search bar:
+
+    GET /opensearch.xml        : the OpenSearch description document
+
+    GET /search/<query>        : the (stable) search results url used by
+                                 the description document
+
+    GET /suggest?q=<prefix>    : OpenSearch suggestions (json) for the
+                                 titles which match the prefix
+
+  The description document uses the `OpenSearch.SiteName` and
+  `OpenSearch.Description`, as well as the favicon in
+  `OpenSearch.Favicon` (embedded as a data url).
+
+  The (absolute) urls in the description document are based upon
+  `OpenSearch.BaseUrl`, or (if that is empty) upon the host and scheme of
+  the request for the description document.
+
+*/
+
+import (
+  "fmt"
+  "strings"
+  "net/http"
+  "io/ioutil"
+  "database/sql"
+  "encoding/xml"
+  "encoding/base64"
+)
+
+type openSearchUrl struct {
+  Type     string `xml:"type,attr"`
+  Method   string `xml:"method,attr,omitempty"`
+  Rel      string `xml:"rel,attr,omitempty"`
+  Template string `xml:"template,attr"`
+}
+
+type openSearchImage struct {
+  Width  int    `xml:"width,attr"`
+  Height int    `xml:"height,attr"`
+  Type   string `xml:"type,attr"`
+  Url    string `xml:",chardata"`
+}
+
+type openSearchDescription struct {
+  XMLName       xml.Name         `xml:"OpenSearchDescription"`
+  Xmlns         string           `xml:"xmlns,attr"`
+  ShortName     string           `xml:"ShortName"`
+  Description   string           `xml:"Description"`
+  InputEncoding string           `xml:"InputEncoding"`
+  Image         *openSearchImage `xml:"Image,omitempty"`
+  Urls          []openSearchUrl  `xml:"Url"`
+}
+
+func getSiteName() string {
+  return getConfigStr("OpenSearch.SiteName", "Searcher")
+}
+
+// The (absolute) base url of the searcher, as seen by this request.
+//
+func openSearchBaseUrl(r *http.Request) string {
+  baseUrl := getConfigStr("OpenSearch.BaseUrl", "")
+  if baseUrl != "" { return strings.TrimSuffix(baseUrl, "/") }
+  scheme := "http"
+  if r.TLS != nil { scheme = "https" }
+  return scheme + "://" + r.Host
+}
+
+// The favicon (if any) as a data url.
+//
+func openSearchFavicon() *openSearchImage {
+  faviconPath := getConfigStr("OpenSearch.Favicon", "config/searcherFavicon.ico")
+  if faviconPath == "" { return nil }
+  faviconBytes, err := ioutil.ReadFile(faviconPath)
+  if err != nil {
+    WebserverMaybeError("could not read the OpenSearch favicon", err)
+    return nil
+  }
+  //
+  // an ico file's (first) image size is given in bytes 6 and 7 (where 0
+  // means 256)
+  //
+  width, height := 16, 16
+  if 8 <= len(faviconBytes) {
+    width, height = int(faviconBytes[6]), int(faviconBytes[7])
+    if width  == 0 { width  = 256 }
+    if height == 0 { height = 256 }
+  }
+  return &openSearchImage{
+    Width  : width,
+    Height : height,
+    Type   : "image/x-icon",
+    Url    : "data:image/x-icon;base64," +
+      base64.StdEncoding.EncodeToString(faviconBytes),
+  }
+}
+
+func openSearchHandler(w http.ResponseWriter, r *http.Request) {
+  baseUrl := openSearchBaseUrl(r)
+  theDescription := openSearchDescription{
+    Xmlns         : "http://a9.com/-/spec/opensearch/1.1/",
+    ShortName     : getSiteName(),
+    Description   : getConfigStr("OpenSearch.Description", "Search this site"),
+    InputEncoding : "UTF-8",
+    Image         : openSearchFavicon(),
+    Urls          : []openSearchUrl{
+      {
+        Type     : "text/html",
+        Method   : "get",
+        Template : baseUrl + "/search/{searchTerms}",
+      },
+      {
+        Type     : "application/x-suggestions+json",
+        Method   : "get",
+        Template : baseUrl + "/suggest?q={searchTerms}",
+      },
+      {
+        Type     : "application/opensearchdescription+xml",
+        Rel      : "self",
+        Template : baseUrl + "/opensearch.xml",
+      },
+    },
+  }
+  w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+  fmt.Fprint(w, xml.Header)
+  encoder := xml.NewEncoder(w)
+  encoder.Indent("", "  ")
+  WebserverMaybeError(
+    "could not encode the OpenSearch description", encoder.Encode(theDescription),
+  )
+}
+
+// Turn a (partially typed) query into an FTS5 prefix query on the titles.
+// Each word is quoted (so that it can not contain FTS5 syntax), and the
+// last word is treated as a prefix.
+//
+func suggestionQuery(aPrefix string) string {
+  someWords := strings.Fields(aPrefix)
+  if len(someWords) < 1 { return "" }
+  quotedWords := []string{}
+  for _, aWord := range someWords {
+    quotedWords = append(quotedWords, `"`+strings.Replace(aWord, `"`, `""`, -1)+`"`)
+  }
+  return "title : ( " + strings.Join(quotedWords, " ") + "* )"
+}
+
+func suggestHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    aPrefix     := r.URL.Query().Get("q")
+    suggestions := []string{}
+
+    if ftsQuery := suggestionQuery(aPrefix); ftsQuery != "" {
+      aclSql, aclArgs := aclCondition(
+        "documents.docPath", allowedPathPrefixes(getSearchUser(r)),
+      )
+      someArgs := append([]interface{}{ ftsQuery }, aclArgs...)
+      someArgs  = append(someArgs, getConfigInt("OpenSearch.MaxSuggestions", 10))
+      rows, err := searchDB.Query(`
+        select distinct documents.title from documentSearch
+          join documents on documents.docId = documentSearch.rowid
+          where documentSearch match ? and documents.title != '' and `+aclSql+`
+          order by documentSearch.rank
+          limit ?
+      `, someArgs...)
+      if err == nil {
+        for rows.Next() {
+          var aTitle string
+          if rows.Scan(&aTitle) == nil {
+            suggestions = append(suggestions, aTitle)
+          }
+        }
+        err = rows.Err()
+        rows.Close()
+      }
+      WebserverMaybeError("could not find suggestions", err)
+    }
+
+    writeJson(w, http.StatusOK, []interface{}{ aPrefix, suggestions })
+  }
+}
+
+func registerOpenSearchHandlers(mux *http.ServeMux, searchDB *sql.DB) {
+  mux.HandleFunc("/opensearch.xml", openSearchHandler)
+  mux.HandleFunc("/suggest", authHandler(suggestHandler(searchDB)))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+  "context"
+  "strings"
+  "testing"
+  "net/url"
+  "net/http/httptest"
+)
+
+func TestSuggestionQuery(t *testing.T) {
+  someTests := []struct {
+    aPrefix string
+    want    string
+  }{
+    { "",              `` },
+    { "   ",           `` },
+    { "qua",           `title : ( "qua"* )` },
+    { " quantum  gra", `title : ( "quantum" "gra"* )` },
+    { `say "hi`,       `title : ( "say" """hi"* )` },
+    { "a OR b",        `title : ( "a" "OR" "b"* )` },
+    { "title:x -y",    `title : ( "title:x" "-y"* )` },
+  }
+  for _, aTest := range someTests {
+    if got := suggestionQuery(aTest.aPrefix); got != aTest.want {
+      t.Errorf("suggestionQuery(%q) = %s, want %s", aTest.aPrefix, got, aTest.want)
+    }
+  }
+}
+
+func TestSuggestHandler(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  for _, aDoc := range []indexedDocument{
+    { path : "files/public/a.html",  title : "Quantum gravity",   source : fileSource },
+    { path : "files/public/b.html",  title : "Quantum mechanics", source : fileSource },
+    { path : "files/private/c.html", title : "Quantum secrets",   source : fileSource },
+    { path : "files/public/d.html",  title : "Classical \"gravity\"", source : fileSource },
+  } {
+    if err := upsertDocument(searchDB, aDoc); err != nil {
+      t.Fatalf("could not insert a document: %s", err)
+    }
+  }
+  setTestConfig(t,
+    `Auth.ACL={ "*" : [ "files/public/" ], "user:alice" : [ "files/" ] }`,
+  )
+  aHandler := suggestHandler(searchDB)
+
+  someTests := []struct {
+    name   string
+    query  string
+    user   *searchUser
+    want   string
+  }{
+    { "prefix",       "quan",  nil, `["quan",["Quantum gravity","Quantum mechanics"]]` },
+    { "allowed user", "quan",  &searchUser{ name : "alice" },
+      `["quan",["Quantum gravity","Quantum mechanics","Quantum secrets"]]` },
+    { "two words",    "quantum gr", nil, `["quantum gr",["Quantum gravity"]]` },
+    { "quotes",       `"grav`, nil,
+      `["\"grav",["Quantum gravity","Classical \"gravity\""]]` },
+    { "fts syntax",   "quan OR secr", nil, `["quan OR secr",[]]` },
+    { "no query",     "",      nil, `["",[]]` },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aRequest := httptest.NewRequest("GET", "/suggest", nil)
+      aRequest.URL.RawQuery = url.Values{ "q" : { aTest.query } }.Encode()
+      if aTest.user != nil {
+        aRequest = aRequest.WithContext(
+          context.WithValue(aRequest.Context(), searchUserKey{}, aTest.user),
+        )
+      }
+      numErrors := webserverErrors.get()
+      aRecorder := httptest.NewRecorder()
+      aHandler(aRecorder, aRequest)
+      got := strings.TrimSpace(aRecorder.Body.String())
+      if got != aTest.want { t.Errorf("got %s, want %s", got, aTest.want) }
+      if webserverErrors.get() != numErrors { t.Errorf("an error was logged") }
+    })
+  }
+}
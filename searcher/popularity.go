@@ -0,0 +1,270 @@
+package main
+
+/*
+
+  We count the clicks (through the /click endpoint) on each search result,
+  per document and (normalized) query, in the docClicks table of the
+  search database.
+
+  Each count is also kept as an exponentially decaying "popularity", which
+  halves every `Ranking.PopularityHalfLifeDays` days, so that documents
+  which were popular long ago gradually lose their boost. (The decay is
+  applied lazily, whenever a document is clicked or ranked.)
+
+  If `Ranking.PopularityWeight` is greater than zero, search results are
+  re-ranked by blending their (bm25) relevance with their popularity:
+
+    score = relevance + PopularityWeight * ln(1 + popularity)
+
+  where a document's popularity is the sum of its (decayed) clicks over
+  all queries, with clicks from the current query counted twice. (If
+  `Ranking.LinkWeight` is greater than zero, the results' link scores are
+  also blended in, see: links.go.)
+
+  If `Ranking.RecencyWeight` is greater than zero, newer documents (by
+  their modification times, which for the pages of a sitemap are their
+  lastmods, see: sitemap.go) are also boosted:
+
+    score = ... + RecencyWeight * 0.5^(age / RecencyHalfLifeDays)
+
+  To allow popular (well linked or recent) documents to move up, the best
+  `Ranking.Candidates` results (by relevance) are re-ranked.
+
+  Each re-ranked result is an sql variable (in the queries which find
+  their popularity and link scores), and SQLite limits the number of
+  variables in a query, so at most maxRankingCandidates results are ever
+  re-ranked.
+
+*/
+
+import (
+  "fmt"
+  "math"
+  "sort"
+  "time"
+  "strings"
+  "database/sql"
+  "github.com/tidwall/gjson"
+)
+
+const maxRankingCandidates = 1000
+
+func isRankingCandidates(aValue gjson.Result) error {
+  if aValue.Int() < 1 || maxRankingCandidates < aValue.Int() {
+    return fmt.Errorf("must be between 1 and %d", maxRankingCandidates)
+  }
+  return nil
+}
+
+// The number of (the most relevant) results to re-rank.
+//
+func rankingCandidates() int {
+  numCandidates := int(getConfigInt("Ranking.Candidates", 200))
+  if numCandidates < 1 { return 1 }
+  if maxRankingCandidates < numCandidates { return maxRankingCandidates }
+  return numCandidates
+}
+
+func popularityWeight() float64 {
+  return getConfigFloat("Ranking.PopularityWeight", 0)
+}
+
+// Should search result links go through the /click endpoint?
+//
+func isClickTrackingEnabled() bool {
+  return isAnalyticsEnabled() || 0 < popularityWeight()
+}
+
+// How much a popularity has decayed since it was last updated.
+//
+func popularityDecay(updatedAt int64, now int64) float64 {
+  halfLifeDays := getConfigFloat("Ranking.PopularityHalfLifeDays", 30)
+  if halfLifeDays <= 0 || now <= updatedAt { return 1 }
+  return math.Pow(0.5, float64(now-updatedAt) / (halfLifeDays * 24 * 60 * 60))
+}
+
+type documentClick struct {
+  docPath string
+  query   string
+}
+
+// Count a batch of (click) events, per document and (normalized) query,
+// in one transaction (on the search writer).
+//
+func recordDocumentClicks(searchDB *sql.DB, someEvents []analyticsEvent) error {
+  clickCounts := map[documentClick]int{}
+  for _, anEvent := range someEvents {
+    if anEvent.kind != clickEvent { continue }
+    aClick := documentClick{ anEvent.docPath, normalizeQuery(anEvent.query) }
+    clickCounts[aClick] = clickCounts[aClick] + 1
+  }
+  if len(clickCounts) < 1 { return nil }
+  now := time.Now().Unix()
+
+  transaction, err := searchDB.Begin()
+  if err != nil { return err }
+  for aClick, numClicks := range clickCounts {
+    var popularity float64
+    var updatedAt  int64
+    err = transaction.QueryRow(
+      "select popularity, updatedAt from docClicks where docPath = ? and query = ?",
+      aClick.docPath, aClick.query,
+    ).Scan(&popularity, &updatedAt)
+    if err != nil && err != sql.ErrNoRows {
+      transaction.Rollback()
+      return err
+    }
+    _, err = transaction.Exec(`
+      insert into docClicks ( docPath, query, clicks, popularity, updatedAt )
+        values ( ?, ?, ?, ?, ? )
+        on conflict ( docPath, query ) do update set
+          clicks     = clicks + excluded.clicks,
+          popularity = excluded.popularity,
+          updatedAt  = excluded.updatedAt
+    `, aClick.docPath, aClick.query, numClicks,
+      popularity * popularityDecay(updatedAt, now) + float64(numClicks), now)
+    if err != nil {
+      transaction.Rollback()
+      return err
+    }
+  }
+  return transaction.Commit()
+}
+
+// The (decayed) popularity of each of the documents for a query.
+//
+func documentPopularity(
+  searchDB *sql.DB, docPaths []string, aQuery string,
+) (map[string]float64, error) {
+  popularities := map[string]float64{}
+  if len(docPaths) < 1 { return popularities, nil }
+
+  someArgs := []interface{}{}
+  for _, aDocPath := range docPaths {
+    someArgs = append(someArgs, aDocPath)
+  }
+  rows, err := searchDB.Query(`
+    select docPath, query, popularity, updatedAt from docClicks
+      where docPath in ( ?`+strings.Repeat(", ?", len(docPaths)-1)+` )
+  `, someArgs...)
+  if err != nil { return nil, err }
+  defer rows.Close()
+
+  aQuery = normalizeQuery(aQuery)
+  now   := time.Now().Unix()
+  for rows.Next() {
+    var docPath    string
+    var clickQuery string
+    var popularity float64
+    var updatedAt  int64
+    if err = rows.Scan(&docPath, &clickQuery, &popularity, &updatedAt); err != nil {
+      return nil, err
+    }
+    decayedPopularity := popularity * popularityDecay(updatedAt, now)
+    popularities[docPath] = popularities[docPath] + decayedPopularity
+    if clickQuery == aQuery {
+      popularities[docPath] = popularities[docPath] + decayedPopularity
+    }
+  }
+  return popularities, rows.Err()
+}
+
+func recencyWeight() float64 {
+  return getConfigFloat("Ranking.RecencyWeight", 0)
+}
+
+// Are search results re-ranked (by their popularity, link scores or
+// recency)?
+//
+func isReranking() bool {
+  return 0 < popularityWeight() || 0 < linkWeight() || 0 < recencyWeight()
+}
+
+// Re-rank the search results (in place) by blending their relevance with
+// their popularity, their link score (see: links.go) and their recency.
+//
+func rerankResults(
+  searchDB *sql.DB, results []SearchResults, aQuery string,
+) {
+  if !isReranking() { return }
+  // (any further results, when more are asked for, keep their order)
+  if maxRankingCandidates < len(results) { results = results[:maxRankingCandidates] }
+  addPopularityBoost(searchDB, results, aQuery)
+  addLinkBoost(searchDB, results)
+  addRecencyBoost(searchDB, results)
+  sort.SliceStable(results, func(i, j int) bool {
+    return results[i].relevance > results[j].relevance
+  })
+}
+
+// Add the (weighted) popularity of each of the search results to its
+// relevance.
+//
+func addPopularityBoost(
+  searchDB *sql.DB, results []SearchResults, aQuery string,
+) {
+  weight := popularityWeight()
+  if weight <= 0 { return }
+
+  docPaths := []string{}
+  for _, aResult := range results {
+    docPaths = append(docPaths, aResult.docPath)
+  }
+  popularities, err := documentPopularity(searchDB, docPaths, aQuery)
+  if err != nil {
+    WebserverMaybeError("could not get the popularity of the results", err)
+    return
+  }
+  for i := range results {
+    results[i].relevance = results[i].relevance +
+      weight * math.Log1p(popularities[results[i].docPath])
+  }
+}
+
+// How much of the recency boost a document modified at mtime gets (1 for
+// a document modified now, halving every `Ranking.RecencyHalfLifeDays`).
+//
+func recencyDecay(mtime int64, now int64) float64 {
+  halfLifeDays := getConfigFloat("Ranking.RecencyHalfLifeDays", 365)
+  if now <= mtime { return 1 }
+  if halfLifeDays <= 0 { return 0 }
+  return math.Pow(0.5, float64(now-mtime) / (halfLifeDays * 24 * 60 * 60))
+}
+
+// Add the (weighted) recency of each of the search results to its
+// relevance.
+//
+func addRecencyBoost(searchDB *sql.DB, results []SearchResults) {
+  weight := recencyWeight()
+  if weight <= 0 || len(results) < 1 { return }
+
+  someArgs := []interface{}{}
+  for _, aResult := range results {
+    someArgs = append(someArgs, aResult.docPath)
+  }
+  rows, err := searchDB.Query(`
+    select docPath, mtime from documents
+      where mtime is not null
+        and docPath in ( ?`+strings.Repeat(", ?", len(results)-1)+` )
+  `, someArgs...)
+  if err != nil {
+    WebserverMaybeError("could not get the modification times of the results", err)
+    return
+  }
+  defer rows.Close()
+  now       := time.Now().Unix()
+  recencies := map[string]float64{}
+  for rows.Next() {
+    var docPath string
+    var mtime   int64
+    if err = rows.Scan(&docPath, &mtime); err != nil {
+      WebserverMaybeError("could not get the modification times of the results", err)
+      return
+    }
+    recencies[docPath] = recencyDecay(mtime, now)
+  }
+  for i := range results {
+    results[i].relevance = results[i].relevance +
+      weight * recencies[results[i].docPath]
+  }
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+  "fmt"
+  "math"
+  "time"
+  "strings"
+  "testing"
+)
+
+// However many results are asked for, re-ranking never runs out of sql
+// variables.
+//
+func TestRerankManyResults(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  setTestConfig(t, "Ranking.PopularityWeight=10", "Ranking.LinkWeight=1")
+  mustExec(t, searchDB, `insert into docClicks values ( 'push:500', 'foo', 3, 3, ? )`,
+    time.Now().Unix())
+
+  results := make([]SearchResults, 40000)
+  for i := range results {
+    results[i].docPath   = fmt.Sprintf("push:%d", i)
+    results[i].relevance = float64(len(results) - i) / 1000
+  }
+  numErrors := webserverErrors.get()
+  rerankResults(searchDB, results, "foo")
+  if got := webserverErrors.get() - numErrors; got != 0 {
+    t.Fatalf("re-ranking logged %d errors", got)
+  }
+  if results[0].docPath != "push:500" {
+    t.Errorf("the popular result was not moved up, got [%s]", results[0].docPath)
+  }
+  if results[maxRankingCandidates].docPath != fmt.Sprintf("push:%d", maxRankingCandidates) {
+    t.Errorf("a result after the candidates was moved, got [%s]",
+      results[maxRankingCandidates].docPath)
+  }
+}
+
+func TestRecencyDecay(t *testing.T) {
+  setTestConfig(t, "Ranking.RecencyHalfLifeDays=10")
+  now := time.Now().Unix()
+  someTests := []struct {
+    name  string
+    mtime int64
+    want  float64
+  }{
+    { "now",                now,                 1 },
+    { "in the future",      now + 1000,          1 },
+    { "one half life ago",  now - 10*24*60*60,   0.5 },
+    { "two half lives ago", now - 20*24*60*60,   0.25 },
+  }
+  for _, aTest := range someTests {
+    if got := recencyDecay(aTest.mtime, now); math.Abs(got - aTest.want) > 1e-9 {
+      t.Errorf("%s: got %g, want %g", aTest.name, got, aTest.want)
+    }
+  }
+}
+
+func TestRerankByRecency(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  now := time.Now().Unix()
+  someDocs := []indexedDocument{
+    { path: "files/old.html", title: "Old", mtime: now - 2*365*24*60*60,
+      body: "gravity", source: fileSource },
+    { path: "files/new.html", title: "New", mtime: now - 24*60*60,
+      body: "gravity", source: fileSource },
+    { path: "files/older.html", title: "Older", mtime: now - 5*365*24*60*60,
+      body: "gravity", source: fileSource },
+  }
+  for _, aDoc := range someDocs {
+    if err := upsertDocument(searchDB, aDoc); err != nil {
+      t.Fatalf("could not insert [%s]: %s", aDoc.path, err)
+    }
+  }
+  newResults := func() []SearchResults {
+    return []SearchResults{
+      { docPath: "files/old.html",   relevance: 1.2 },
+      { docPath: "files/older.html", relevance: 1.1 },
+      { docPath: "files/new.html",   relevance: 1.0 },
+    }
+  }
+  resultPaths := func(results []SearchResults) string {
+    somePaths := []string{}
+    for _, aResult := range results { somePaths = append(somePaths, aResult.docPath) }
+    return strings.Join(somePaths, " ")
+  }
+
+  // without a recency weight, the results keep their relevance order
+  results := newResults()
+  rerankResults(searchDB, results, "gravity")
+  if got := resultPaths(results); got != "files/old.html files/older.html files/new.html" {
+    t.Errorf("got [%s] without a recency weight", got)
+  }
+
+  setTestConfig(t, "Ranking.RecencyWeight=1", "Ranking.RecencyHalfLifeDays=365")
+  results = newResults()
+  rerankResults(searchDB, results, "gravity")
+  if got := resultPaths(results); got != "files/new.html files/old.html files/older.html" {
+    t.Errorf("got [%s] with a recency weight", got)
+  }
+}
@@ -0,0 +1,167 @@
+package main
+
+/*
+
+  The indexer, the webServer (and any backups) all use the same (search)
+  database concurrently. To stop long indexing transactions (and the
+  indexer's vacuum) from failing searches with "database is locked"
+  errors:
+
+    - the database uses `Database.JournalMode` (by default WAL, so that
+      readers never block the writer, nor the writer the readers),
+
+    - every connection waits up to `Database.BusyTimeoutMs` for a lock
+      before giving up,
+
+    - ALL writes (by the indexer, the ingestion API, click counting, ...)
+      go through the ONE writer connection (see: getSearchWriter), so the
+      searcher never competes with itself for the write lock,
+
+    - the webServer's searches use a pool of (at most
+      `Database.MaxReadConnections`) read-only connections.
+
+  If a search can not get a lock (or, when not using WAL, while the
+  indexer is vacuuming the database) the webServer responds with a 503
+  (and a Retry-After header) rather than an error page.
+
+  These settings are only read when the connections are opened (that is,
+  when the searcher starts).
+
+*/
+
+import (
+  "fmt"
+  "sync"
+  "strings"
+  "net/url"
+  "net/http"
+  "sync/atomic"
+  "database/sql"
+  "github.com/tidwall/gjson"
+  "github.com/mattn/go-sqlite3"
+)
+
+func isJournalMode(aValue gjson.Result) error {
+  switch strings.ToUpper(aValue.String()) {
+    case "WAL", "DELETE", "TRUNCATE", "PERSIST" : return nil
+  }
+  return fmt.Errorf("must be one of WAL, DELETE, TRUNCATE or PERSIST")
+}
+
+func isSynchronous(aValue gjson.Result) error {
+  switch strings.ToUpper(aValue.String()) {
+    case "OFF", "NORMAL", "FULL", "EXTRA" : return nil
+  }
+  return fmt.Errorf("must be one of OFF, NORMAL, FULL or EXTRA")
+}
+
+func getJournalMode() string {
+  return strings.ToUpper(getConfigStr("Database.JournalMode", "WAL"))
+}
+
+// The (SQLite) uri used to open the search database.
+//
+func searchDBUri(readOnly bool) string {
+  someParams := url.Values{}
+  someParams.Set("_busy_timeout", fmt.Sprintf("%d",
+    getConfigInt("Database.BusyTimeoutMs", 5000),
+  ))
+  if readOnly {
+    someParams.Set("mode", "ro")
+  } else {
+    someParams.Set("_journal_mode", getJournalMode())
+    someParams.Set("_synchronous", strings.ToUpper(
+      getConfigStr("Database.Synchronous", "NORMAL"),
+    ))
+    // take the write lock at the start of each transaction (rather than
+    // failing to upgrade a read lock part way through)
+    someParams.Set("_txlock", "immediate")
+  }
+  return "file:" + getConfigStr("DatabasePath", "") + "?" + someParams.Encode()
+}
+
+// Open the search database (read-only or read-write).
+//
+func openSearchDB(readOnly bool) (*sql.DB, error) {
+  return sql.Open(searchDBDriver, searchDBUri(readOnly))
+}
+
+// Open the webServer's pool of read-only connections.
+//
+func openSearchReaders() (*sql.DB, error) {
+  searchDB, err := openSearchDB(true)
+  if err != nil { return nil, err }
+  maxReaders := int(getConfigInt("Database.MaxReadConnections", 4))
+  searchDB.SetMaxOpenConns(maxReaders)
+  searchDB.SetMaxIdleConns(maxReaders)
+  return searchDB, nil
+}
+
+var searchWriter     *sql.DB
+var searchWriterErr  error
+var searchWriterOnce sync.Once
+
+// The (one) writer connection, shared by everything which changes the
+// search database.
+//
+func getSearchWriter() (*sql.DB, error) {
+  searchWriterOnce.Do(func() {
+    searchWriter, searchWriterErr = openSearchDB(false)
+    if searchWriterErr != nil { return }
+    searchWriter.SetMaxOpenConns(1)
+    searchWriter.SetMaxIdleConns(1)
+    searchWriter.SetConnMaxLifetime(0)
+    // open the connection now (so that the journal mode is set before
+    // any readers connect)
+    searchWriterErr = searchWriter.Ping()
+  })
+  return searchWriter, searchWriterErr
+}
+
+/////////////////////////////
+// Vacuuming
+
+var vacuumsInProgress int32
+
+// While vacuuming a database which does not use WAL, the readers are
+// blocked (for the duration of the vacuum).
+//
+func isVacuumBlockingReaders() bool {
+  return 0 < atomic.LoadInt32(&vacuumsInProgress) && getJournalMode() != "WAL"
+}
+
+// Vacuum the database (if at least `Database.VacuumAfterRemovals`
+// documents have just been removed).
+//
+func maybeVacuumSearchDB(searchDB *sql.DB, numRemoved int64) {
+  vacuumAfter := getConfigInt("Database.VacuumAfterRemovals", 1)
+  if vacuumAfter < 1 || numRemoved < vacuumAfter { return }
+
+  atomic.AddInt32(&vacuumsInProgress, 1)
+  defer atomic.AddInt32(&vacuumsInProgress, -1)
+
+  IndexerLog("vacuuming database....")
+  _, err := searchDB.Exec("vacuum;")
+  IndexerMaybeError("could not vacuum the database", err)
+  IndexerLog("finished vacuuming database.")
+}
+
+/////////////////////////////
+// Busy databases
+
+// Did this error occur because the database was locked?
+//
+func isDatabaseBusy(err error) bool {
+  if sqliteErr, isSQLiteErr := err.(sqlite3.Error); isSQLiteErr {
+    return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+  }
+  return false
+}
+
+func writeDatabaseBusy(w http.ResponseWriter) {
+  w.Header().Set("Retry-After", "5")
+  http.Error(w,
+    "the index is busy (being maintained), please try again shortly",
+    http.StatusServiceUnavailable,
+  )
+}
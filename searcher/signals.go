@@ -0,0 +1,82 @@
+package main
+
+/*
+
+  We handle the operating system signals sent to the searcher:
+
+    SIGINT, SIGTERM : orderly shutdown: the webServer stops accepting new
+                      requests and drains any in-flight searches, the
+                      indexer finishes its current transaction, and both
+                      close the database.
+
+    SIGHUP          : force a reload of the configuration file and any
+                      webServer templates and assets.
+
+    SIGUSR1         : trigger an immediate indexing pass.
+
+  The shutdown request is broadcast by closing a channel, so any number of
+  goroutines can wait upon it (or poll it using isShuttingDown).
+
+*/
+
+import (
+  "os"
+  "log"
+  "sync"
+  "syscall"
+  "os/signal"
+)
+
+var shutdownRequested  chan struct{} = make(chan struct{})
+var shutdownOnce       sync.Once
+var indexPassRequested chan struct{} = make(chan struct{}, 1)
+
+func requestShutdown() {
+  shutdownOnce.Do(func() {
+    log.Print("Searcher: shutdown requested")
+    close(shutdownRequested)
+  })
+}
+
+func isShuttingDown() bool {
+  select {
+    case <-shutdownRequested : return true
+    default                  : return false
+  }
+}
+
+// Request an immediate indexing pass. If a request is already pending
+// there is nothing more to do.
+//
+func requestIndexPass() {
+  select {
+    case indexPassRequested <- struct{}{} :
+    default                               :
+  }
+}
+
+func handleSignals() {
+  signals := make(chan os.Signal, 1)
+  signal.Notify(
+    signals,
+    syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1,
+  )
+
+  for aSignal := range signals {
+    switch aSignal {
+      case syscall.SIGINT, syscall.SIGTERM :
+        log.Printf("Searcher: received %s", aSignal)
+        requestShutdown()
+        // a second SIGINT/SIGTERM will use the default (immediate exit)
+        signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+      case syscall.SIGHUP :
+        log.Printf("Searcher: received %s: reloading configuration", aSignal)
+        reloadConfigFile()
+        reloadWebServerTemplates()
+        reloadWebServerAssets()
+      case syscall.SIGUSR1 :
+        log.Printf("Searcher: received %s: requesting an index pass", aSignal)
+        requestIndexPass()
+    }
+  }
+}
@@ -0,0 +1,372 @@
+package main
+
+/*
+
+  We read sitemaps (see: https://www.sitemaps.org/protocol.html), either
+  from a url or from a local file:
+
+    <urlset>
+      <url><loc>https://.../aPage.html</loc><lastmod>2021-11-02</lastmod></url>
+      ...
+    </urlset>
+
+  A sitemap index lists (the locations of) further sitemaps:
+
+    <sitemapindex>
+      <sitemap><loc>https://.../sitemap1.xml</loc></sitemap>
+      ...
+    </sitemapindex>
+
+  readSitemap follows any sitemap indexes (reading at most maxSitemaps
+  sitemaps) and returns every page listed (with its lastmod, if any).
+  Sitemaps whose location ends in `.gz` are decompressed.
+
+  An HtmlDirs entry may be a (local) sitemap (any path ending in `.xml` or
+  `.xml.gz`) rather than a directory, for example:
+
+    "HtmlDirs": [ "files/_site/sitemap.xml" ]
+
+  For such a "sitemap root":
+
+    - ONLY the pages listed in the sitemap are indexed (files in the
+      sitemap's directory which are not listed are removed from the
+      index),
+
+    - each page's file is found by appending the path of its url to the
+      sitemap's directory (using index.html for directories, and adding
+      .html to paths without an extension if need be),
+
+    - each page's url is its (sitemap) loc (rather than being derived from
+      the UrlBase),
+
+    - a page's lastmod (if any) is used as its modification time, so a
+      page is only reindexed when its lastmod changes (and it is used to
+      order feeds, newest first, and to rank recently modified pages
+      higher, see: popularity.go).
+
+  A sitemap index in a sitemap root may list its sitemaps by url, these
+  are read from the same directory (using the path of their urls). The
+  HtmlDirs can NOT be urls (remote sites are crawled, see: crawler.go).
+
+*/
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "path"
+  "sort"
+  "time"
+  "regexp"
+  "strings"
+  "net/url"
+  "net/http"
+  "path/filepath"
+  "database/sql"
+  "compress/gzip"
+  "encoding/xml"
+  "github.com/tidwall/gjson"
+)
+
+const maxSitemaps = 100
+
+type sitemapEntry struct {
+  url     string
+  lastMod time.Time
+}
+
+type sitemapXmlEntry struct {
+  Loc     string `xml:"loc"`
+  LastMod string `xml:"lastmod"`
+}
+
+// (both urlsets and sitemap indexes)
+//
+type sitemapXml struct {
+  Urls     []sitemapXmlEntry `xml:"url"`
+  Sitemaps []sitemapXmlEntry `xml:"sitemap"`
+}
+
+// Parse a sitemap's lastmod (a W3C datetime, which may be just a date).
+// Returns the zero time if there is no (valid) lastmod.
+//
+func parseSitemapTime(aStr string) time.Time {
+  aStr = strings.TrimSpace(aStr)
+  for _, aLayout := range []string{
+    time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02", "2006-01", "2006",
+  } {
+    if aTime, err := time.Parse(aLayout, aStr); err == nil { return aTime }
+  }
+  return time.Time{}
+}
+
+func isUrlLocation(location string) bool {
+  return strings.HasPrefix(location, "http://") ||
+    strings.HasPrefix(location, "https://")
+}
+
+// Open a sitemap (from a url or a file).
+//
+func openSitemap(client *http.Client, location string) (io.ReadCloser, error) {
+  var aReader io.ReadCloser
+  if isUrlLocation(location) {
+    request, err := http.NewRequest(http.MethodGet, location, nil)
+    if err != nil { return nil, err }
+    request.Header.Set("User-Agent", getCrawlerUserAgent())
+    response, err := client.Do(request)
+    if err != nil { return nil, err }
+    if response.StatusCode != http.StatusOK {
+      response.Body.Close()
+      return nil, fmt.Errorf("%s returned %s", location, response.Status)
+    }
+    aReader = response.Body
+  } else {
+    aFile, err := os.Open(location)
+    if err != nil { return nil, err }
+    aReader = aFile
+  }
+  if !strings.HasSuffix(location, ".gz") { return aReader, nil }
+  gzipReader, err := gzip.NewReader(aReader)
+  if err != nil {
+    aReader.Close()
+    return nil, err
+  }
+  return struct {
+    io.Reader
+    io.Closer
+  }{ gzipReader, aReader }, nil
+}
+
+// The location of a sitemap listed in a sitemap index (relative to the
+// index).
+//
+func sitemapLocation(indexLocation string, aLoc string) string {
+  aLoc = strings.TrimSpace(aLoc)
+  if isUrlLocation(indexLocation) {
+    if isUrlLocation(aLoc) { return aLoc }
+    indexUrl, err := url.Parse(indexLocation)
+    if err != nil { return aLoc }
+    locUrl, err := indexUrl.Parse(aLoc)
+    if err != nil { return aLoc }
+    return locUrl.String()
+  }
+  if locUrl, err := url.Parse(aLoc); err == nil && isUrlLocation(aLoc) {
+    // (a local sitemap index listing its sitemaps by url)
+    return filepath.Join(filepath.Dir(indexLocation), filepath.FromSlash(locUrl.Path))
+  }
+  if filepath.IsAbs(aLoc) { return aLoc }
+  return filepath.Join(filepath.Dir(indexLocation), aLoc)
+}
+
+// Read every page listed in a sitemap (following any sitemap indexes).
+//
+func readSitemap(client *http.Client, location string) ([]sitemapEntry, error) {
+  someEntries := []sitemapEntry{}
+  toRead      := []string{ location }
+  hasRead     := map[string]bool{}
+  for 0 < len(toRead) && len(hasRead) < maxSitemaps {
+    aLocation := toRead[0]
+    toRead     = toRead[1:]
+    if hasRead[aLocation] { continue }
+    hasRead[aLocation] = true
+
+    aReader, err := openSitemap(client, aLocation)
+    if err != nil { return someEntries, err }
+    var aSitemap sitemapXml
+    err = xml.NewDecoder(aReader).Decode(&aSitemap)
+    aReader.Close()
+    if err != nil {
+      return someEntries, fmt.Errorf("could not parse sitemap %s: %s", aLocation, err)
+    }
+    for _, anEntry := range aSitemap.Urls {
+      pageUrl := strings.TrimSpace(anEntry.Loc)
+      if len(pageUrl) < 1 { continue }
+      someEntries = append(someEntries, sitemapEntry{
+        url     : pageUrl,
+        lastMod : parseSitemapTime(anEntry.LastMod),
+      })
+    }
+    for _, aSitemapEntry := range aSitemap.Sitemaps {
+      if len(strings.TrimSpace(aSitemapEntry.Loc)) < 1 { continue }
+      toRead = append(toRead, sitemapLocation(aLocation, aSitemapEntry.Loc))
+    }
+  }
+  return someEntries, nil
+}
+
+/////////////////////////////
+// Sitemap roots
+
+// (a validator for the HtmlDirs)
+//
+func areLocalHtmlDirs(aValue gjson.Result) error {
+  for _, anHtmlDir := range aValue.Array() {
+    if isUrlLocation(anHtmlDir.String()) {
+      return fmt.Errorf(
+        "[%s] must be a local directory or sitemap (use Crawler.Seeds for remote sites)",
+        anHtmlDir.String(),
+      )
+    }
+  }
+  return nil
+}
+
+// Is this HtmlDirs entry a sitemap (rather than a directory)?
+//
+func isSitemapHtmlDir(anHtmlDir string) bool {
+  return strings.HasSuffix(anHtmlDir, ".xml") || strings.HasSuffix(anHtmlDir, ".xml.gz")
+}
+
+// The directory containing the files of an HtmlDirs entry.
+//
+func htmlDirRoot(anHtmlDir string) string {
+  if isSitemapHtmlDir(anHtmlDir) { return filepath.Dir(anHtmlDir) }
+  return anHtmlDir
+}
+
+// The file of a page given its (file) path: the path itself (or its
+// index.html, if it is a directory), or the path with .html added (if it
+// has no extension). Returns "" if there is no such file.
+//
+func findPageFile(filePath string, isDirectory bool) string {
+  if isDirectory { filePath = filepath.Join(filePath, "index.html") }
+  if fileInfo, err := os.Stat(filePath); err == nil {
+    if !fileInfo.IsDir() { return filePath }
+    filePath = filepath.Join(filePath, "index.html")
+  } else if filepath.Ext(filePath) == "" {
+    filePath = filePath + ".html"
+  }
+  if fileInfo, err := os.Stat(filePath); err == nil && !fileInfo.IsDir() {
+    return filePath
+  }
+  return ""
+}
+
+// The file (in a sitemap root's directory) of a page listed in its
+// sitemap, or "" if there is no such file.
+//
+func sitemapPageFile(siteRoot string, pageUrl string) string {
+  parsedUrl, err := url.Parse(pageUrl)
+  if err != nil { return "" }
+  return findPageFile(
+    filepath.Join(siteRoot, filepath.FromSlash(path.Clean("/"+parsedUrl.Path))),
+    strings.HasSuffix(parsedUrl.Path, "/"),
+  )
+}
+
+// The (files of the) pages listed in a sitemap root's sitemap.
+//
+func readSitemapPages(sitemapPath string) (map[string]sitemapEntry, error) {
+  if isUrlLocation(sitemapPath) {
+    return nil, fmt.Errorf("[%s] is not a local sitemap", sitemapPath)
+  }
+  someEntries, err := readSitemap(nil, sitemapPath)
+  if err != nil { return nil, err }
+  siteRoot    := filepath.Dir(sitemapPath)
+  sitemapPages := map[string]sitemapEntry{}
+  for _, anEntry := range someEntries {
+    filePath := sitemapPageFile(siteRoot, anEntry.url)
+    if len(filePath) < 1 {
+      IndexerLogf("SKIPPED: [%s] (no such file in %s)", anEntry.url, siteRoot)
+      continue
+    }
+    sitemapPages[filePath] = anEntry
+  }
+  return sitemapPages, nil
+}
+
+// The pages listed in all of the sitemap roots (and the directories of
+// those roots whose sitemaps could be read).
+//
+func findSitemapPages() (map[string]sitemapEntry, []string) {
+  sitemapPages := map[string]sitemapEntry{}
+  siteRoots    := []string{}
+  for _, anHtmlDir := range getConfigAStr("HtmlDirs", []string{ "files" }) {
+    if !isSitemapHtmlDir(anHtmlDir) { continue }
+    somePages, err := readSitemapPages(anHtmlDir)
+    if err != nil {
+      IndexerMaybeError("could not read sitemap "+anHtmlDir, err)
+      continue
+    }
+    for filePath, anEntry := range somePages { sitemapPages[filePath] = anEntry }
+    siteRoots = append(siteRoots, filepath.Dir(anHtmlDir))
+  }
+  return sitemapPages, siteRoots
+}
+
+func isInDirectory(aPath string, someDirs []string) bool {
+  cleanPath := filepath.Clean(aPath)
+  for _, aDir := range someDirs {
+    cleanDir := filepath.Clean(aDir)
+    if cleanPath == cleanDir ||
+      strings.HasPrefix(cleanPath, cleanDir+string(filepath.Separator)) {
+      return true
+    }
+  }
+  return false
+}
+
+// Remove any (indexed) files in a sitemap root's directory which are no
+// longer listed in its sitemap.
+//
+func removeUnlistedFiles(
+  searchDB *sql.DB, siteRoot string, sitemapPages map[string]sitemapEntry,
+) int64 {
+  dirPrefix := filepath.Clean(siteRoot)+string(filepath.Separator)
+  rows, err := searchDB.Query(`
+    select docPath from documents
+      where source = ? and substr(docPath, 1, ?) = ? ;
+  `, fileSource, len(dirPrefix), dirPrefix)
+  IndexerMaybeError("selecting the files in "+siteRoot, err)
+  if err != nil { return 0 }
+  filesToDelete := []string{}
+  for rows.Next() {
+    var aPath string
+    if err = rows.Scan(&aPath); err != nil { break }
+    if _, isListed := sitemapPages[aPath]; !isListed {
+      filesToDelete = append(filesToDelete, aPath)
+    }
+  }
+  IndexerMaybeError("selecting the files in "+siteRoot, rows.Err())
+  rows.Close()
+
+  numDeletions := int64(0)
+  for _, aPath := range filesToDelete {
+    if isShuttingDown() { break }
+    IndexerLogf("deleting (not in the sitemap): [%s]", aPath)
+    if err := removeFileFromIndex(searchDB, aPath); err != nil { break }
+    numDeletions = numDeletions + 1
+  }
+  return numDeletions
+}
+
+// Index (at most maxInsertions of) the new or changed pages listed in a
+// sitemap root's sitemap. Returns the number of pages (re)indexed.
+//
+func indexSitemap(
+  searchDB *sql.DB, sitemapPath string, excludedPaths []string,
+  titleRegexp *regexp.Regexp, maxInsertions int64,
+) int64 {
+  sitemapPages, err := readSitemapPages(sitemapPath)
+  if err != nil {
+    IndexerMaybeError("could not read sitemap "+sitemapPath, err)
+    return 0
+  }
+  numDeletions := removeUnlistedFiles(searchDB, filepath.Dir(sitemapPath), sitemapPages)
+  if !isShuttingDown() { maybeVacuumSearchDB(searchDB, numDeletions) }
+
+  somePaths := []string{}
+  for filePath := range sitemapPages { somePaths = append(somePaths, filePath) }
+  sort.Strings(somePaths)
+  numInsertions := int64(0)
+  for _, filePath := range somePaths {
+    if maxInsertions <= numInsertions { break }
+    if isShuttingDown() { break }
+    if isInDirectory(filePath, excludedPaths) { continue }
+    anEntry := sitemapPages[filePath]
+    if indexFile(searchDB, filePath, anEntry.url, anEntry.lastMod, titleRegexp, false) {
+      numInsertions = numInsertions + 1
+    }
+  }
+  return numInsertions
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+  "os"
+  "time"
+  "strings"
+  "testing"
+  "path/filepath"
+)
+
+func TestParseSitemapTime(t *testing.T) {
+  someTests := []struct {
+    aStr string
+    want time.Time
+  }{
+    { "2021-11-02T10:30:00Z",      time.Date(2021, 11, 2, 10, 30, 0, 0, time.UTC) },
+    { "2021-11-02T10:30:00+01:00", time.Date(2021, 11, 2, 9, 30, 0, 0, time.UTC) },
+    { "2021-11-02T10:30+01:00",    time.Date(2021, 11, 2, 9, 30, 0, 0, time.UTC) },
+    { " 2021-11-02 ",              time.Date(2021, 11, 2, 0, 0, 0, 0, time.UTC) },
+    { "2021-11",                   time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC) },
+    { "2021",                      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) },
+    { "",                          time.Time{} },
+    { "yesterday",                 time.Time{} },
+  }
+  for _, aTest := range someTests {
+    if got := parseSitemapTime(aTest.aStr); !got.Equal(aTest.want) {
+      t.Errorf("parseSitemapTime(%q) = %s, want %s", aTest.aStr, got, aTest.want)
+    }
+  }
+}
+
+func writeTestFiles(t *testing.T, aDir string, someFiles map[string]string) {
+  t.Helper()
+  for aPath, aContent := range someFiles {
+    filePath := filepath.Join(aDir, filepath.FromSlash(aPath))
+    if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(filePath, []byte(aContent), 0644); err != nil { t.Fatal(err) }
+  }
+}
+
+func TestSitemapPageFile(t *testing.T) {
+  siteRoot := t.TempDir()
+  writeTestFiles(t, siteRoot, map[string]string{
+    "index.html"           : "home",
+    "a.html"               : "a",
+    "about/index.html"     : "about",
+    "blog/post.html"       : "post",
+    "data.json"            : "{}",
+  })
+  someTests := []struct {
+    pageUrl string
+    want    string
+  }{
+    { "https://example.com/",            "index.html" },
+    { "https://example.com",             "index.html" },
+    { "https://example.com/a.html",      "a.html" },
+    { "https://example.com/about/",      "about/index.html" },
+    { "https://example.com/about",       "about/index.html" },
+    { "https://example.com/blog/post",   "blog/post.html" },
+    { "https://example.com/a.html?x=1",  "a.html" },
+    { "https://example.com/data.json",   "data.json" },
+    { "https://example.com/missing",     "" },
+    { "https://example.com/../../etc/passwd", "" },
+  }
+  for _, aTest := range someTests {
+    want := ""
+    if aTest.want != "" { want = filepath.Join(siteRoot, filepath.FromSlash(aTest.want)) }
+    if got := sitemapPageFile(siteRoot, aTest.pageUrl); got != want {
+      t.Errorf("sitemapPageFile(%q) = %q, want %q", aTest.pageUrl, got, want)
+    }
+  }
+}
+
+func TestReadSitemapPages(t *testing.T) {
+  siteRoot := t.TempDir()
+  writeTestFiles(t, siteRoot, map[string]string{
+    "sitemap.xml" : `<?xml version="1.0" encoding="UTF-8"?>
+      <sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+        <sitemap><loc>https://example.com/sitemaps/pages.xml</loc></sitemap>
+      </sitemapindex>`,
+    "sitemaps/pages.xml" : `<?xml version="1.0" encoding="UTF-8"?>
+      <urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+        <url><loc>https://example.com/</loc><lastmod>2021-11-02</lastmod></url>
+        <url><loc>https://example.com/a</loc></url>
+        <url><loc>https://example.com/missing.html</loc></url>
+      </urlset>`,
+    "index.html" : "home",
+    "a.html"     : "a",
+  })
+  sitemapPages, err := readSitemapPages(filepath.Join(siteRoot, "sitemap.xml"))
+  if err != nil { t.Fatalf("could not read the sitemap: %s", err) }
+  if len(sitemapPages) != 2 { t.Fatalf("got %d pages, want 2: %v", len(sitemapPages), sitemapPages) }
+  homePage := sitemapPages[filepath.Join(siteRoot, "index.html")]
+  if homePage.url != "https://example.com/" ||
+    !homePage.lastMod.Equal(time.Date(2021, 11, 2, 0, 0, 0, 0, time.UTC)) {
+    t.Errorf("got home page %+v", homePage)
+  }
+  aPage := sitemapPages[filepath.Join(siteRoot, "a.html")]
+  if aPage.url != "https://example.com/a" || !aPage.lastMod.IsZero() {
+    t.Errorf("got page %+v", aPage)
+  }
+}
+
+func TestUrlHtmlDirs(t *testing.T) {
+  someTests := []struct {
+    htmlDirs  string
+    wantError string
+  }{
+    { `[ "files", "files/_site/sitemap.xml" ]`, "" },
+    { `[ "http://127.0.0.1:1/sitemap.xml" ]`, "[http://127.0.0.1:1/sitemap.xml] must be a local" },
+    { `[ "files", "https://example.com/docs" ]`, "[https://example.com/docs] must be a local" },
+  }
+  for _, aTest := range someTests {
+    problems := validateConfig(`{ "HtmlDirs" : ` + aTest.htmlDirs + ` }`)
+    if aTest.wantError == "" {
+      if 0 < len(problems.errors) { t.Errorf("%s: unexpected errors %q", aTest.htmlDirs, problems.errors) }
+      continue
+    }
+    if len(problems.errors) != 1 || !strings.Contains(problems.errors[0], aTest.wantError) {
+      t.Errorf("%s: got errors %q, want [%s]", aTest.htmlDirs, problems.errors, aTest.wantError)
+    }
+  }
+
+  // (even if the configuration was not checked, a url is never fetched)
+  if _, err := readSitemapPages("http://127.0.0.1:1/sitemap.xml"); err == nil {
+    t.Errorf("read the pages of a remote sitemap root")
+  }
+}
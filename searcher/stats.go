@@ -0,0 +1,379 @@
+package main
+
+/*
+
+  We report what has been indexed:
+
+    GET /admin/stats[?terms=<n>]              : the statistics (as json)
+    GET /admin/stats?format=html[&terms=<n>]  : the statistics (as a page)
+
+    searcher -c <configFile> stats
+
+  The statistics are:
+
+    - the number of documents (in total, by HtmlDirs root, by type and
+      the number of pushed documents and crawled pages),
+
+    - the size of the database (and its WAL file),
+
+    - the size of the documentSearch index's vocabulary, and its most
+      common terms (using an fts5vocab table),
+
+    - the oldest and newest documents (by their modification times),
+
+    - the timing, and the number of files added, updated, removed,
+      skipped (not indexable) and failed, of the last index pass.
+
+  Since the statistics include document paths, they are only available
+  using the admin API.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "sort"
+  "context"
+  "strconv"
+  "strings"
+  "net/http"
+  "database/sql"
+  "html/template"
+)
+
+type statsCount struct {
+  Name  string `json:"name"`
+  Count int64  `json:"count"`
+}
+
+type statsTerm struct {
+  Term        string `json:"term"`
+  Documents   int64  `json:"documents"`
+  Occurrences int64  `json:"occurrences"`
+}
+
+type statsDocument struct {
+  Path     string    `json:"path"`
+  Title    string    `json:"title"`
+  Modified time.Time `json:"modified"`
+}
+
+type statsIndexPass struct {
+  Start        time.Time `json:"start"`
+  End          time.Time `json:"end"`
+  Duration     string    `json:"duration"`
+  FilesAdded   int64     `json:"filesAdded"`
+  FilesUpdated int64     `json:"filesUpdated"`
+  FilesRemoved int64     `json:"filesRemoved"`
+  FilesSkipped int64     `json:"filesSkipped"`
+  FilesFailed  int64     `json:"filesFailed"`
+}
+
+type indexStats struct {
+  Documents      int64           `json:"documents"`
+  PushedDocs     int64           `json:"pushedDocuments"`
+  CrawledDocs    int64           `json:"crawledDocuments"`
+  Roots          []statsCount    `json:"roots"`
+  Types          []statsCount    `json:"types"`
+  DatabaseBytes  int64           `json:"databaseBytes"`
+  WalBytes       int64           `json:"walBytes"`
+  VocabularySize int64           `json:"vocabularySize"`
+  TopTerms       []statsTerm     `json:"topTerms"`
+  Oldest         []statsDocument `json:"oldest"`
+  Newest         []statsDocument `json:"newest"`
+  LastPass       *statsIndexPass `json:"lastPass"`
+}
+
+// Count the documents by HtmlDirs root and by type.
+//
+func countDocuments(searchDB *sql.DB, theStats *indexStats) error {
+  rows, err := searchDB.Query("select docPath, source, docType from documents")
+  if err != nil { return err }
+  defer rows.Close()
+
+  htmlDirs   := getConfigAStr("HtmlDirs", []string{ "files" })
+  rootCounts := map[string]int64{}
+  typeCounts := map[string]int64{}
+  for rows.Next() {
+    var docPath, source string
+    var docType sql.NullString
+    if err = rows.Scan(&docPath, &source, &docType); err != nil { return err }
+    theStats.Documents = theStats.Documents + 1
+    if source == pushSource {
+      theStats.PushedDocs = theStats.PushedDocs + 1
+      typeCounts[docType.String] = typeCounts[docType.String] + 1
+      continue
+    }
+    if source == crawlSource {
+      theStats.CrawledDocs = theStats.CrawledDocs + 1
+      typeCounts[docType.String] = typeCounts[docType.String] + 1
+      continue
+    }
+    typeCounts[fileResultType(docPath)] = typeCounts[fileResultType(docPath)] + 1
+    aRoot := "(other)"
+    for _, anHtmlDir := range htmlDirs {
+      dirPrefix := strings.TrimSuffix(htmlDirRoot(anHtmlDir), "/") + "/"
+      if strings.HasPrefix(docPath, dirPrefix) {
+        aRoot = anHtmlDir
+        break
+      }
+    }
+    rootCounts[aRoot] = rootCounts[aRoot] + 1
+  }
+  if err = rows.Err(); err != nil { return err }
+
+  for _, anHtmlDir := range htmlDirs {
+    theStats.Roots = append(theStats.Roots, statsCount{ anHtmlDir, rootCounts[anHtmlDir] })
+  }
+  if 0 < rootCounts["(other)"] {
+    theStats.Roots = append(theStats.Roots, statsCount{ "(other)", rootCounts["(other)"] })
+  }
+  for aType, aCount := range typeCounts {
+    if strings.TrimSpace(aType) == "" { aType = "(none)" }
+    theStats.Types = append(theStats.Types, statsCount{ aType, aCount })
+  }
+  sort.Slice(theStats.Types, func(i, j int) bool {
+    return theStats.Types[i].Name < theStats.Types[j].Name
+  })
+  return nil
+}
+
+// Find the size of the index's vocabulary and its most common terms (an
+// fts5vocab table only exists on the connection which created it).
+//
+func findVocabulary(searchDB *sql.DB, numTerms int, theStats *indexStats) error {
+  aConn, err := searchDB.Conn(context.Background())
+  if err != nil { return err }
+  defer aConn.Close()
+  ctx := context.Background()
+
+  _, err = aConn.ExecContext(ctx, `
+    create virtual table if not exists temp.documentVocab
+      using fts5vocab(main, documentSearch, row);
+  `)
+  if err != nil { return err }
+
+  err = aConn.QueryRowContext(ctx,
+    "select count(*) from temp.documentVocab",
+  ).Scan(&theStats.VocabularySize)
+  if err != nil { return err }
+
+  rows, err := aConn.QueryContext(ctx, `
+    select term, doc, cnt from temp.documentVocab order by doc desc limit ?
+  `, numTerms)
+  if err != nil { return err }
+  defer rows.Close()
+  for rows.Next() {
+    var aTerm statsTerm
+    if err = rows.Scan(&aTerm.Term, &aTerm.Documents, &aTerm.Occurrences); err != nil {
+      return err
+    }
+    theStats.TopTerms = append(theStats.TopTerms, aTerm)
+  }
+  return rows.Err()
+}
+
+func findDocumentsByMTime(
+  searchDB *sql.DB, order string, numDocs int,
+) ([]statsDocument, error) {
+  rows, err := searchDB.Query(`
+    select docPath, title, mtime from documents
+      where mtime is not null
+      order by mtime `+order+`
+      limit ?
+  `, numDocs)
+  if err != nil { return nil, err }
+  defer rows.Close()
+  someDocs := []statsDocument{}
+  for rows.Next() {
+    var aDoc  statsDocument
+    var title sql.NullString
+    var mtime int64
+    if err = rows.Scan(&aDoc.Path, &title, &mtime); err != nil { return nil, err }
+    aDoc.Title    = title.String
+    aDoc.Modified = time.Unix(mtime, 0).UTC()
+    someDocs = append(someDocs, aDoc)
+  }
+  return someDocs, rows.Err()
+}
+
+func findLastIndexPass(searchDB *sql.DB) (*statsIndexPass, error) {
+  var passStart, passEnd int64
+  var someCounts [5]sql.NullInt64
+  err := searchDB.QueryRow(`
+    select passStart, passEnd,
+        filesAdded, filesUpdated, filesRemoved, filesSkipped, filesFailed
+      from indexPasses order by rowid desc limit 1
+  `).Scan(
+    &passStart, &passEnd, &someCounts[0], &someCounts[1], &someCounts[2],
+    &someCounts[3], &someCounts[4],
+  )
+  if err == sql.ErrNoRows { return nil, nil }
+  if err != nil { return nil, err }
+  return &statsIndexPass{
+    Start        : time.Unix(passStart, 0).UTC(),
+    End          : time.Unix(passEnd, 0).UTC(),
+    Duration     : (time.Duration(passEnd - passStart) * time.Second).String(),
+    FilesAdded   : someCounts[0].Int64,
+    FilesUpdated : someCounts[1].Int64,
+    FilesRemoved : someCounts[2].Int64,
+    FilesSkipped : someCounts[3].Int64,
+    FilesFailed  : someCounts[4].Int64,
+  }, nil
+}
+
+func getIndexStats(searchDB *sql.DB, numTerms int) (indexStats, error) {
+  theStats := indexStats{
+    Roots    : []statsCount{},
+    Types    : []statsCount{},
+    TopTerms : []statsTerm{},
+  }
+  if err := countDocuments(searchDB, &theStats); err != nil {
+    return theStats, fmt.Errorf("could not count the documents: %s", err)
+  }
+
+  databasePath := getConfigStr("DatabasePath", "")
+  if dbFileInfo, err := os.Stat(databasePath); err == nil {
+    theStats.DatabaseBytes = dbFileInfo.Size()
+  }
+  if walFileInfo, err := os.Stat(databasePath+"-wal"); err == nil {
+    theStats.WalBytes = walFileInfo.Size()
+  }
+
+  if err := findVocabulary(searchDB, numTerms, &theStats); err != nil {
+    return theStats, fmt.Errorf("could not read the vocabulary: %s", err)
+  }
+
+  var err error
+  if theStats.Oldest, err = findDocumentsByMTime(searchDB, "asc", 5); err != nil {
+    return theStats, fmt.Errorf("could not find the oldest documents: %s", err)
+  }
+  if theStats.Newest, err = findDocumentsByMTime(searchDB, "desc", 5); err != nil {
+    return theStats, fmt.Errorf("could not find the newest documents: %s", err)
+  }
+  if theStats.LastPass, err = findLastIndexPass(searchDB); err != nil {
+    return theStats, fmt.Errorf("could not find the last index pass: %s", err)
+  }
+  return theStats, nil
+}
+
+var statsTemplate = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.SiteName}}: index statistics</title>
+</head>
+<body>
+  <h1>{{.SiteName}}: index statistics</h1>
+  {{with .Stats}}
+  <p>{{.Documents}} documents ({{.PushedDocs}} pushed, {{.CrawledDocs}} crawled), database {{.DatabaseBytes}} bytes
+    (WAL {{.WalBytes}} bytes), vocabulary {{.VocabularySize}} terms</p>
+  <h2>By root</h2>
+  <table>{{range .Roots}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+  <h2>By type</h2>
+  <table>{{range .Types}}<tr><td>{{.Name}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+  <h2>Most common terms</h2>
+  <table>
+    <tr><th>term</th><th>documents</th><th>occurrences</th></tr>
+    {{range .TopTerms}}<tr><td>{{.Term}}</td><td>{{.Documents}}</td><td>{{.Occurrences}}</td></tr>{{end}}
+  </table>
+  <h2>Oldest documents</h2>
+  <table>{{range .Oldest}}<tr><td>{{.Modified}}</td><td>{{.Path}}</td><td>{{.Title}}</td></tr>{{end}}</table>
+  <h2>Newest documents</h2>
+  <table>{{range .Newest}}<tr><td>{{.Modified}}</td><td>{{.Path}}</td><td>{{.Title}}</td></tr>{{end}}</table>
+  <h2>Last index pass</h2>
+  {{with .LastPass}}
+  <p>started {{.Start}}, took {{.Duration}}: {{.FilesAdded}} added, {{.FilesUpdated}} updated,
+    {{.FilesRemoved}} removed, {{.FilesSkipped}} skipped, {{.FilesFailed}} failed</p>
+  {{else}}
+  <p>no index pass has completed</p>
+  {{end}}
+  {{end}}
+</body>
+</html>
+`))
+
+func adminStatsHandler(searchDB *sql.DB) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    numTerms, err := strconv.Atoi(r.URL.Query().Get("terms"))
+    if err != nil || numTerms < 1 { numTerms = 20 }
+    theStats, err := getIndexStats(searchDB, numTerms)
+    if isDatabaseBusy(err) {
+      writeDatabaseBusy(w)
+      return
+    }
+    if err != nil {
+      WebserverMaybeError("could not collect the index statistics", err)
+      writeJsonMessage(w, http.StatusInternalServerError, err.Error())
+      return
+    }
+    if r.URL.Query().Get("format") != "html" {
+      writeJson(w, http.StatusOK, theStats)
+      return
+    }
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    err = statsTemplate.Execute(w, map[string]interface{}{
+      "SiteName" : getSiteName(),
+      "Stats"    : theStats,
+    })
+    WebserverMaybeError("could not render the index statistics", err)
+  }
+}
+
+// The `stats` command.
+//
+func statsCommand() int {
+  initDatabaseStructure()
+  searchDB, err := openSearchDB(true)
+  if err != nil {
+    fmt.Printf("ERROR: could not open the database: %s\n", err)
+    return 1
+  }
+  defer searchDB.Close()
+
+  theStats, err := getIndexStats(searchDB, 20)
+  if err != nil {
+    fmt.Printf("ERROR: %s\n", err)
+    return 1
+  }
+  fmt.Printf("documents: %d (%d pushed, %d crawled)\n",
+    theStats.Documents, theStats.PushedDocs, theStats.CrawledDocs)
+  fmt.Printf("database:  %d bytes (WAL %d bytes)\n", theStats.DatabaseBytes, theStats.WalBytes)
+  fmt.Printf("vocabulary: %d terms\n", theStats.VocabularySize)
+  fmt.Println("by root:")
+  for _, aCount := range theStats.Roots {
+    fmt.Printf("  %8d  %s\n", aCount.Count, aCount.Name)
+  }
+  fmt.Println("by type:")
+  for _, aCount := range theStats.Types {
+    fmt.Printf("  %8d  %s\n", aCount.Count, aCount.Name)
+  }
+  fmt.Println("most common terms (documents, occurrences):")
+  for _, aTerm := range theStats.TopTerms {
+    fmt.Printf("  %8d  %8d  %s\n", aTerm.Documents, aTerm.Occurrences, aTerm.Term)
+  }
+  fmt.Println("oldest documents:")
+  for _, aDoc := range theStats.Oldest {
+    fmt.Printf("  %s  %s\n", aDoc.Modified.Format(time.RFC3339), aDoc.Path)
+  }
+  fmt.Println("newest documents:")
+  for _, aDoc := range theStats.Newest {
+    fmt.Printf("  %s  %s\n", aDoc.Modified.Format(time.RFC3339), aDoc.Path)
+  }
+  if aPass := theStats.LastPass; aPass != nil {
+    fmt.Printf(
+      "last index pass: started %s, took %s: %d added, %d updated, %d removed, %d skipped, %d failed\n",
+      aPass.Start.Format(time.RFC3339), aPass.Duration, aPass.FilesAdded,
+      aPass.FilesUpdated, aPass.FilesRemoved, aPass.FilesSkipped, aPass.FilesFailed,
+    )
+  } else {
+    fmt.Println("last index pass: none")
+  }
+  return 0
+}
+
+func registerStatsHandlers(mux *http.ServeMux, searchDB *sql.DB) {
+  mux.HandleFunc("/admin/stats",
+    adminHandler(http.MethodGet, adminStatsHandler(searchDB)))
+}
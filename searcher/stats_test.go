@@ -0,0 +1,100 @@
+package main
+
+import (
+  "strconv"
+  "strings"
+  "testing"
+  "encoding/json"
+  "net/http/httptest"
+)
+
+func TestIndexStats(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  setTestConfig(t, `HtmlDirs=["files/blog", "files/tasks"]`)
+
+  someDocs := []indexedDocument{
+    { path: "files/blog/a.html", title: "A", mtime: 3000,
+      body: "gravity gravity badgers", source: fileSource },
+    { path: "files/blog/b.html", title: "B", mtime: 1000,
+      body: "gravity", source: fileSource },
+    { path: "files/tasks/c.html", title: "C", mtime: 2000,
+      body: "gravity badgers", source: fileSource },
+    { path: "other/d.html", title: "D", mtime: 4000,
+      body: "gravel", source: fileSource },
+    { path: "push:e", title: "E", kind: "memo", mtime: 5000,
+      body: "gravity", source: pushSource },
+    { path: "https://example.com/f", url: "https://example.com/f", title: "F",
+      kind: "html", mtime: 6000, body: "badgers", source: crawlSource },
+  }
+  for _, aDoc := range someDocs {
+    if err := upsertDocument(searchDB, aDoc); err != nil {
+      t.Fatalf("could not insert [%s]: %s", aDoc.path, err)
+    }
+  }
+  mustExec(t, searchDB, `insert into indexPasses values ( 100, 160, 1, 2, 3, 4, 5 )`)
+  mustExec(t, searchDB, `insert into indexPasses values ( 200, 230, 6, 7, 8, 9, 10 )`)
+
+  theStats, err := getIndexStats(searchDB, 2)
+  if err != nil { t.Fatalf("could not get the statistics: %s", err) }
+
+  if theStats.Documents != 6 || theStats.PushedDocs != 1 || theStats.CrawledDocs != 1 {
+    t.Errorf("got %d documents (%d pushed, %d crawled), want 6 (1 pushed, 1 crawled)",
+      theStats.Documents, theStats.PushedDocs, theStats.CrawledDocs)
+  }
+  checkCounts := func(aName string, got []statsCount, want string) {
+    t.Helper()
+    someCounts := []string{}
+    for _, aCount := range got {
+      someCounts = append(someCounts, aCount.Name+"="+strconv.FormatInt(aCount.Count, 10))
+    }
+    if strings.Join(someCounts, " ") != want {
+      t.Errorf("got %s [%s], want [%s]", aName, strings.Join(someCounts, " "), want)
+    }
+  }
+  checkCounts("roots", theStats.Roots, "files/blog=2 files/tasks=1 (other)=1")
+  checkCounts("types", theStats.Types, "(none)=1 B=2 T=1 html=1 memo=1")
+
+  // gravity, badgers and gravel (as well as the six titles)
+  if theStats.VocabularySize != 9 {
+    t.Errorf("got a vocabulary of %d terms, want 9", theStats.VocabularySize)
+  }
+  if len(theStats.TopTerms) != 2 ||
+     theStats.TopTerms[0] != (statsTerm{ "gravity", 4, 5 }) ||
+     theStats.TopTerms[1] != (statsTerm{ "badgers", 3, 3 }) {
+    t.Errorf("got top terms %+v", theStats.TopTerms)
+  }
+
+  if len(theStats.Oldest) != 5 || theStats.Oldest[0].Path != "files/blog/b.html" {
+    t.Errorf("got oldest documents %+v", theStats.Oldest)
+  }
+  if len(theStats.Newest) != 5 || theStats.Newest[0].Path != "https://example.com/f" {
+    t.Errorf("got newest documents %+v", theStats.Newest)
+  }
+
+  wantPass := statsIndexPass{
+    Duration : "30s", FilesAdded : 6, FilesUpdated : 7, FilesRemoved : 8,
+    FilesSkipped : 9, FilesFailed : 10,
+  }
+  if theStats.LastPass == nil {
+    t.Fatal("got no last index pass")
+  }
+  gotPass := *theStats.LastPass
+  if gotPass.Start.Unix() != 200 || gotPass.End.Unix() != 230 {
+    t.Errorf("got last pass from %s to %s", gotPass.Start, gotPass.End)
+  }
+  gotPass.Start = wantPass.Start
+  gotPass.End   = wantPass.End
+  if gotPass != wantPass { t.Errorf("got last pass %+v, want %+v", gotPass, wantPass) }
+
+  // the admin handler reports the same statistics
+  aRecorder := httptest.NewRecorder()
+  adminStatsHandler(searchDB)(aRecorder, httptest.NewRequest("GET", "/admin/stats?terms=1", nil))
+  var gotStats indexStats
+  if err := json.NewDecoder(aRecorder.Body).Decode(&gotStats); err != nil {
+    t.Fatalf("could not decode the statistics: %s", err)
+  }
+  if gotStats.Documents != 6 || len(gotStats.TopTerms) != 1 {
+    t.Errorf("got %d documents and %d terms from the handler, want 6 and 1",
+      gotStats.Documents, len(gotStats.TopTerms))
+  }
+}
@@ -5,8 +5,10 @@ package main
 import (
   "os"
   "log"
+  "time"
   "strings"
   "strconv"
+  "context"
   "net/url"
   "net/http"
   "database/sql"
@@ -21,6 +23,7 @@ func WebserverMaybeFatal(logMessage string, err error) {
 
 func WebserverMaybeError(logMessage string, err error) {
   if err != nil {
+    webserverErrors.inc()
     log.Printf("Webserver(error): %s error: %s",logMessage, err)
   }
 }
@@ -34,86 +37,280 @@ func WebserverLogf(logFormat string, v ...interface{}) {
 }
 
 type SearchResults struct {
-  FilePath string
-  Title    string
-  Type     string
-  Rank     string
+  FilePath  string
+  Link      string
+  Title     string
+  Type      string
+  Rank      string
+  docPath   string
+  relevance float64
 }
 
 type SearchData struct {
+  SiteName    string
+  Stylesheets []string
+  Scripts     []string
   Query       string
   MaxNum      int
   MaxNumRange []int
   Results     []SearchResults
 }
 
-func runWebServer(cliHost string, cliPort int64) {
-
-  htmlDirs := getConfigAStr("HtmlDirs", []string{ "files" })
-  urlBase  := getConfigStr("UrlBase", "")
-  host     := getConfigStr("Host", "")
-  if cliHost == "" {
-    if host == "" {
-  		host = "0.0.0.0"
-  	}
-  } else {
-  	host = cliHost
+// The address on which the webServer should listen
+//
+func listenAddress(aConfig *configSnapshot) string {
+  host := aConfig.Host
+  if host == "" {
+    host = "0.0.0.0"
   }
-  portInt     := getConfigInt("Port", 0)
- 	if cliPort == 0 {
-    if portInt == 0 {
-  		portInt = 9090
-  	}
-  } else {
-  	portInt = cliPort
+  portInt := aConfig.Port
+  if portInt == 0 {
+    portInt = 9090
   }
-  port := strconv.FormatInt(portInt, 10)
+  return host+":"+strconv.FormatInt(portInt, 10)
+}
+
+// The url of an (indexed) file, found by replacing its HtmlDir by the
+// UrlBase.
+//
+func fileUrl(filePath string, htmlDirs []string, urlBase string) string {
+  theUrl := filePath
+  for _, anHtmlDir := range htmlDirs {
+    if strings.HasPrefix(filePath, anHtmlDir) {
+      theUrl = strings.Replace(filePath, anHtmlDir, urlBase, 1)
+    }
+  }
+  return theUrl
+}
+
+func shutdownServers(servers []*http.Server) {
+  for _, aServer := range servers {
+    shutdownServer(aServer)
+  }
+}
 
-  searchForm := CreateTemplate(
-    getConfigStr("Webserver.SearchForm", "config/searchForm.html"),
+// Stop accepting new requests and give any in-flight requests a limited
+// time to complete.
+//
+func shutdownServer(server *http.Server) {
+  shutdownSeconds := getConfigInt("Webserver.ShutdownSeconds", 10)
+  WebserverLogf("shutting down [%s] (waiting at most %d seconds)",
+    server.Addr, shutdownSeconds,
+  )
+  ctx, cancel := context.WithTimeout(
+    context.Background(), time.Duration(shutdownSeconds) * time.Second,
   )
+  defer cancel()
+  err := server.Shutdown(ctx)
+  WebserverMaybeError("could not drain in-flight requests", err)
+}
+
+// The (display) type of an indexed file (pushed documents provide their
+// own type).
+//
+func fileResultType(filePath string) string {
+  switch {
+    case strings.Contains(filePath, "blog")   : return "B"
+    case strings.Contains(filePath, "author") : return "A"
+    case strings.Contains(filePath, "cite")   : return "C"
+    case strings.Contains(filePath, "tasks")  : return "T"
+  }
+  return " "
+}
+
+// Limit the number of results a searcher asks for to [1, maxNum] (an
+// unlimited number would exhaust the memory, and SQLite's variables, used
+// to rank the results).
+//
+func clampNumResults(aNum int, maxNum int) int {
+  if aNum < 1      { return 1 }
+  if maxNum < aNum { return maxNum }
+  return aNum
+}
+
+// The numbers of results a searcher may choose between.
+//
+func numResultsRange(maxNum int) []int {
+  someNums := []int{}
+  for _, aNum := range []int{10, 50, 100, 200} {
+    if aNum < maxNum { someNums = append(someNums, aNum) }
+  }
+  return append(someNums, maxNum)
+}
 
-  searchDB, err := sql.Open("sqlite3", getConfigStr("DatabasePath", ""))
+func runWebServer() {
+
+  searchForm := CreateTemplate(getConfigSnapshot().SearchForm)
+
+  // searches use a pool of read-only connections, while any changes go
+  // through the (shared) writer connection
+  //
+  searchDB, err := openSearchReaders()
   WebserverMaybeFatal("trying to open the database", err)
   defer searchDB.Close()
+  writerDB, err := getSearchWriter()
+  WebserverMaybeFatal("trying to open the database for writing", err)
 
-  http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
-    // do not do anything!
+  // React to configuration changes: a new search form template is loaded,
+  // and a new Host, Port, TLS or timeout configuration rebinds the
+  // listener.
+  //
+  rebindRequested := make(chan struct{}, 1)
+  subscribeToConfig("webServer", func(oldConfig, newConfig *configSnapshot) {
+    if oldConfig.SearchForm != newConfig.SearchForm {
+      searchForm.changeFilePath(newConfig.SearchForm)
+    }
+    if listenerConfig(oldConfig) != listenerConfig(newConfig) {
+      select {
+        case rebindRequested <- struct{}{} :
+        default                            :
+      }
+    }
   })
 
-  http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+  mux := http.NewServeMux()
+
+  registerAssetHandlers(mux)
+
+  mux.HandleFunc("/healthz", healthzHandler)
+  mux.HandleFunc("/readyz",  readyzHandler(searchDB))
+  mux.HandleFunc("/metrics", metricsHandler)
+
+  registerAdminHandlers(mux)
+  registerIngestHandlers(mux, writerDB)
+  registerAnalyticsHandlers(mux, searchDB)
+  registerOpenSearchHandlers(mux, searchDB)
+  registerFeedHandlers(mux, searchDB, writerDB)
+  registerBackupHandlers(mux, searchDB)
+  registerStatsHandlers(mux, searchDB)
+  registerExportHandlers(mux, searchDB)
+
+  mux.HandleFunc("/", authHandler(staticFilesHandler(searchHandler(searchDB, searchForm))))
+
+  // Serve (until we are asked to shutdown), rebinding the listener
+  // whenever the Host, Port, TLS or timeout configuration changes.
+  //
+  isFirstListen := true
+  for {
+    serverFailed := make(chan error, 2)
+    servers, err := startWebServers(mux, serverFailed)
+    if err != nil {
+      serverFailed <- err
+    }
+
+    select {
+      case <-shutdownRequested :
+        shutdownServers(servers)
+        WebserverLog("finished")
+        return
+      case <-rebindRequested :
+        shutdownServers(servers)
+      case err := <-serverFailed :
+        WebserverMaybeError("could not listen", err)
+        shutdownServers(servers)
+        if isFirstListen {
+          requestShutdown()
+          return
+        }
+        // wait for a (hopefully better) configuration
+        select {
+          case <-shutdownRequested :
+            WebserverLog("finished")
+            return
+          case <-rebindRequested :
+        }
+    }
+    isFirstListen = false
+  }
+}
+
+// The search form (and its results). Only `/search/<query>` GETs (and
+// POSTs of the search form) are searches; any other path, such as the home
+// page, shows an empty search form.
+//
+func searchHandler(
+  searchDB *sql.DB, searchForm *webServerTemplate,
+) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
     WebserverLogf("url: [%s]", r.URL.Path)
+    queryStart := time.Now()
+    htmlDirs   := getConfigSnapshot().HtmlDirs
+    urlBase    := getConfigSnapshot().UrlBase
     userQuery  := ""
     maxNum := int(getConfigInt("Webserver.MaxNumResults", 100))
-    if r.Method == http.MethodGet {
-      userQuery = strings.Replace(r.URL.Path, "/search/", "", 1)
+    if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/search/") {
+      userQuery = strings.TrimPrefix(r.URL.Path, "/search/")
       newQuery, err := url.QueryUnescape(userQuery)
       if err == nil { userQuery = newQuery  }
     } else if r.Method == http.MethodPost {
       r.ParseForm()
       userQuery  = r.Form.Get("searchQueryStr")
       tmpMaxNum, err := strconv.Atoi(r.Form.Get("searchQueryNum"))
-      if err != nil { tmpMaxNum = maxNum }
-      maxNum = tmpMaxNum
+      if err == nil { maxNum = clampNumResults(tmpMaxNum, maxNum) }
+    }
+
+    if userQuery != "" && isVacuumBlockingReaders() {
+      writeDatabaseBusy(w)
+      return
+    }
+
+    if aFormat := r.URL.Query().Get("format"); isFeedFormat(aFormat) && userQuery != "" {
+      extendWriteDeadline(w)
+      serveSearchFeed(w, r, searchDB, aFormat, userQuery, maxNum)
+      return
     }
 
     sqlQuery := strings.Replace(userQuery, "'", "''", -1)
     WebserverLogf("query: [%s]", sqlQuery)
     var searchData SearchData
+    searchData.SiteName    = getSiteName()
+    searchData.Stylesheets = assetUrls("Webserver.Assets.Stylesheets")
+    searchData.Scripts     = assetUrls("Webserver.Assets.Scripts")
     searchData.Query  = userQuery
     searchData.MaxNum = maxNum
-    searchData.MaxNumRange = []int{10, 50, 100, 200}
+    searchData.MaxNumRange = numResultsRange(
+      int(getConfigInt("Webserver.MaxNumResults", 100)),
+    )
     if 0 < len(sqlQuery) {
-      results := make([]SearchResults, maxNum)
-      sqlCmd := "select filePath, fileTitle, bm25(pageSearch) from pageSearch('"+sqlQuery+"') order by rank;"
+      //
+      // if the results are re-ranked (by popularity or link score), we
+      // consider (at least) the best `Ranking.Candidates` results
+      //
+      numCandidates := maxNum
+      if isReranking() {
+        maxCandidates := rankingCandidates()
+        if numCandidates < maxCandidates { numCandidates = maxCandidates }
+      }
+      results := make([]SearchResults, numCandidates)
+      aclSql, aclArgs := aclCondition(
+        "documents.docPath", allowedPathPrefixes(getSearchUser(r)),
+      )
+      sqlCmd := `
+        select documents.docPath, documents.title, bm25(documentSearch),
+            documents.url, documents.docType
+          from documentSearch('`+sqlQuery+`')
+          join documents on documents.docId = documentSearch.rowid
+          where `+aclSql+`
+          order by documentSearch.rank;
+      `
       WebserverLogf("sqlCmdQuery: [%s]", sqlCmd)
-      rows, err := searchDB.Query(sqlCmd)
-      WebserverMaybeError("trying to search pageSearch table with query", err)
+      rows, err := searchDB.Query(sqlCmd, aclArgs...)
+      if isDatabaseBusy(err) {
+        WebserverMaybeError("the database is busy", err)
+        writeDatabaseBusy(w)
+        return
+      }
+      if err != nil {
+        // (most likely a syntax error in the query)
+        WebserverMaybeError("trying to search documentSearch table with query", err)
+        http.Error(w, "could not search for ["+userQuery+"]", http.StatusBadRequest)
+        return
+      }
       defer rows.Close()
       //
       numResults := 0
       for {
-        if maxNum <= numResults { break }
+        if numCandidates <= numResults { break }
         hasRow := rows.Next()
         if  !hasRow {
           err := rows.Err()
@@ -123,38 +320,53 @@ func runWebServer(cliHost string, cliPort int64) {
         var filePath string
         var title    string
         var rank     float64
-        err = rows.Scan(&filePath, &title, &rank)
+        var docUrl   sql.NullString
+        var docType  sql.NullString
+        err = rows.Scan(&filePath, &title, &rank, &docUrl, &docType)
         WebserverMaybeError("scanning filePath and title from results", err)
-        if _, err = os.Stat(filePath); err != nil { continue }
-        for _, anHtmlDir := range htmlDirs {
-          if strings.HasPrefix(filePath, anHtmlDir) {
-            results[numResults].FilePath =
-              strings.Replace(filePath, anHtmlDir, urlBase, 1)
-          }
+        if 0 < len(docUrl.String) {
+          //
+          // pushed (and crawled) documents provide their own url and type
+          //
+          results[numResults].FilePath  = docUrl.String
+          results[numResults].docPath   = filePath
+          results[numResults].Title     = title
+          results[numResults].relevance = -1 * rank
+          results[numResults].Type      = docType.String
+          numResults = numResults + 1
+          continue
         }
-        results[numResults].Title    = title
-        results[numResults].Rank     = strconv.FormatFloat(-1 * rank, 'f', 2, 64)
-        var resultType string
-        switch {
-          case strings.Contains(filePath, "blog")   : resultType = "B"
-          case strings.Contains(filePath, "author") : resultType = "A"
-          case strings.Contains(filePath, "cite")   : resultType = "C"
-          case strings.Contains(filePath, "tasks")  : resultType = "T"
-          default : resultType = " "
-        }
-        results[numResults].Type = resultType
+        if _, err = os.Stat(filePath); err != nil { continue }
+        results[numResults].FilePath  = fileUrl(filePath, htmlDirs, urlBase)
+        results[numResults].docPath   = filePath
+        results[numResults].Title     = title
+        results[numResults].relevance = -1 * rank
+        results[numResults].Type      = fileResultType(filePath)
         numResults = numResults + 1
       }
       rows.Close()
+      //
+      // re-rank (by popularity, link score and recency) and then link the best
+      // maxNum results
+      //
+      rerankResults(searchDB, results[:numResults], userQuery)
+      if maxNum < numResults { numResults = maxNum }
+      for i := 0 ; i < numResults ; i++ {
+        results[i].Rank = strconv.FormatFloat(results[i].relevance, 'f', 2, 64)
+        results[i].Link = results[i].FilePath
+        if isClickTrackingEnabled() {
+          results[i].Link = clickLink(results[i].docPath, userQuery, i+1)
+        }
+      }
       searchData.Results = results[:numResults]
+
+      observeSearchQuery(numResults, time.Since(queryStart))
+      recordSearch(userQuery, getSearchUser(r), numResults, time.Since(queryStart))
     } else {
       searchData.Results = []SearchResults{}
     }
 
-    err = searchForm.execute(w, searchData )
+    err := searchForm.execute(w, searchData )
     WebserverMaybeError("could not execute searchForm", err)
-  })
-
-  WebserverLogf("listening to %s:%s", host, port)
-  http.ListenAndServe(host+":"+port, nil)
+  }
 }
@@ -0,0 +1,205 @@
+package main
+
+/*
+
+  We serve a small number of static assets (the favicon, as well as any
+  stylesheets and scripts used by the search form) from the config
+  directory, so that the search form can be styled without an external
+  web server:
+
+    GET /favicon.ico        : `Webserver.Assets.Favicon`
+    GET /assets/<id>/<name> : one of the `Webserver.Assets.Stylesheets` or
+                              `Webserver.Assets.Scripts` (by file name,
+                              where the id is a hash of its path, so that
+                              assets with the same file name, in different
+                              directories, do not shadow each other)
+
+  The urls of the stylesheets and scripts are given to the search form
+  template (as .Stylesheets and .Scripts), each with a version (its ETag)
+  so that browsers fetch a changed asset immediately.
+
+  We implement a lazy reloading of each asset (just like the
+  webServerTemplate) so that assets can be changed outside the searcher.
+  TO DO THIS, we require a RWMutex. SEE: https://stackoverflow.com/a/19168242
+  for a good discussion on how to USE RWMutex's.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "log"
+  "mime"
+  "sync"
+  "time"
+  "bytes"
+  "strings"
+  "net/url"
+  "net/http"
+  "io/ioutil"
+  "hash/crc32"
+  "path/filepath"
+)
+
+type webServerAsset struct {
+  filePath  string
+  fileMTime time.Time
+  fileSize  int64
+  content   []byte
+  etag      string
+  update    sync.RWMutex
+}
+
+// All assets which have been loaded (by file path).
+//
+var webServerAssets       map[string]*webServerAsset = map[string]*webServerAsset{}
+var updateWebServerAssets sync.Mutex
+
+func getWebServerAsset(aFilePath string) *webServerAsset {
+  updateWebServerAssets.Lock()
+  defer updateWebServerAssets.Unlock()
+
+  wsa, isLoaded := webServerAssets[aFilePath]
+  if !isLoaded {
+    wsa = new(webServerAsset)
+    wsa.filePath = aFilePath
+    webServerAssets[aFilePath] = wsa
+  }
+  return wsa
+}
+
+func reloadWebServerAssets() {
+  updateWebServerAssets.Lock()
+  defer updateWebServerAssets.Unlock()
+
+  for _, wsa := range webServerAssets {
+    log.Printf("WebserverAsset(reload): forced reload of [%s]", wsa.filePath)
+    wsa.reloadAsset()
+  }
+}
+
+func (wsa *webServerAsset) hasAssetChanged() bool {
+  wsa.update.RLock()
+  defer wsa.update.RUnlock()
+
+  assetFileInfo, err := os.Stat(wsa.filePath)
+  if err != nil {
+    // the asset is (temporarily?) missing... keep the current content
+    return wsa.content == nil
+  }
+  if !wsa.fileMTime.Equal(assetFileInfo.ModTime()) ||
+     wsa.fileSize != assetFileInfo.Size() {
+    log.Printf("WebserverAsset(reload): file info changed for [%s]", wsa.filePath)
+    return true
+  }
+  return false
+}
+
+func (wsa *webServerAsset) reloadAsset() {
+  wsa.update.Lock()
+  defer wsa.update.Unlock()
+
+  assetFileInfo, err := os.Stat(wsa.filePath)
+  if err == nil {
+    var newContent []byte
+    newContent, err = ioutil.ReadFile(wsa.filePath)
+    if err == nil {
+      wsa.content   = newContent
+      wsa.fileMTime = assetFileInfo.ModTime()
+      wsa.fileSize  = assetFileInfo.Size()
+      wsa.etag      = fmt.Sprintf(`"%x-%x"`,
+        assetFileInfo.ModTime().UnixNano(), assetFileInfo.Size(),
+      )
+      log.Printf("WebserverAsset: loaded [%s]", wsa.filePath)
+      return
+    }
+  }
+  log.Printf(
+    "WebserverAsset: failed to load asset from [%s] ERROR: %s", wsa.filePath, err,
+  )
+}
+
+// The asset's current ETag (or "" if it could not be loaded).
+//
+func (wsa *webServerAsset) getETag() string {
+  if wsa.hasAssetChanged() { wsa.reloadAsset() }
+
+  wsa.update.RLock()
+  defer wsa.update.RUnlock()
+  return wsa.etag
+}
+
+func (wsa *webServerAsset) serve(w http.ResponseWriter, r *http.Request) {
+  if wsa.hasAssetChanged() { wsa.reloadAsset() }
+
+  wsa.update.RLock()
+  defer wsa.update.RUnlock()
+
+  if wsa.content == nil {
+    http.NotFound(w, r)
+    return
+  }
+  if contentType := mime.TypeByExtension(filepath.Ext(wsa.filePath)); contentType != "" {
+    w.Header().Set("Content-Type", contentType)
+  }
+  cacheSeconds := getConfigInt("Webserver.Assets.CacheSeconds", 3600)
+  w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", cacheSeconds))
+  w.Header().Set("ETag", wsa.etag)
+  http.ServeContent(
+    w, r, filepath.Base(wsa.filePath), wsa.fileMTime, bytes.NewReader(wsa.content),
+  )
+}
+
+// The (configured) stylesheets and scripts.
+//
+func getAssetPaths() []string {
+  return append(
+    getConfigAStr("Webserver.Assets.Stylesheets", []string{}),
+    getConfigAStr("Webserver.Assets.Scripts", []string{})...,
+  )
+}
+
+// The (unescaped) url path of a stylesheet or script.
+//
+func assetUrlPath(anAssetPath string) string {
+  assetId := crc32.ChecksumIEEE([]byte(filepath.Clean(anAssetPath)))
+  return fmt.Sprintf("/assets/%08x/", assetId) + filepath.Base(anAssetPath)
+}
+
+// The (versioned) urls of the configured stylesheets or scripts.
+//
+func assetUrls(configVarPath string) []string {
+  someUrls := []string{}
+  for _, anAssetPath := range getConfigAStr(configVarPath, []string{}) {
+    anAssetUrl := (&url.URL{ Path: assetUrlPath(anAssetPath) }).EscapedPath()
+    if anETag := getWebServerAsset(anAssetPath).getETag(); anETag != "" {
+      anAssetUrl = anAssetUrl + "?v=" + url.QueryEscape(strings.Trim(anETag, `"`))
+    }
+    someUrls = append(someUrls, anAssetUrl)
+  }
+  return someUrls
+}
+
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+  faviconPath := getConfigStr("Webserver.Assets.Favicon", "config/searcherFavicon.ico")
+  if faviconPath == "" {
+    http.NotFound(w, r)
+    return
+  }
+  getWebServerAsset(faviconPath).serve(w, r)
+}
+
+func assetsHandler(w http.ResponseWriter, r *http.Request) {
+  for _, anAssetPath := range getAssetPaths() {
+    if assetUrlPath(anAssetPath) == r.URL.Path {
+      getWebServerAsset(anAssetPath).serve(w, r)
+      return
+    }
+  }
+  http.NotFound(w, r)
+}
+
+func registerAssetHandlers(mux *http.ServeMux) {
+  mux.HandleFunc("/favicon.ico", faviconHandler)
+  mux.HandleFunc("/assets/", assetsHandler)
+}
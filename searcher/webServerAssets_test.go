@@ -0,0 +1,53 @@
+package main
+
+import (
+  "strings"
+  "testing"
+  "io/ioutil"
+  "path/filepath"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestAssetsWithTheSameFileName(t *testing.T) {
+  assetsDir := t.TempDir()
+  writeTestFiles(t, assetsDir, map[string]string{
+    "light/site.css" : "light css",
+    "dark/site.css"  : "dark css",
+    "site.js"        : "site js",
+  })
+  setTestConfig(t,
+    `Webserver.Assets.Stylesheets=["`+filepath.Join(assetsDir, "light", "site.css")+
+      `", "`+filepath.Join(assetsDir, "dark", "site.css")+`"]`,
+    `Webserver.Assets.Scripts=["`+filepath.Join(assetsDir, "site.js")+`"]`,
+  )
+
+  someUrls := append(
+    assetUrls("Webserver.Assets.Stylesheets"),
+    assetUrls("Webserver.Assets.Scripts")...,
+  )
+  wantBodies := []string{ "light css", "dark css", "site js" }
+  if len(someUrls) != len(wantBodies) {
+    t.Fatalf("got %d asset urls, want %d: %q", len(someUrls), len(wantBodies), someUrls)
+  }
+  for i, anAssetUrl := range someUrls {
+    aRecorder := httptest.NewRecorder()
+    assetsHandler(aRecorder, httptest.NewRequest("GET", anAssetUrl, nil))
+    aResponse := aRecorder.Result()
+    aBody, _ := ioutil.ReadAll(aResponse.Body)
+    if aResponse.StatusCode != http.StatusOK || string(aBody) != wantBodies[i] {
+      t.Errorf("GET %s = %d %q, want %d %q", anAssetUrl,
+        aResponse.StatusCode, aBody, http.StatusOK, wantBodies[i])
+    }
+  }
+
+  // an asset may not be requested by its file name alone
+  aRecorder := httptest.NewRecorder()
+  assetsHandler(aRecorder, httptest.NewRequest("GET", "/assets/site.css", nil))
+  if aRecorder.Code != http.StatusNotFound {
+    t.Errorf("GET /assets/site.css = %d, want %d", aRecorder.Code, http.StatusNotFound)
+  }
+  if strings.Split(someUrls[0], "?")[0] == strings.Split(someUrls[1], "?")[0] {
+    t.Errorf("stylesheets with the same file name share the url [%s]", someUrls[0])
+  }
+}
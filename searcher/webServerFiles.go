@@ -0,0 +1,151 @@
+package main
+
+/*
+
+  For small deployments, the webServer can serve the (indexed) static
+  files itself, so that a single searcher can host both the pages and
+  their search.
+
+  Search results link to a file by replacing its HtmlDir by the UrlBase,
+  so each of the HtmlDirs is mounted at the (path of the) UrlBase. A
+  request is mapped back to a file by looking for it in each of the
+  HtmlDirs (in order).
+
+  The search form ("/") and the search results ("/search/...") are never
+  treated as files, while any other (missing or hidden) path under the
+  mount path is not found.
+
+  Directories are served using their index file (if any), and files are
+  served with MIME types based upon their extension as well as
+  Cache-Control, ETag and Last-Modified headers (so that conditional and
+  range requests work).
+
+  Files are subject to the same access control (`Auth.ACL`) as the search
+  results, so a searcher can not fetch a file they could not find.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "mime"
+  "path"
+  "strings"
+  "net/url"
+  "net/http"
+  "path/filepath"
+)
+
+// The path at which the HtmlDirs are mounted.
+//
+func staticFilesMountPath(aUrlBase string) string {
+  mountPath := aUrlBase
+  if parsedUrl, err := url.Parse(aUrlBase); err == nil {
+    mountPath = parsedUrl.Path
+  }
+  return strings.TrimSuffix(mountPath, "/")
+}
+
+// Find the file (in one of the HtmlDirs) which corresponds to a request's
+// path. Returns the file's path and info, or an empty path if there is no
+// such file.
+//
+func findStaticFile(
+  requestPath string, mountPath string, htmlDirs []string,
+) (string, os.FileInfo) {
+  if !strings.HasPrefix(requestPath, mountPath+"/") { return "", nil }
+  relPath := path.Clean("/"+strings.TrimPrefix(requestPath, mountPath))
+
+  // never serve "hidden" files or directories
+  for _, aSegment := range strings.Split(relPath, "/") {
+    if strings.HasPrefix(aSegment, ".") { return "", nil }
+  }
+
+  for _, anHtmlDir := range htmlDirs {
+    filePath := filepath.Join(htmlDirRoot(anHtmlDir), filepath.FromSlash(relPath))
+    if fileInfo, err := os.Stat(filePath); err == nil {
+      return filePath, fileInfo
+    }
+  }
+  return "", nil
+}
+
+func serveStaticFile(
+  w http.ResponseWriter, r *http.Request, filePath string, fileInfo os.FileInfo,
+) {
+  aFile, err := os.Open(filePath)
+  if err != nil {
+    WebserverMaybeError("could not open static file", err)
+    http.NotFound(w, r)
+    return
+  }
+  defer aFile.Close()
+
+  if contentType := mime.TypeByExtension(filepath.Ext(filePath)); contentType != "" {
+    w.Header().Set("Content-Type", contentType)
+  }
+  cacheSeconds := getConfigInt("Webserver.StaticFiles.CacheSeconds", 3600)
+  w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", cacheSeconds))
+  w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`,
+    fileInfo.ModTime().UnixNano(), fileInfo.Size(),
+  ))
+  http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), aFile)
+}
+
+// Wrap the search handler so that requests for static files (if enabled)
+// are served from the HtmlDirs.
+//
+func staticFilesHandler(next http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    if !getConfigBool("Webserver.StaticFiles.Enabled", false) ||
+      (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+      r.URL.Path == "/" || strings.HasPrefix(r.URL.Path, "/search/") {
+      next(w, r)
+      return
+    }
+
+    theConfig := getConfigSnapshot()
+    filePath, fileInfo := findStaticFile(
+      r.URL.Path, staticFilesMountPath(theConfig.UrlBase), theConfig.HtmlDirs,
+    )
+    if filePath == "" {
+      //
+      // (a missing file is not a search)
+      //
+      if strings.HasPrefix(r.URL.Path, staticFilesMountPath(theConfig.UrlBase)+"/") {
+        http.NotFound(w, r)
+        return
+      }
+      next(w, r)
+      return
+    }
+    // (a directory is allowed by the prefixes which allow its files)
+    aclPath := filePath
+    if fileInfo.IsDir() { aclPath = filePath+string(filepath.Separator) }
+    if !isPathAllowed(aclPath, allowedPathPrefixes(getSearchUser(r))) {
+      http.NotFound(w, r)
+      return
+    }
+
+    if fileInfo.IsDir() {
+      if !strings.HasSuffix(r.URL.Path, "/") {
+        http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+        return
+      }
+      indexFiles := getConfigAStr(
+        "Webserver.StaticFiles.IndexFiles", []string{ "index.html" },
+      )
+      for _, anIndexFile := range indexFiles {
+        indexPath := filepath.Join(filePath, anIndexFile)
+        if indexInfo, err := os.Stat(indexPath); err == nil && !indexInfo.IsDir() {
+          serveStaticFile(w, r, indexPath, indexInfo)
+          return
+        }
+      }
+      http.NotFound(w, r)
+      return
+    }
+
+    serveStaticFile(w, r, filePath, fileInfo)
+  }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+  "os"
+  "context"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestStaticFilesHandler(t *testing.T) {
+  // (the HtmlDirs and ACL use relative paths)
+  wasDir, err := os.Getwd()
+  if err != nil { t.Fatal(err) }
+  if err := os.Chdir(t.TempDir()); err != nil { t.Fatal(err) }
+  t.Cleanup(func() { os.Chdir(wasDir) })
+  writeTestFiles(t, ".", map[string]string{
+    "site/public/a.html"       : "public a",
+    "site/public/index.html"   : "public index",
+    "site/public/.hidden.html" : "hidden",
+    "site/.git/config"         : "secret config",
+    "site/private/b.html"      : "private b",
+  })
+  setTestConfig(t,
+    "Webserver.StaticFiles.Enabled=true",
+    `HtmlDirs=["./site"]`,
+    `Auth.ACL={ "*" : [ "./site/public/" ], "user:alice" : [ "./site/" ] }`,
+  )
+  aHandler := staticFilesHandler(func(w http.ResponseWriter, r *http.Request) {
+    w.Write([]byte("search"))
+  })
+
+  someTests := []struct {
+    name       string
+    path       string
+    user       *searchUser
+    wantStatus int
+    wantBody   string
+  }{
+    { "public file",        "/public/a.html",       nil, http.StatusOK, "public a" },
+    { "index file",         "/public/",             nil, http.StatusOK, "public index" },
+    { "directory",          "/public",              nil, http.StatusMovedPermanently, "" },
+    { "restricted file",    "/private/b.html",      nil, http.StatusNotFound, "" },
+    { "allowed file",       "/private/b.html",
+      &searchUser{ name : "alice" }, http.StatusOK, "private b" },
+    { "hidden file",        "/public/.hidden.html", nil, http.StatusNotFound, "" },
+    { "hidden directory",   "/.git/config",
+      &searchUser{ name : "alice" }, http.StatusNotFound, "" },
+    { "escaping the root",  "/../site/private/b.html", nil, http.StatusNotFound, "" },
+    { "missing file",       "/missing.html",        nil, http.StatusNotFound, "" },
+    { "search form",        "/",                    nil, http.StatusOK, "search" },
+    { "search",             "/search/b",            nil, http.StatusOK, "search" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aRequest := httptest.NewRequest("GET", "/", nil)
+      aRequest.URL.Path = aTest.path
+      if aTest.user != nil {
+        aRequest = aRequest.WithContext(
+          context.WithValue(aRequest.Context(), searchUserKey{}, aTest.user),
+        )
+      }
+      aRecorder := httptest.NewRecorder()
+      aHandler(aRecorder, aRequest)
+      if aRecorder.Code != aTest.wantStatus {
+        t.Fatalf("got status %d, want %d", aRecorder.Code, aTest.wantStatus)
+      }
+      if aTest.wantBody != "" && aRecorder.Body.String() != aTest.wantBody {
+        t.Errorf("got body %q, want %q", aRecorder.Body.String(), aTest.wantBody)
+      }
+    })
+  }
+}
@@ -0,0 +1,261 @@
+package main
+
+/*
+
+  We (optionally) serve using TLS (and HTTP/2).
+
+  TLS is enabled whenever both `Webserver.TLS.CertFile` and
+  `Webserver.TLS.KeyFile` are configured.
+
+  We implement a lazy reloading of the certificate and key files (just like
+  the webServerTemplate) so that a renewed certificate is picked up without
+  restarting the searcher. TO DO THIS, we require a RWMutex. SEE:
+  https://stackoverflow.com/a/19168242 for a good discussion on how to USE
+  RWMutex's.
+
+  We can also (optionally) listen on a plain HTTP port and redirect every
+  request to HTTPS.
+
+  The WriteTimeout (`Webserver.Timeouts.WriteSeconds`) bounds the whole
+  response, which is too short for the responses which stream a lot of
+  data (such as `/admin/export`, backups and feeds). These "streaming"
+  handlers replace their write deadline with
+  `Webserver.Timeouts.StreamSeconds` (0 removes the deadline). This needs
+  a response writer with a SetWriteDeadline method (go 1.20 or later),
+  when built with an older go the WriteTimeout still applies.
+
+*/
+
+import (
+  "os"
+  "fmt"
+  "log"
+  "net"
+  "sync"
+  "time"
+  "strconv"
+  "net/http"
+  "crypto/tls"
+  "github.com/tidwall/gjson"
+)
+
+type webServerCertificate struct {
+  certFile      string
+  keyFile       string
+  certFileMTime int64
+  certFileSize  int64
+  keyFileMTime  int64
+  keyFileSize   int64
+  certificate   *tls.Certificate
+  update        sync.RWMutex
+}
+
+func CreateCertificate(aCertFile string, aKeyFile string) (*webServerCertificate, error) {
+  wsc := new(webServerCertificate)
+  wsc.certFile = aCertFile
+  wsc.keyFile  = aKeyFile
+  if err := wsc.reloadCertificate(); err != nil { return nil, err }
+  return wsc, nil
+}
+
+func fileMTimeAndSize(aPath string) (int64, int64) {
+  fileInfo, err := os.Stat(aPath)
+  if err != nil { return 0, 0 }
+  return fileInfo.ModTime().Unix(), fileInfo.Size()
+}
+
+func (wsc *webServerCertificate) hasCertificateChanged() bool {
+  wsc.update.RLock()
+  defer wsc.update.RUnlock()
+
+  certMTime, certSize := fileMTimeAndSize(wsc.certFile)
+  keyMTime,  keySize  := fileMTimeAndSize(wsc.keyFile)
+  if certMTime == 0 || keyMTime == 0 {
+    // (at least) one of the files is (temporarily?) missing... keep the
+    // current certificate
+    return false
+  }
+  if wsc.certFileMTime != certMTime || wsc.certFileSize != certSize ||
+     wsc.keyFileMTime  != keyMTime  || wsc.keyFileSize  != keySize {
+    log.Printf(
+      "WebserverCertificate(reload): file info changed for [%s] [%s]",
+      wsc.certFile, wsc.keyFile,
+    )
+    return true
+  }
+  return false
+}
+
+func (wsc *webServerCertificate) reloadCertificate() error {
+  wsc.update.Lock()
+  defer wsc.update.Unlock()
+
+  newCertificate, err := tls.LoadX509KeyPair(wsc.certFile, wsc.keyFile)
+  if err != nil {
+    log.Printf(
+      "WebserverCertificate: failed to load certificate from [%s] [%s] ERROR: %s",
+      wsc.certFile, wsc.keyFile, err,
+    )
+    return err
+  }
+  wsc.certificate = &newCertificate
+  wsc.certFileMTime, wsc.certFileSize = fileMTimeAndSize(wsc.certFile)
+  wsc.keyFileMTime,  wsc.keyFileSize  = fileMTimeAndSize(wsc.keyFile)
+  log.Printf("WebserverCertificate: loaded [%s] [%s]", wsc.certFile, wsc.keyFile)
+  return nil
+}
+
+// Used as the tls.Config's GetCertificate, so that the certificate is
+// (lazily) reloaded whenever it changes on disk.
+//
+func (wsc *webServerCertificate) getCertificate(
+  hello *tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+  if wsc.hasCertificateChanged() { wsc.reloadCertificate() }
+
+  wsc.update.RLock()
+  defer wsc.update.RUnlock()
+  return wsc.certificate, nil
+}
+
+func isTLSVersion(aValue gjson.Result) error {
+  if _, err := parseTLSVersion(aValue.String()); err != nil { return err }
+  return nil
+}
+
+func parseTLSVersion(aVersion string) (uint16, error) {
+  switch aVersion {
+    case "1.0" : return tls.VersionTLS10, nil
+    case "1.1" : return tls.VersionTLS11, nil
+    case "1.2" : return tls.VersionTLS12, nil
+    case "1.3" : return tls.VersionTLS13, nil
+  }
+  return 0, fmt.Errorf("must be one of 1.0, 1.1, 1.2 or 1.3")
+}
+
+func isTLSEnabled() bool {
+  return getConfigStr("Webserver.TLS.CertFile", "") != "" &&
+    getConfigStr("Webserver.TLS.KeyFile", "") != ""
+}
+
+// Everything which, when changed, requires the listeners to be rebound.
+//
+func listenerConfig(aConfig *configSnapshot) string {
+  return listenAddress(aConfig) +
+    gjson.Get(aConfig.raw, "Webserver.TLS").Raw +
+    gjson.Get(aConfig.raw, "Webserver.Timeouts").Raw
+}
+
+func configSeconds(configVarPath string, aDefault int64) time.Duration {
+  return time.Duration(getConfigInt(configVarPath, aDefault)) * time.Second
+}
+
+// Create the (main) http.Server with the configured timeouts and (if
+// enabled) TLS configuration.
+//
+func newWebServer(anAddress string, aHandler http.Handler) (*http.Server, error) {
+  server := &http.Server{
+    Addr              : anAddress,
+    Handler           : aHandler,
+    ReadTimeout       : configSeconds("Webserver.Timeouts.ReadSeconds", 30),
+    ReadHeaderTimeout : configSeconds("Webserver.Timeouts.HeaderSeconds", 10),
+    WriteTimeout      : configSeconds("Webserver.Timeouts.WriteSeconds", 60),
+    IdleTimeout       : configSeconds("Webserver.Timeouts.IdleSeconds", 120),
+  }
+  if !isTLSEnabled() { return server, nil }
+
+  certificate, err := CreateCertificate(
+    getConfigStr("Webserver.TLS.CertFile", ""),
+    getConfigStr("Webserver.TLS.KeyFile", ""),
+  )
+  if err != nil { return nil, err }
+  minVersion, err := parseTLSVersion(getConfigStr("Webserver.TLS.MinVersion", "1.2"))
+  if err != nil { return nil, err }
+  server.TLSConfig = &tls.Config{
+    MinVersion     : minVersion,
+    GetCertificate : certificate.getCertificate,
+  }
+  if !getConfigBool("Webserver.TLS.HTTP2", true) {
+    // an empty (non nil) map disables HTTP/2
+    server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+  }
+  return server, nil
+}
+
+// Replace the write deadline of a (long) streaming response. We use an
+// interface (rather than http.NewResponseController) so that the searcher
+// still builds with older versions of go.
+//
+func extendWriteDeadline(w http.ResponseWriter) {
+  aWriter, canExtend := w.(interface{ SetWriteDeadline(time.Time) error })
+  if !canExtend { return }
+  theDeadline := time.Time{}
+  if streamTime := configSeconds("Webserver.Timeouts.StreamSeconds", 3600); 0 < streamTime {
+    theDeadline = time.Now().Add(streamTime)
+  }
+  WebserverMaybeError(
+    "could not extend the write deadline", aWriter.SetWriteDeadline(theDeadline),
+  )
+}
+
+// Wrap a handler whose response may take longer than the WriteTimeout.
+//
+func streamingHandler(next http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    extendWriteDeadline(w)
+    next(w, r)
+  }
+}
+
+// Create an http.Server which redirects every request to the (HTTPS)
+// webServer.
+//
+func newRedirectServer(aConfig *configSnapshot) *http.Server {
+  redirectHost := aConfig.Host
+  if redirectHost == "" { redirectHost = "0.0.0.0" }
+  httpPort  := getConfigInt("Webserver.TLS.HTTPPort", 80)
+  httpsPort := strconv.FormatInt(aConfig.Port, 10)
+  return &http.Server{
+    Addr              : net.JoinHostPort(redirectHost, strconv.FormatInt(httpPort, 10)),
+    ReadHeaderTimeout : configSeconds("Webserver.Timeouts.HeaderSeconds", 10),
+    Handler : http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      host := r.Host
+      if aHost, _, err := net.SplitHostPort(r.Host); err == nil { host = aHost }
+      if httpsPort != "443" { host = net.JoinHostPort(host, httpsPort) }
+      http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+    }),
+  }
+}
+
+// Start listening using the main webServer (and, if required, the HTTP to
+// HTTPS redirect server). Any failure to listen is sent to serverFailed.
+//
+func startWebServers(
+  aHandler http.Handler, serverFailed chan error,
+) ([]*http.Server, error) {
+  theConfig := getConfigSnapshot()
+  server, err := newWebServer(listenAddress(theConfig), aHandler)
+  if err != nil { return nil, err }
+  servers := []*http.Server{ server }
+
+  if server.TLSConfig != nil {
+    go func() {
+      WebserverLogf("listening (TLS) to %s", server.Addr)
+      serverFailed <- server.ListenAndServeTLS("", "")
+    }()
+    if getConfigBool("Webserver.TLS.RedirectHTTP", false) {
+      redirectServer := newRedirectServer(theConfig)
+      servers = append(servers, redirectServer)
+      go func() {
+        WebserverLogf("redirecting %s to HTTPS", redirectServer.Addr)
+        serverFailed <- redirectServer.ListenAndServe()
+      }()
+    }
+  } else {
+    go func() {
+      WebserverLogf("listening to %s", server.Addr)
+      serverFailed <- server.ListenAndServe()
+    }()
+  }
+  return servers, nil
+}
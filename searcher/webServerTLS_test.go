@@ -0,0 +1,43 @@
+package main
+
+import (
+  "io"
+  "time"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestStreamingHandlerOutlivesTheWriteTimeout(t *testing.T) {
+  slowHandler := func(w http.ResponseWriter, r *http.Request) {
+    time.Sleep(1500 * time.Millisecond)
+    io.WriteString(w, "done")
+  }
+  someTests := []struct {
+    name     string
+    handler  http.HandlerFunc
+    wantBody string
+  }{
+    { "plain handler",     slowHandler,                   "" },
+    { "streaming handler", streamingHandler(slowHandler), "done" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      server := httptest.NewUnstartedServer(aTest.handler)
+      server.Config.WriteTimeout = time.Second
+      server.Start()
+      defer server.Close()
+
+      response, err := http.Get(server.URL)
+      theBody := ""
+      if err == nil {
+        someBytes, _ := io.ReadAll(response.Body)
+        response.Body.Close()
+        theBody = string(someBytes)
+      }
+      if theBody != aTest.wantBody {
+        t.Errorf("got body %q (error: %v), want %q", theBody, err, aTest.wantBody)
+      }
+    })
+  }
+}
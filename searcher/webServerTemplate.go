@@ -18,6 +18,12 @@ import (
   "html/template"
 )
 
+// All templates created by CreateTemplate, so that they can be forcibly
+// reloaded (see reloadWebServerTemplates).
+//
+var webServerTemplates      []*webServerTemplate
+var updateWebServerTemplates sync.Mutex
+
 type webServerTemplate struct {
   filePath  string
   fileMTime int64
@@ -37,7 +43,7 @@ func CreateTemplate(aTemplatePath string) *webServerTemplate {
   wst.fileSize  = 0
   newTemplate, err := template.ParseFiles(aTemplatePath)
   if err != nil {
-    log.Fatal(
+    log.Fatalf(
       "WebserverTemplate: could not load initial template [%s] ERROR: %s",
       aTemplatePath, err,
     )
@@ -52,9 +58,23 @@ func CreateTemplate(aTemplatePath string) *webServerTemplate {
 
   log.Printf("WebserverTemplate: loaded [%s]", wst.filePath)
 
+  updateWebServerTemplates.Lock()
+  defer updateWebServerTemplates.Unlock()
+  webServerTemplates = append(webServerTemplates, wst)
+
   return wst
 }
 
+func reloadWebServerTemplates() {
+  updateWebServerTemplates.Lock()
+  defer updateWebServerTemplates.Unlock()
+
+  for _, wst := range webServerTemplates {
+    log.Printf("WebserverTemplate(reload): forced reload of [%s]", wst.filePath)
+    wst.reloadTemplate()
+  }
+}
+
 func (wst *webServerTemplate) hasTemplateChanged() bool {
   wst.update.RLock()
   defer wst.update.RUnlock()
@@ -101,6 +121,32 @@ func (wst *webServerTemplate) reloadTemplate() {
   }
 }
 
+// Change the file from which this template is loaded. (If the new file can
+// not be loaded, the current template is kept.)
+//
+func (wst *webServerTemplate) changeFilePath(aTemplatePath string) {
+  newTemplate, err := template.ParseFiles(aTemplatePath)
+  if err != nil {
+    log.Printf(
+      "WebserverTemplate: failed to load template from [%s] ERROR: %s",
+      aTemplatePath, err,
+    )
+    return
+  }
+  templateFileInfo, err := os.Stat(aTemplatePath)
+
+  wst.update.Lock()
+  defer wst.update.Unlock()
+
+  wst.filePath = aTemplatePath
+  wst.template = newTemplate
+  if err == nil {
+    wst.fileMTime = templateFileInfo.ModTime().Unix()
+    wst.fileSize  = templateFileInfo.Size()
+  }
+  log.Printf("WebserverTemplate: loaded [%s]", wst.filePath)
+}
+
 func (wst *webServerTemplate) execute(wr io.Writer, data interface{}) error {
   if wst.hasTemplateChanged() { wst.reloadTemplate() }
 
@@ -0,0 +1,85 @@
+package main
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+)
+
+func TestClampNumResults(t *testing.T) {
+  someTests := []struct {
+    aNum   int
+    maxNum int
+    want   int
+  }{
+    { 10, 100, 10 }, { 100, 100, 100 }, { 101, 100, 100 },
+    { 1000000000, 100, 100 }, { 0, 100, 1 }, { -5, 100, 1 },
+  }
+  for _, aTest := range someTests {
+    if got := clampNumResults(aTest.aNum, aTest.maxNum); got != aTest.want {
+      t.Errorf("clampNumResults(%d, %d) = %d, want %d",
+        aTest.aNum, aTest.maxNum, got, aTest.want)
+    }
+  }
+}
+
+func TestNumResultsRange(t *testing.T) {
+  someTests := []struct {
+    maxNum int
+    want   []int
+  }{
+    { 5,   []int{ 5 } },
+    { 50,  []int{ 10, 50 } },
+    { 100, []int{ 10, 50, 100 } },
+    { 500, []int{ 10, 50, 100, 200, 500 } },
+  }
+  for _, aTest := range someTests {
+    if got := numResultsRange(aTest.maxNum); !reflect.DeepEqual(got, aTest.want) {
+      t.Errorf("numResultsRange(%d) = %v, want %v", aTest.maxNum, got, aTest.want)
+    }
+  }
+}
+
+func TestSearchHandler(t *testing.T) {
+  searchDB := openTestSearchDB(t)
+  err := upsertDocument(searchDB, indexedDocument{
+    path : "push:b", url : "https://example.com/b", title : "Badgers",
+    body : "all about badgers", source : pushSource,
+  })
+  if err != nil { t.Fatalf("could not insert a document: %s", err) }
+  aHandler := searchHandler(searchDB, CreateTemplate("../config/searchForm.html"))
+
+  someTests := []struct {
+    name      string
+    method    string
+    path      string
+    form      string
+    wantBody  string
+  }{
+    { "home page",     "GET",  "/",                 "", "Query: []" },
+    { "stray path",    "GET",  "/favicon.ico",      "", "Query: []" },
+    { "search url",    "GET",  "/search/badgers",   "", "Badgers" },
+    { "search form",   "POST", "/search/",
+      "searchQueryStr=badgers&searchQueryNum=10", "Badgers" },
+    { "no results",    "GET",  "/search/wombats",   "", "0 matches found" },
+  }
+  for _, aTest := range someTests {
+    t.Run(aTest.name, func(t *testing.T) {
+      aRequest := httptest.NewRequest(aTest.method, aTest.path, strings.NewReader(aTest.form))
+      if aTest.method == "POST" {
+        aRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+      }
+      aRecorder := httptest.NewRecorder()
+      aHandler(aRecorder, aRequest)
+      if aRecorder.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", aRecorder.Code, aRecorder.Body.String())
+      }
+      if !strings.Contains(aRecorder.Body.String(), aTest.wantBody) {
+        t.Errorf("expected the body to contain %q, got: %s",
+          aTest.wantBody, aRecorder.Body.String())
+      }
+    })
+  }
+}